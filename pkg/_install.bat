@@ -4,5 +4,44 @@ cd xmlx
 copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\document.go  .
 copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\entitymap.go .
 copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\node.go      .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\xpointer.go  .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\stylesheet.go .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\nodelist.go  .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\pool.go      .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\qname.go     .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\path.go      .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\extract.go   .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\router.go    .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\json.go      .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\stream.go    .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\saml.go      .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\ubl.go       .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\geo.go       .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\conref.go    .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\attrnorm.go  .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\unorm.go     .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\chars.go     .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\query.go     .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\bom.go       .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\css.go       .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\hash.go      .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\subdoc.go    .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\iter.go      .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\redact.go    .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\entitystats.go .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\codec.go     .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\batch.go     .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\typedjson.go .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\jsonml.go    .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\names.go     .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\spill.go     .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\builder.go   .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\profile.go   .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\match.go     .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\response.go  .
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\store.go     .
 go install
+md cmd\xmlx
+copy c:\c_portab\01_rb\_rbprogs\go-xmlx-rb\cmd\xmlx\main.go cmd\xmlx\
+go install .\cmd\xmlx
 pause
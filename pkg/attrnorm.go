@@ -0,0 +1,132 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "strings"
+)
+
+// normalizeAttr applies attribute-value normalization as described in
+// section 3.3.3 of the XML specification: white space characters become
+// spaces and, for all types but CDATA, leading and trailing spaces are
+// dropped and runs of spaces collapsed into one. Since encoding/xml has
+// already expanded character references, spaces written as &#10; or &#9;
+// are normalized as well.
+func (this *Document) normalizeAttr(elem, attr, value string) string {
+  value = strings.Map(func(r rune) rune {
+    switch r {
+    case '\t', '\n', '\r':
+      return ' '
+    }
+    return r
+  }, value)
+
+  if t := this.attrType(elem, attr); t != "" && t != "CDATA" {
+    value = strings.Join(strings.Fields(value), " ")
+  }
+  return value
+}
+
+// attrType returns the declared type of an attribute, looked up in AttrTypes
+// and in the ATTLIST declarations of the internal DTD subset. Enumerated
+// types are reported as "ENUMERATION".
+func (this *Document) attrType(elem, attr string) string {
+  key := elem + "@" + attr
+  if t, ok := this.AttrTypes[key]; ok {
+    return t
+  }
+  return this.dtdAttrTypes[key]
+}
+
+// readAttlists records the attribute types declared by the ATTLIST
+// declarations in the internal subset of a DOCTYPE directive.
+func (this *Document) readAttlists(doctype string) {
+  for {
+    i := strings.Index(doctype, "<!ATTLIST")
+    if i < 0 {
+      return
+    }
+    doctype = doctype[i+len("<!ATTLIST"):]
+
+    tokens, rest := dtdTokens(doctype)
+    doctype = rest
+    if len(tokens) < 1 {
+      continue
+    }
+
+    elem := localPart(tokens[0])
+    for j := 1; j+1 < len(tokens); j += 2 {
+      name, typ := localPart(tokens[j]), tokens[j+1]
+      if typ == "NOTATION" || strings.HasPrefix(typ, "(") {
+        if typ == "NOTATION" {
+          j++
+        }
+        typ = "ENUMERATION"
+      }
+      if this.dtdAttrTypes == nil {
+        this.dtdAttrTypes = make(map[string]string)
+      }
+      this.dtdAttrTypes[elem+"@"+name] = typ
+
+      // Skip the default declaration: #REQUIRED, #IMPLIED, a value or
+      // #FIXED followed by a value.
+      if j+2 < len(tokens) && tokens[j+2] == "#FIXED" {
+        j++
+      }
+      j++
+    }
+  }
+}
+
+// dtdTokens splits the body of a markup declaration up to its closing '>'
+// into tokens. Quoted strings and parenthesized groups are single tokens.
+// The text following the declaration is returned as well.
+func dtdTokens(s string) ([]string, string) {
+  tokens := make([]string, 0, 8)
+  start, depth := -1, 0
+  var quote byte
+
+  for i := 0; i < len(s); i++ {
+    c := s[i]
+    switch {
+    case quote != 0:
+      if c == quote {
+        quote = 0
+      }
+      continue
+    case c == '"' || c == '\'':
+      quote = c
+    case c == '(':
+      depth++
+    case c == ')':
+      depth--
+    case depth > 0:
+    case c == '>' || c == ' ' || c == '\t' || c == '\n' || c == '\r':
+      if start >= 0 {
+        tokens = append(tokens, s[start:i])
+        start = -1
+      }
+      if c == '>' {
+        return tokens, s[i+1:]
+      }
+      continue
+    }
+    if start < 0 {
+      start = i
+    }
+  }
+
+  if start >= 0 {
+    tokens = append(tokens, s[start:])
+  }
+  return tokens, ""
+}
+
+func localPart(name string) string {
+  if i := strings.IndexByte(name, ':'); i > -1 {
+    return name[i+1:]
+  }
+  return name
+}
@@ -0,0 +1,95 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "context"
+  "errors"
+  "io"
+  "runtime"
+  "sync"
+)
+
+// Input is a single source for ParseAll.
+type Input struct {
+  Reader  io.Reader   // Source to read; if nil, Path is loaded.
+  Path    string      // File to load when Reader is nil.
+  Charset CharsetFunc // Passed on to the Load functions.
+  Doc     *Document   // Document to load into, with its options; New() if nil.
+}
+
+// ParseAll loads the given inputs concurrently, with at most workers loads
+// running at once (runtime.NumCPU() if workers is not positive). The
+// documents and errors are returned in the order of the inputs; for every
+// input either the document or the error is set.
+//
+// Once ctx is done, inputs that have not been started yet are not loaded
+// and get ctx.Err() as their error. Loads already running are completed.
+func ParseAll(ctx context.Context, inputs []Input, workers int) ([]*Document, []error) {
+  docs := make([]*Document, len(inputs))
+  errs := make([]error, len(inputs))
+  if workers <= 0 {
+    workers = runtime.NumCPU()
+  }
+  if workers > len(inputs) {
+    workers = len(inputs)
+  }
+
+  next := make(chan int)
+  var wg sync.WaitGroup
+  for w := 0; w < workers; w++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      for i := range next {
+        if err := ctx.Err(); err != nil {
+          errs[i] = err
+          continue
+        }
+        docs[i], errs[i] = parseInput(&inputs[i])
+      }
+    }()
+  }
+
+  // select picks at random among ready cases, so ctx is checked before
+  // each send, and again by the worker that receives the input.
+  for i := range inputs {
+    if ctx.Err() == nil {
+      select {
+      case <-ctx.Done():
+      case next <- i:
+        continue
+      }
+    }
+    for j := i; j < len(inputs); j++ {
+      errs[j] = ctx.Err()
+    }
+    break
+  }
+  close(next)
+  wg.Wait()
+  return docs, errs
+}
+
+func parseInput(in *Input) (*Document, error) {
+  doc := in.Doc
+  if doc == nil {
+    doc = New()
+  }
+
+  var err error
+  switch {
+  case in.Reader != nil:
+    err = doc.LoadStream(in.Reader, in.Charset)
+  case in.Path != "":
+    err = doc.LoadFile(in.Path, in.Charset)
+  default:
+    err = errors.New("xmlx: input has neither Reader nor Path")
+  }
+  if err != nil {
+    return nil, err
+  }
+  return doc, nil
+}
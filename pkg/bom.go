@@ -0,0 +1,57 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "bufio"
+  "io"
+  "strings"
+  "unicode/utf16"
+)
+
+const utf8BOM = "\xef\xbb\xbf"
+
+// skipBOM returns a reader that drops a leading UTF-8 byte order mark, which
+// encoding/xml would otherwise report as text before the root element.
+func skipBOM(r io.Reader) io.Reader {
+  br := bufio.NewReader(r)
+  if b, err := br.Peek(len(utf8BOM)); err == nil && string(b) == utf8BOM {
+    br.Discard(len(utf8BOM))
+  }
+  return br
+}
+
+// encodeOutput converts serialized output, which is UTF-8, to the encoding
+// named by Document.Encoding. For "UTF-16" the output is written little
+// endian and always starts with a byte order mark, as the XML specification
+// requires; "UTF-16LE" and "UTF-16BE" only get one if SaveBOM is set, as
+// does UTF-8. Other encodings are written as they are. It is only applied
+// to byte output (SaveBytes, SaveFile, SaveStream); strings stay UTF-8.
+func (this *Document) encodeOutput(b []byte) []byte {
+  enc := strings.ToUpper(this.Encoding)
+  switch enc {
+  case "UTF-16", "UTF-16LE", "UTF-16BE":
+  default:
+    if this.SaveBOM && (enc == "" || enc == "UTF-8") {
+      return append([]byte(utf8BOM), b...)
+    }
+    return b
+  }
+
+  units := utf16.Encode([]rune(string(b)))
+  if enc == "UTF-16" || this.SaveBOM {
+    units = append([]uint16{0xFEFF}, units...)
+  }
+
+  out := make([]byte, 0, 2*len(units))
+  for _, u := range units {
+    if enc == "UTF-16BE" {
+      out = append(out, byte(u>>8), byte(u))
+    } else {
+      out = append(out, byte(u), byte(u>>8))
+    }
+  }
+  return out
+}
@@ -0,0 +1,212 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "encoding/xml"
+  "errors"
+  "fmt"
+  "strconv"
+)
+
+// Builder constructs a tree one call at a time, without NewNode and
+// AddChild plumbing:
+//
+//   b := doc.Build().NS("p", "urn:prices")
+//   b.Elem("order").Attr("id", "1").
+//     Elem("item").Text("x").End().
+//     AttrNS("urn:prices", "currency", "EUR").
+//     End()
+//   if err := b.Err(); err != nil { ... }
+//
+// Elem opens an element in the namespace of the element it is added to;
+// ElemNS gives the namespace URI. Namespace declarations are added where
+// they are first needed: element namespaces become the default namespace
+// unless a prefix was registered with NS, attribute namespaces always get a
+// prefix. Names keep the namespace URI in Name.Space.
+//
+// The first error (e.g. an empty name, or End with no element open) stops
+// the builder; it is returned by Err and all further calls do nothing.
+type Builder struct {
+  start    *Node             // Node the builder was started on.
+  cur      *Node             // Node new nodes are added to.
+  prefixes map[string]string // Prefixes registered with NS, by URI.
+  err      error
+}
+
+// Build returns a builder adding nodes to the document, at the top level.
+func (this *Document) Build() *Builder {
+  if this.Root == nil {
+    this.Root = NewNode(NT_ROOT)
+  }
+  return this.Root.Build()
+}
+
+// Build returns a builder adding nodes as children of this node.
+func (this *Node) Build() *Builder {
+  return &Builder{start: this, cur: this, prefixes: make(map[string]string)}
+}
+
+// NS registers the prefix to declare for the namespace uri when it is
+// first used.
+func (this *Builder) NS(prefix, uri string) *Builder {
+  if this.err == nil {
+    this.prefixes[uri] = prefix
+  }
+  return this
+}
+
+// Elem opens a new element, in the same namespace as the current one, and
+// makes it the current element.
+func (this *Builder) Elem(name string) *Builder {
+  uri := ""
+  if this.cur.Type == NT_ELEMENT {
+    uri = this.cur.NamespaceURI()
+  }
+  return this.ElemNS(uri, name)
+}
+
+// ElemNS opens a new element in the namespace uri ("" for none) and makes
+// it the current element.
+func (this *Builder) ElemNS(uri, name string) *Builder {
+  if this.err != nil {
+    return this
+  }
+  if name == "" {
+    this.err = errors.New("xmlx: builder: empty element name")
+    return this
+  }
+
+  t := NewNode(NT_ELEMENT)
+  t.Name = xml.Name{Space: uri, Local: name}
+  this.cur.AddChild(t)
+  this.cur = t
+
+  ctx := t.NamespaceContext()
+  switch {
+  case uri == "":
+    if ctx[""] != "" {
+      t.setNamespaceDecl("", "")
+    }
+  case this.bound(ctx, uri):
+  default:
+    if prefix, ok := this.prefixes[uri]; ok && prefix != "" {
+      t.addNamespaceDecl(prefix, uri)
+    } else {
+      t.setNamespaceDecl("", uri)
+    }
+  }
+  return this
+}
+
+// bound reports whether uri is already bound in ctx, to the prefix
+// registered for it if there is one.
+func (this *Builder) bound(ctx map[string]string, uri string) bool {
+  prefix, ok := lookupPrefix(ctx, uri)
+  if !ok {
+    return false
+  }
+  want, reg := this.prefixes[uri]
+  return !reg || want == prefix
+}
+
+// Attr sets an attribute without namespace on the current element.
+func (this *Builder) Attr(name, value string) *Builder {
+  return this.AttrNS("", name, value)
+}
+
+// AttrNS sets an attribute in the namespace uri on the current element,
+// declaring a prefix for it if none is in scope.
+func (this *Builder) AttrNS(uri, name, value string) *Builder {
+  if this.err != nil {
+    return this
+  }
+  if this.cur.Type != NT_ELEMENT {
+    this.err = fmt.Errorf("xmlx: builder: attribute %s outside of an element", name)
+    return this
+  }
+  if name == "" {
+    this.err = errors.New("xmlx: builder: empty attribute name")
+    return this
+  }
+
+  if uri != "" && this.attrPrefix(uri) == "" {
+    prefix := this.prefixes[uri]
+    ctx := this.cur.NamespaceContext()
+    for i := 1; prefix == "" || (ctx[prefix] != "" && ctx[prefix] != uri); i++ {
+      prefix = "ns" + strconv.Itoa(i)
+    }
+    this.cur.addNamespaceDecl(prefix, uri)
+  }
+  this.cur.SetAttrNS(uri, name, value)
+  return this
+}
+
+// attrPrefix returns a prefix bound to uri in scope of the current element,
+// or "" if there is none.
+func (this *Builder) attrPrefix(uri string) string {
+  ctx := this.cur.NamespaceContext()
+  if prefix, ok := this.prefixes[uri]; ok && prefix != "" && ctx[prefix] == uri {
+    return prefix
+  }
+  for prefix, u := range ctx {
+    if prefix != "" && u == uri {
+      return prefix
+    }
+  }
+  return ""
+}
+
+// Text adds a text node to the current element.
+func (this *Builder) Text(s string) *Builder {
+  return this.add(NT_TEXT, s)
+}
+
+// CData adds a text node written as a CDATA section.
+func (this *Builder) CData(s string) *Builder {
+  if this.add(NT_TEXT, s); this.err == nil {
+    this.cur.Children[len(this.cur.Children)-1].Hints |= HINT_CDATA
+  }
+  return this
+}
+
+// Comment adds a comment to the current element.
+func (this *Builder) Comment(s string) *Builder {
+  return this.add(NT_COMMENT, s)
+}
+
+func (this *Builder) add(typ NodeType, s string) *Builder {
+  if this.err != nil {
+    return this
+  }
+  t := NewNode(typ)
+  t.Value = s
+  this.cur.AddChild(t)
+  return this
+}
+
+// End closes the current element, making its parent the current one.
+func (this *Builder) End() *Builder {
+  if this.err != nil {
+    return this
+  }
+  if this.cur == this.start {
+    this.err = errors.New("xmlx: builder: End without an open element")
+    return this
+  }
+  this.cur = this.cur.Parent
+  return this
+}
+
+// Node returns the current node: the element last opened and not yet
+// closed, or the node the builder was started on.
+func (this *Builder) Node() *Node {
+  return this.cur
+}
+
+// Err returns the first error met while building, if any.
+func (this *Builder) Err() error {
+  return this.err
+}
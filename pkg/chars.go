@@ -0,0 +1,130 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "fmt"
+  "io"
+  "unicode/utf8"
+)
+
+// charFilter finds characters XML 1.0 does not allow: C0 control characters
+// other than tab, newline and carriage return, U+FFFE, U+FFFF and UTF-8
+// encoded surrogates. Depending on the mode they are removed or replaced by
+// U+FFFD, or only reported. The input must be UTF-8 or another ASCII
+// compatible encoding; bytes that are not valid UTF-8 are passed on as they
+// are, for the decoder or charset reader to deal with.
+type charFilter struct {
+  r      io.Reader
+  mode   int
+  report func(error)
+  line   int
+  pend   []byte // Incomplete sequence at the end of the last chunk.
+  out    []byte // Filtered bytes not yet handed out.
+  err    error
+}
+
+func newCharFilter(r io.Reader, mode int, report func(error)) *charFilter {
+  return &charFilter{r: r, mode: mode, report: report, line: 1}
+}
+
+func (this *charFilter) Read(p []byte) (int, error) {
+  for len(this.out) == 0 {
+    if this.err != nil {
+      return 0, this.err
+    }
+    buf := make([]byte, 4096)
+    n, err := this.r.Read(buf)
+    this.err = err
+    data := append(this.pend, buf[:n]...)
+    this.pend = nil
+    this.out = this.filter(data, err != nil)
+  }
+
+  n := copy(p, this.out)
+  this.out = this.out[n:]
+  return n, nil
+}
+
+// filter processes a chunk of input. Unless final is set, a sequence cut
+// off at the end of the chunk is kept back for the next one.
+func (this *charFilter) filter(data []byte, final bool) []byte {
+  out := make([]byte, 0, len(data))
+
+  for i := 0; i < len(data); {
+    c := data[i]
+    if c < utf8.RuneSelf {
+      if c == '\n' {
+        this.line++
+      }
+      if c < 0x20 && c != '\t' && c != '\n' && c != '\r' {
+        out = this.bad(out, rune(c))
+      } else {
+        out = append(out, c)
+      }
+      i++
+      continue
+    }
+
+    if !final && (!utf8.FullRune(data[i:]) || (c == 0xED && len(data)-i < 3)) {
+      this.pend = append([]byte(nil), data[i:]...)
+      break
+    }
+
+    // Surrogates are not valid UTF-8, but some encoders write them anyway.
+    if c == 0xED && i+2 < len(data) && data[i+1] >= 0xA0 && data[i+1] <= 0xBF && data[i+2]&0xC0 == 0x80 {
+      r := rune(c&0x0F)<<12 | rune(data[i+1]&0x3F)<<6 | rune(data[i+2]&0x3F)
+      out = this.bad(out, r)
+      i += 3
+      continue
+    }
+
+    r, size := utf8.DecodeRune(data[i:])
+    if r == 0xFFFE || r == 0xFFFF {
+      out = this.bad(out, r)
+    } else {
+      out = append(out, data[i:i+size]...)
+    }
+    i += size
+  }
+
+  return out
+}
+
+func (this *charFilter) bad(out []byte, r rune) []byte {
+  switch this.mode {
+  case BADCHAR_STRIP:
+    this.report(fmt.Errorf("xmlx: line %d: removed invalid character U+%04X", this.line, r))
+    return out
+  case BADCHAR_REPLACE:
+    this.report(fmt.Errorf("xmlx: line %d: replaced invalid character U+%04X", this.line, r))
+    return append(out, "�"...)
+  }
+  this.report(fmt.Errorf("xmlx: line %d: invalid character U+%04X", this.line, r))
+  return append(out, string(r)...)
+}
+
+// checkChars applies the BadChars policy of the document to serialized
+// output. With BADCHAR_ERROR the first invalid character is returned as
+// error; otherwise the filtered output is returned and the changes are
+// added to Warnings.
+func (this *Document) checkChars(b []byte) ([]byte, error) {
+  var first error
+  f := newCharFilter(nil, this.BadChars, func(err error) {
+    if this.BadChars == BADCHAR_ERROR {
+      if first == nil {
+        first = err
+      }
+      return
+    }
+    this.Warnings = append(this.Warnings, err)
+  })
+
+  b = f.filter(b, true)
+  if first != nil {
+    return nil, first
+  }
+  return b, nil
+}
@@ -0,0 +1,177 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+// Command xmlx is a small command line front end for the xmlx package.
+//
+// Usage:
+//
+//   xmlx query <path-expr> [file ...]   print the nodes matching path-expr
+//   xmlx fmt [-indent str] [file ...]   re-serialize documents
+//   xmlx validate [-xsd file] [file ...] check documents for well-formedness
+//   xmlx tojson [file ...]              convert documents to JSON
+//
+// Every command reads standard input when no files are given. A path
+// expression is a '/' separated list of [prefix:]name steps, where '*'
+// matches any name. A leading '/' anchors the first step at the document
+// root; otherwise the first step is searched for recursively.
+package main
+
+import (
+  "encoding/json"
+  "errors"
+  "flag"
+  "fmt"
+  "io"
+  "os"
+  "strings"
+
+  "bar8tl/p/xmlx"
+)
+
+const usage = `usage: xmlx <command> [arguments]
+
+commands:
+  query <path-expr> [file ...]
+  fmt [-indent str] [file ...]
+  validate [-xsd file] [file ...]
+  tojson [file ...]
+`
+
+func main() {
+  if len(os.Args) < 2 {
+    fmt.Fprint(os.Stderr, usage)
+    os.Exit(2)
+  }
+
+  var err error
+  switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+  case "query":
+    err = runQuery(args)
+  case "fmt":
+    err = runFmt(args)
+  case "validate":
+    err = runValidate(args)
+  case "tojson":
+    err = runToJSON(args)
+  default:
+    fmt.Fprint(os.Stderr, usage)
+    os.Exit(2)
+  }
+
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "xmlx %s: %s\n", os.Args[1], err)
+    os.Exit(1)
+  }
+}
+
+// eachDocument loads every named file, or standard input if there are none,
+// and hands the result to fn.
+func eachDocument(files []string, fn func(name string, doc *xmlx.Document) error) error {
+  if len(files) == 0 {
+    doc := xmlx.New()
+    if err := doc.LoadStream(os.Stdin, nil); err != nil {
+      return fmt.Errorf("<stdin>: %s", err)
+    }
+    return fn("<stdin>", doc)
+  }
+
+  for _, name := range files {
+    doc := xmlx.New()
+    if err := doc.LoadFile(name, nil); err != nil {
+      return fmt.Errorf("%s: %s", name, err)
+    }
+    if err := fn(name, doc); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+func runQuery(args []string) error {
+  if len(args) < 1 {
+    return errors.New("missing path expression")
+  }
+  expr := args[0]
+
+  return eachDocument(args[1:], func(name string, doc *xmlx.Document) error {
+    for _, n := range query(doc.Root, expr) {
+      if len(n.Children) == 1 && n.Children[0].Type == xmlx.NT_TEXT {
+        fmt.Println(n.GetValue())
+      } else {
+        fmt.Println(n.String())
+      }
+    }
+    return nil
+  })
+}
+
+// query evaluates a simple path expression against the given node.
+func query(root *xmlx.Node, expr string) []*xmlx.Node {
+  recursive := !strings.HasPrefix(expr, "/")
+  steps := strings.Split(strings.Trim(expr, "/"), "/")
+
+  list := []*xmlx.Node{root}
+  for i, step := range steps {
+    ns, name := "*", step
+    if j := strings.IndexByte(step, ':'); j > -1 {
+      ns, name = step[:j], step[j+1:]
+    }
+
+    next := make([]*xmlx.Node, 0, len(list))
+    for _, n := range list {
+      var found []*xmlx.Node
+      if i == 0 && recursive {
+        found = n.SelectNodesRecursive(ns, name)
+      } else {
+        found = n.SelectNodes(ns, name)
+      }
+      next = append(next, found...)
+    }
+    list = next
+  }
+
+  return list
+}
+
+func runFmt(args []string) error {
+  fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+  indent := fs.String("indent", "  ", "indentation for a single level")
+  fs.Parse(args)
+
+  xmlx.IndentPrefix = *indent
+  return eachDocument(fs.Args(), func(name string, doc *xmlx.Document) error {
+    if err := doc.SaveStream(os.Stdout); err != nil {
+      return err
+    }
+    _, err := io.WriteString(os.Stdout, "\n")
+    return err
+  })
+}
+
+func runValidate(args []string) error {
+  fs := flag.NewFlagSet("validate", flag.ExitOnError)
+  xsd := fs.String("xsd", "", "XML schema to validate against")
+  fs.Parse(args)
+
+  if *xsd != "" {
+    return errors.New("XSD validation is not supported yet")
+  }
+
+  return eachDocument(fs.Args(), func(name string, doc *xmlx.Document) error {
+    if err := doc.Validate(); err != nil {
+      return fmt.Errorf("%s: %s", name, err)
+    }
+    fmt.Printf("%s: ok\n", name)
+    return nil
+  })
+}
+
+func runToJSON(args []string) error {
+  return eachDocument(args, func(name string, doc *xmlx.Document) error {
+    enc := json.NewEncoder(os.Stdout)
+    enc.SetIndent("", "  ")
+    enc.SetEscapeHTML(false)
+    return enc.Encode(doc.Root.JSONValue())
+  })
+}
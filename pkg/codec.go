@@ -0,0 +1,167 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "bytes"
+  "compress/gzip"
+  "encoding/base64"
+  "encoding/xml"
+  "fmt"
+  "io/ioutil"
+  "strings"
+)
+
+// Codec converts the text of an element between the form it has in the
+// document and the form the program works with, e.g. base64 encoded binary
+// data. Codecs are registered in Document.Codecs by the name of the elements
+// they apply to: the local name for elements in no namespace, or
+// "{uri}local" for elements in a namespace. Decode is applied when the
+// document is loaded and Encode when it is saved, so the tree always holds
+// the decoded values.
+//
+// Codecs only apply to elements without child elements. Their text is joined
+// into a single text node on load, placed after any comments and processing
+// instructions, which are kept. Node.String and Node.Bytes do not encode;
+// use the Document Save functions.
+type Codec interface {
+  Decode(s string) (string, error)
+  Encode(s string) (string, error)
+}
+
+// Base64Codec decodes standard base64 encoded text, ignoring whitespace, as
+// xs:base64Binary allows.
+var Base64Codec Codec = base64Codec{}
+
+// GzipCodec decodes base64 encoded, gzip compressed text.
+var GzipCodec Codec = gzipCodec{}
+
+type base64Codec struct{}
+
+func (base64Codec) Decode(s string) (string, error) {
+  b, err := base64.StdEncoding.DecodeString(stripSpace(s))
+  return string(b), err
+}
+
+func (base64Codec) Encode(s string) (string, error) {
+  return base64.StdEncoding.EncodeToString([]byte(s)), nil
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Decode(s string) (string, error) {
+  b, err := base64.StdEncoding.DecodeString(stripSpace(s))
+  if err != nil {
+    return "", err
+  }
+  r, err := gzip.NewReader(bytes.NewReader(b))
+  if err != nil {
+    return "", err
+  }
+  b, err = ioutil.ReadAll(r)
+  return string(b), err
+}
+
+func (gzipCodec) Encode(s string) (string, error) {
+  var buf bytes.Buffer
+  w := gzip.NewWriter(&buf)
+  if _, err := w.Write([]byte(s)); err != nil {
+    return "", err
+  }
+  if err := w.Close(); err != nil {
+    return "", err
+  }
+  return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func stripSpace(s string) string {
+  return strings.Map(func(r rune) rune {
+    if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+      return -1
+    }
+    return r
+  }, s)
+}
+
+// decodeElement applies the codec registered for n, if any, as n is closed
+// during a load.
+func (this *Document) decodeElement(xp *xml.Decoder, n *Node) error {
+  c, ok := codecFor(this.Codecs, n)
+  if !ok || n.ChildElementCount() > 0 {
+    return nil
+  }
+
+  val, err := c.Decode(codecText(n))
+  if err != nil {
+    line, _ := xp.InputPos()
+    return fmt.Errorf("xmlx: line %d: <%s>: %s", line, n.Name.Local, err)
+  }
+
+  keep := n.Children[:0]
+  for _, v := range n.Children {
+    if !v.IsText() {
+      keep = append(keep, v)
+    }
+  }
+  n.Children = keep
+
+  t := this.newNode(NT_TEXT)
+  t.Value = val
+  n.AddChild(t)
+  return nil
+}
+
+// codecFor returns the codec registered for element n, if any.
+func codecFor(codecs map[string]Codec, n *Node) (Codec, bool) {
+  if n.Type != NT_ELEMENT {
+    return nil, false
+  }
+  key := n.Name.Local
+  if uri := n.NamespaceURI(); uri != "" {
+    key = "{" + uri + "}" + key
+  }
+  c, ok := codecs[key]
+  return c, ok
+}
+
+// codecText returns the text held by n, as given to a codec.
+func codecText(n *Node) string {
+  var buf bytes.Buffer
+  for _, v := range n.Children {
+    if v.IsText() {
+      buf.WriteString(v.Value)
+    }
+  }
+  return buf.String()
+}
+
+// printEncoded writes the content and end tag of n, at the given depth, with
+// its text encoded by the codec registered for it, and reports whether it
+// did. Comments and processing instructions are written before the text.
+// Elements with child elements are left to printElement.
+func (p *printer) printEncoded(n *Node, depth int) bool {
+  c, ok := codecFor(p.codecs, n)
+  if !ok || n.ChildElementCount() > 0 {
+    return false
+  }
+
+  val, err := c.Encode(codecText(n))
+  if err != nil {
+    p.err = fmt.Errorf("xmlx: <%s>: %s", n.Name.Local, err)
+    return true
+  }
+
+  p.WriteRune('>')
+  for _, v := range n.Children {
+    if !v.IsText() {
+      p.print(v, depth+1)
+    }
+  }
+  xml.EscapeText(p, []byte(val))
+  p.WriteString("</")
+  p.WriteString(n.QualifiedName())
+  p.WriteRune('>')
+  return true
+}
@@ -0,0 +1,194 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "fmt"
+  "strings"
+)
+
+// ConrefResolver expands content references, as used by DITA's conref
+// attribute and similar include mechanisms. An element carrying one of the
+// reference attributes gets a copy of the content of the element the
+// reference points to; its own children are discarded.
+//
+// References have the form "uri#pointer", "#pointer" or "uri". The pointer
+// is an XPointer as accepted by ResolvePointer, or a DITA style
+// "topicid/elementid" pair. A bare uri addresses the document element of
+// that document.
+type ConrefResolver struct {
+  // Names of the reference attributes. Defaults to "conref".
+  Attrs []string
+
+  // Loads the documents referenced by URI. If nil, only references within
+  // the document being resolved can be followed.
+  Load func(uri string) (*Document, error)
+
+  docs  map[string]*Document // Loaded documents by URI; "" is the main one.
+  uris  map[*Node]string     // URI of each document, keyed by its root.
+  state map[*Node]int        // Resolution state of nodes, see below.
+}
+
+const (
+  conrefActive = 1 // Being resolved; reaching it again means a cycle.
+  conrefDone   = 2
+)
+
+// Resolve expands all references in doc, including references found in the
+// content that is pulled in. Referenced content is resolved before it is
+// copied. Attributes of the target that the referencing element does not
+// have are copied as well, except for IDs. The reference attribute itself is
+// removed. An error is returned for references that cannot be resolved and
+// for references that (indirectly) include themselves.
+func (this *ConrefResolver) Resolve(doc *Document) error {
+  if doc.Root == nil {
+    return nil
+  }
+  if len(this.Attrs) == 0 {
+    this.Attrs = []string{"conref"}
+  }
+
+  this.docs = map[string]*Document{"": doc}
+  this.uris = map[*Node]string{doc.Root: ""}
+  this.state = make(map[*Node]int)
+  defer func() { this.docs, this.uris, this.state = nil, nil, nil }()
+
+  return this.resolve(doc.Root)
+}
+
+func (this *ConrefResolver) resolve(cn *Node) error {
+  switch this.state[cn] {
+  case conrefDone:
+    return nil
+  case conrefActive:
+    return fmt.Errorf("xmlx: conref cycle at %s", describeNode(cn))
+  }
+  this.state[cn] = conrefActive
+
+  if attr := this.reference(cn); attr != nil {
+    target, err := this.lookup(cn, attr.value())
+    if err != nil {
+      return err
+    }
+    if err = this.resolve(target); err != nil {
+      return fmt.Errorf("%s (via %s=%q)", err, attr.Name.Local, attr.value())
+    }
+    this.expand(cn, attr, target)
+  } else {
+    for _, v := range cn.Children {
+      if err := this.resolve(v); err != nil {
+        return err
+      }
+    }
+  }
+
+  this.state[cn] = conrefDone
+  return nil
+}
+
+// reference returns the reference attribute of cn, if any.
+func (this *ConrefResolver) reference(cn *Node) *Attr {
+  if cn.Type != NT_ELEMENT {
+    return nil
+  }
+  for _, a := range cn.Attributes {
+    if a.Name.Space != "" {
+      continue
+    }
+    for _, name := range this.Attrs {
+      if a.Name.Local == name {
+        return a
+      }
+    }
+  }
+  return nil
+}
+
+// lookup finds the element ref points to. Fragment only references are
+// resolved against the document cn belongs to.
+func (this *ConrefResolver) lookup(cn *Node, ref string) (*Node, error) {
+  uri, ptr := ref, ""
+  if i := strings.Index(ref, "#"); i > -1 {
+    uri, ptr = ref[:i], ref[i+1:]
+  }
+
+  if uri == "" {
+    root := cn
+    for root.Parent != nil {
+      root = root.Parent
+    }
+    uri = this.uris[root]
+  }
+
+  doc, ok := this.docs[uri]
+  if !ok {
+    if this.Load == nil {
+      return nil, fmt.Errorf("xmlx: conref %q: external references need a Load function", ref)
+    }
+    var err error
+    if doc, err = this.Load(uri); err != nil {
+      return nil, fmt.Errorf("xmlx: conref %q: %s", ref, err)
+    }
+    if doc.Root == nil {
+      return nil, fmt.Errorf("xmlx: conref %q: document is empty", ref)
+    }
+    this.docs[uri] = doc
+    this.uris[doc.Root] = uri
+  }
+
+  if ptr == "" {
+    if n := doc.documentElement(); n != nil {
+      return n, nil
+    }
+    return nil, fmt.Errorf("xmlx: conref %q: document has no root element", ref)
+  }
+
+  // DITA addresses elements inside a topic as topicid/elementid.
+  if i := strings.Index(ptr, "/"); i > -1 && !strings.Contains(ptr, "(") {
+    if topic := findID(doc.Root, ptr[:i]); topic != nil {
+      if n := findID(topic, ptr[i+1:]); n != nil {
+        return n, nil
+      }
+    }
+    return nil, fmt.Errorf("xmlx: conref %q: target not found", ref)
+  }
+
+  n, err := doc.ResolvePointer(ptr)
+  if err != nil {
+    return nil, fmt.Errorf("xmlx: conref %q: %s", ref, err)
+  }
+  return n, nil
+}
+
+// expand replaces the content of cn with a copy of the content of target.
+func (this *ConrefResolver) expand(cn *Node, ref *Attr, target *Node) {
+  attrs := make([]*Attr, 0, len(cn.Attributes)+len(target.Attributes))
+  for _, a := range cn.Attributes {
+    if a != ref {
+      attrs = append(attrs, a)
+    }
+  }
+  for _, a := range target.Attributes {
+    if a.Name.Local == "id" || cn.HasAttr(a.Name.Space, a.Name.Local) {
+      continue
+    }
+    attrs = append(attrs, a.copy())
+  }
+  cn.Attributes = attrs
+
+  cn.Children = make([]*Node, 0, len(target.Children))
+  for _, v := range target.Children {
+    c := v.Clone()
+    c.Parent = cn
+    cn.Children = append(cn.Children, c)
+  }
+}
+
+func describeNode(n *Node) string {
+  if id := n.As("*", "id"); id != "" {
+    return fmt.Sprintf("<%s id=%q>", n.QualifiedName(), id)
+  }
+  return "<" + n.QualifiedName() + ">"
+}
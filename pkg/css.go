@@ -0,0 +1,448 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+  "unicode"
+)
+
+// Selector is a compiled CSS selector, for users more at home with the
+// selectors of HTML scraping libraries than with the Select functions. The
+// supported subset is:
+//
+//   name  ns|name  *  ns|*       type selectors; ns is a prefix or '*'
+//   #id  .class                  id and class attributes
+//   [a]  [a=v]  [a~=v]  [a|=v]   attribute presence and value tests,
+//   [a^=v]  [a$=v]  [a*=v]       with v quoted or bare
+//   :root  :empty  :first-child  :last-child  :nth-child(n)
+//   A B  A > B  A + B  A ~ B     descendant and sibling combinators
+//   A, B                         selector lists
+//
+// A Selector is immutable and safe for concurrent use.
+type Selector struct {
+  expr   string
+  groups []cssComplex
+}
+
+type cssComplex struct {
+  parts []cssCompound
+  combs []byte // combs[i] joins parts[i] and parts[i+1]: ' ', '>', '+' or '~'.
+}
+
+type cssCompound struct {
+  space   string
+  local   string
+  attrs   []cssAttr
+  pseudos []cssPseudo
+}
+
+type cssAttr struct {
+  space string
+  local string
+  op    string // "" for a presence test.
+  value string
+}
+
+type cssPseudo struct {
+  name string
+  n    int // Argument of nth-child.
+}
+
+// CompileSelector parses a CSS selector for later use.
+func CompileSelector(expr string) (*Selector, error) {
+  p := &cssParser{s: expr}
+  sel, err := p.parse()
+  if err != nil {
+    return nil, fmt.Errorf("xmlx: selector %q: %s", expr, err)
+  }
+  return sel, nil
+}
+
+// String returns the source text of the selector.
+func (this *Selector) String() string {
+  return this.expr
+}
+
+// Match reports whether element n matches the selector.
+func (this *Selector) Match(n *Node) bool {
+  if n.Type != NT_ELEMENT {
+    return false
+  }
+  for i := range this.groups {
+    if this.groups[i].matchAt(n, len(this.groups[i].parts)-1) {
+      return true
+    }
+  }
+  return false
+}
+
+// Find returns the elements below n that match the selector, in document
+// order and without duplicates.
+func (this *Selector) Find(n *Node) []*Node {
+  return n.SelectNodesRecursiveFunc(this.Match)
+}
+
+// Find returns the elements below this node matching the CSS selector, e.g.
+// "order > item.urgent[sku]". See Selector for the supported syntax. An
+// invalid selector matches nothing; use CompileSelector to get the error.
+func (this *Node) Find(selector string) []*Node {
+  sel, err := CompileSelector(selector)
+  if err != nil {
+    return []*Node{}
+  }
+  return sel.Find(this)
+}
+
+// Find returns the elements of the document matching the CSS selector. See
+// Node.Find.
+func (this *Document) Find(selector string) []*Node {
+  return this.Root.Find(selector)
+}
+
+func (this *cssComplex) matchAt(n *Node, i int) bool {
+  if !this.parts[i].match(n) {
+    return false
+  }
+  if i == 0 {
+    return true
+  }
+
+  switch this.combs[i-1] {
+  case '>':
+    p := n.Parent
+    return p != nil && p.Type == NT_ELEMENT && this.matchAt(p, i-1)
+  case '+':
+    prev := n.PrevSiblingElement()
+    return prev != nil && this.matchAt(prev, i-1)
+  case '~':
+    for prev := n.PrevSiblingElement(); prev != nil; prev = prev.PrevSiblingElement() {
+      if this.matchAt(prev, i-1) {
+        return true
+      }
+    }
+  default:
+    for p := n.Parent; p != nil && p.Type == NT_ELEMENT; p = p.Parent {
+      if this.matchAt(p, i-1) {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+func (this *cssCompound) match(n *Node) bool {
+  if n.Type != NT_ELEMENT || !n.matches(this.space, this.local) {
+    return false
+  }
+
+  for _, a := range this.attrs {
+    if !a.match(n) {
+      return false
+    }
+  }
+
+  for _, p := range this.pseudos {
+    switch p.name {
+    case "root":
+      if n.Parent == nil || n.Parent.Type != NT_ROOT {
+        return false
+      }
+    case "empty":
+      for _, v := range n.Children {
+        if v.Type == NT_ELEMENT || (v.IsText() && v.Value != "") {
+          return false
+        }
+      }
+    case "first-child":
+      if n.PrevSiblingElement() != nil {
+        return false
+      }
+    case "last-child":
+      if n.NextSiblingElement() != nil {
+        return false
+      }
+    case "nth-child":
+      pos := 1
+      for prev := n.PrevSiblingElement(); prev != nil; prev = prev.PrevSiblingElement() {
+        pos++
+      }
+      if pos != p.n {
+        return false
+      }
+    }
+  }
+
+  return true
+}
+
+func (this *cssAttr) match(n *Node) bool {
+  for _, a := range n.Attributes {
+    if a.Name.Local != this.local || (this.space != "*" && this.space != a.Name.Space) {
+      continue
+    }
+
+    v := a.value()
+    switch this.op {
+    case "":
+      return true
+    case "=":
+      return v == this.value
+    case "~=":
+      for _, f := range strings.Fields(v) {
+        if f == this.value {
+          return true
+        }
+      }
+      return false
+    case "|=":
+      return v == this.value || strings.HasPrefix(v, this.value+"-")
+    case "^=":
+      return this.value != "" && strings.HasPrefix(v, this.value)
+    case "$=":
+      return this.value != "" && strings.HasSuffix(v, this.value)
+    case "*=":
+      return this.value != "" && strings.Contains(v, this.value)
+    }
+  }
+  return false
+}
+
+// cssParser is a small recursive descent parser for selectors.
+type cssParser struct {
+  s string
+  i int
+}
+
+func (this *cssParser) parse() (*Selector, error) {
+  sel := &Selector{expr: this.s}
+  for {
+    c, err := this.complex()
+    if err != nil {
+      return nil, err
+    }
+    sel.groups = append(sel.groups, c)
+
+    this.skipSpace()
+    if this.i == len(this.s) {
+      return sel, nil
+    }
+    if this.s[this.i] != ',' {
+      return nil, fmt.Errorf("unexpected %q", this.s[this.i])
+    }
+    this.i++
+  }
+}
+
+func (this *cssParser) complex() (cssComplex, error) {
+  var c cssComplex
+
+  this.skipSpace()
+  for {
+    part, err := this.compound()
+    if err != nil {
+      return c, err
+    }
+    c.parts = append(c.parts, part)
+
+    space := this.skipSpace()
+    if this.i == len(this.s) || this.s[this.i] == ',' {
+      return c, nil
+    }
+
+    comb := byte(' ')
+    switch this.s[this.i] {
+    case '>', '+', '~':
+      comb = this.s[this.i]
+      this.i++
+      this.skipSpace()
+    default:
+      if !space {
+        return c, fmt.Errorf("unexpected %q", this.s[this.i])
+      }
+    }
+    c.combs = append(c.combs, comb)
+  }
+}
+
+func (this *cssParser) compound() (cssCompound, error) {
+  part := cssCompound{space: "*", local: "*"}
+  start := this.i
+
+  if this.peek('*') || this.isIdent() {
+    name := this.nameOrStar()
+    if this.peek('|') {
+      this.i++
+      if !this.peek('*') && !this.isIdent() {
+        return part, fmt.Errorf("missing name after %s|", name)
+      }
+      part.space, part.local = name, this.nameOrStar()
+    } else {
+      part.local = name
+    }
+  }
+
+  for this.i < len(this.s) {
+    switch this.s[this.i] {
+    case '#', '.':
+      c := this.s[this.i]
+      this.i++
+      if !this.isIdent() {
+        return part, fmt.Errorf("missing name after %q", c)
+      }
+      if c == '#' {
+        part.attrs = append(part.attrs, cssAttr{"*", "id", "=", this.ident()})
+      } else {
+        part.attrs = append(part.attrs, cssAttr{"*", "class", "~=", this.ident()})
+      }
+    case '[':
+      a, err := this.attr()
+      if err != nil {
+        return part, err
+      }
+      part.attrs = append(part.attrs, a)
+    case ':':
+      p, err := this.pseudo()
+      if err != nil {
+        return part, err
+      }
+      part.pseudos = append(part.pseudos, p)
+    default:
+      if this.i == start {
+        return part, fmt.Errorf("unexpected %q", this.s[this.i])
+      }
+      return part, nil
+    }
+  }
+
+  if this.i == start {
+    return part, fmt.Errorf("empty selector")
+  }
+  return part, nil
+}
+
+func (this *cssParser) attr() (cssAttr, error) {
+  a := cssAttr{space: "*"}
+  this.i++ // [
+  this.skipSpace()
+
+  if !this.isIdent() {
+    return a, fmt.Errorf("missing attribute name")
+  }
+  a.local = this.ident()
+  if this.peek('|') && !strings.HasPrefix(this.s[this.i:], "|=") {
+    this.i++
+    if !this.isIdent() {
+      return a, fmt.Errorf("missing attribute name after %s|", a.local)
+    }
+    a.space, a.local = a.local, this.ident()
+  }
+  this.skipSpace()
+
+  for _, op := range []string{"=", "~=", "|=", "^=", "$=", "*="} {
+    if strings.HasPrefix(this.s[this.i:], op) {
+      a.op = op
+      this.i += len(op)
+      break
+    }
+  }
+
+  if a.op != "" {
+    this.skipSpace()
+    if this.peek('"') || this.peek('\'') {
+      q := this.s[this.i]
+      end := strings.IndexByte(this.s[this.i+1:], q)
+      if end < 0 {
+        return a, fmt.Errorf("unterminated string")
+      }
+      a.value = this.s[this.i+1 : this.i+1+end]
+      this.i += end + 2
+    } else if this.isIdent() {
+      a.value = this.ident()
+    } else {
+      return a, fmt.Errorf("missing attribute value")
+    }
+    this.skipSpace()
+  }
+
+  if !this.peek(']') {
+    return a, fmt.Errorf("missing ']'")
+  }
+  this.i++
+  return a, nil
+}
+
+func (this *cssParser) pseudo() (cssPseudo, error) {
+  this.i++ // :
+  if !this.isIdent() {
+    return cssPseudo{}, fmt.Errorf("missing pseudo-class name")
+  }
+  p := cssPseudo{name: this.ident()}
+
+  switch p.name {
+  case "root", "empty", "first-child", "last-child":
+  case "nth-child":
+    if !this.peek('(') {
+      return p, fmt.Errorf("missing argument to :nth-child")
+    }
+    end := strings.IndexByte(this.s[this.i:], ')')
+    if end < 0 {
+      return p, fmt.Errorf("missing ')'")
+    }
+    n, err := strconv.Atoi(strings.TrimSpace(this.s[this.i+1 : this.i+end]))
+    if err != nil || n < 1 {
+      return p, fmt.Errorf("unsupported :nth-child argument %q", this.s[this.i+1:this.i+end])
+    }
+    p.n = n
+    this.i += end + 1
+  default:
+    return p, fmt.Errorf("unsupported pseudo-class :%s", p.name)
+  }
+  return p, nil
+}
+
+func (this *cssParser) skipSpace() bool {
+  start := this.i
+  for this.i < len(this.s) && strings.IndexByte(" \t\r\n", this.s[this.i]) > -1 {
+    this.i++
+  }
+  return this.i > start
+}
+
+func (this *cssParser) peek(c byte) bool {
+  return this.i < len(this.s) && this.s[this.i] == c
+}
+
+func (this *cssParser) isIdent() bool {
+  if this.i >= len(this.s) {
+    return false
+  }
+  r := []rune(this.s[this.i:])[0]
+  return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-'
+}
+
+// ident reads a name. Unlike CSS identifiers, names may start with a digit,
+// so values like [id=42] need no quotes.
+func (this *cssParser) ident() string {
+  start := this.i
+  this.i = len(this.s)
+  for j, r := range this.s[start:] {
+    if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' {
+      this.i = start + j
+      break
+    }
+  }
+  return this.s[start:this.i]
+}
+
+func (this *cssParser) nameOrStar() string {
+  if this.peek('*') {
+    this.i++
+    return "*"
+  }
+  return this.ident()
+}
@@ -7,12 +7,13 @@ simples de busqueda.
 De esta manera, los nodos se convierten simplemente en colecciones y no
 requieren que los lea en el orden en que el xml.Parser los encuentra.
 
-El archivo "Document" implementa hasta ahora 2 funciones de busqueda que le
+El archivo "Document" implementa hasta ahora 4 funciones de busqueda que le
 permitiran buscar nodos especificos
 
 *xmlx.Document.SelectNode          (namespace, name string)   *Node;
 *xmlx.Document.SelectNodes         (namespace, name string) []*Node;
 *xmlx.Document.SelectNodesRecursive(namespace, name string) []*Node;
+*xmlx.Document.SelectNodesDepth    (namespace, name string, maxDepth int) []*Node;
 
 SelectNode () devuelve el primer o unico nodo que se encuentra al buscar por un
 nombre y namespace dados.
@@ -20,9 +21,17 @@ SelectNodes() devuelve una seccion conteniendo todos los nodos coincidentes
 (sin entrar recursivamente en los nodos coincidentes)
 SelectNodesRecursive() devuelve una seccion con todos los nodos coincidentes,
 incluyendo los nodos dentro de otros nodos coincidentes
+SelectNodesDepth() hace lo mismo que SelectNodesRecursive(), pero desciende a
+lo mas maxDepth niveles; util para buscar solo cerca de la raiz de arboles muy
+profundos
 
 Note que estas funciones de busqueda pueden ser llamadas en nodos individuales
 tambien. Esto le permitira buscar solo un subconjunto del documento entero.
+
+Todas las funciones de seleccion devuelven los nodos en orden de documento (el
+orden en que aparecen sus etiquetas de inicio en el XML), y SelectNode()
+devuelve el primero en ese orden. Para listas combinadas desde varias
+consultas, xmlx.SortDocumentOrder() restablece ese orden.
 */
 
 package xmlx
@@ -36,6 +45,7 @@ import (
   "io/ioutil"
   "net/http"
   "os"
+  "regexp"
   "strings"
 )
 
@@ -46,15 +56,65 @@ type CharsetFunc func(charset string, input io.Reader) (io.Reader, error)
 
 // Este tipo representa un documento XML simple.
 type Document struct {
-  Version     string             // Version XML
-  Encoding    string             // Tipo de codificacion encontrado en el documento. Si no existiera se asume UTF-8.
-  StandAlone  string             // Valor del atributo 'standalone' del doctype XML.
-  Entity      map[string]string  // Mapeo de conversiones de entidades de configuracion.
-  Root       *Node               // El nodo raiz del documento.
-  SaveDocType bool               // Indicador de incluir o no los doctype XML al salvar el documento
-  Namespaces  map[string]string  // Mapa de namespaces del documento
+  Version       string             // Version XML
+  Encoding      string             // Tipo de codificacion encontrado en el documento. Si no existiera se asume UTF-8.
+  StandAlone    string             // Valor del atributo 'standalone' del doctype XML.
+  Entity        map[string]string  // Mapeo de conversiones de entidades de configuracion.
+  Root         *Node               // El nodo raiz del documento.
+  SaveDocType   bool               // Indicador de incluir o no los doctype XML al salvar el documento
+  SaveBOM       bool               // Indicador de escribir un BOM al salvar (ver encodeOutput).
+  Namespaces    map[string]string  // Mapa de namespaces del documento
+  DupAttrs      int                // Politica ante atributos duplicados en un elemento (DUPATTR_*).
+  BadChars      int                // Politica ante caracteres no permitidos por XML 1.0 (BADCHAR_*).
+  Warnings      []error            // Advertencias producidas durante la ultima carga.
+  StrictRoot    bool               // Indicador de rechazar multiples elementos raiz o contenido fuera de la raiz.
+  StrictNS      bool               // Indicador de rechazar prefijos de namespace sin declarar al cargar.
+  Strict        bool               // Modo estricto del decodificador (xml.Decoder.Strict). Verdadero por omision.
+  AutoClose     []string           // Elementos que se cierran solos en modo no estricto (ej: xml.HTMLAutoClose).
+  MaxSaveSize   int                // Tamano maximo en bytes del documento serializado; 0 sin limite.
+  MaxSaveDepth  int                // Anidamiento maximo de elementos al serializar; 0 sin limite.
+  OmitEmpty     []string           // Elementos omitidos al salvar si no tienen atributos ni contenido (salvo espacios); "*" para todos.
+  Newline       string             // Salto de linea escrito al indentar y tras la declaracion XML (ej: "\r\n"); "\n" si esta vacio.
+  NoIndent      []string           // Elementos cuyo contenido se escribe tal cual al indentar (ej: pre), como con HINT_NOINDENT.
+  MaxIndent     int                // Niveles maximos de indentacion (la raiz en el nivel 0); lo mas profundo se escribe compacto. 0 sin limite.
+  Inline        []string           // Elementos que forman parte del texto corrido (ej: emphasis); lo que los contiene se escribe tal cual, sin indentar nada debajo.
+  MaxTextSize   int                // Longitud maxima en bytes de un nodo de texto al cargar; 0 sin limite.
+  CoalesceText  bool               // Indicador de unir en un solo nodo los bloques de texto consecutivos.
+  URISpaces     bool               // Indicador de dejar el URI en Name.Space en lugar de su alias.
+  Fragment      bool               // Indicador de permitir varios elementos raiz y texto al nivel superior (ver Validate).
+  ValidateSave  bool               // Indicador de exigir que el documento pase Validate en todas las funciones Save (salvo en modo Fragment).
+  NormalizeAttr bool               // Indicador de normalizar los valores de atributos al cargar (XML 3.3.3).
+  Normalize     func(string) string // Normalizacion Unicode (ej: norm.NFC.String) aplicada a textos y atributos al cargar.
+  AttrTypes     map[string]string  // Tipos de atributos ("elemento@atributo" -> "IDREFS") para la normalizacion, ademas de los del DTD interno.
+  Redact        []RedactRule       // Reglas de enmascaramiento de valores aplicadas al cargar (ver RedactRule).
+  MaxEntities   int                // Numero maximo de referencias a entidades de Entity sustituidas al cargar; 0 sin limite.
+  MaxEntitySize int                // Tamano maximo en bytes del texto sustituido por esas referencias al cargar; 0 sin limite.
+  Stats         LoadStats          // Estadisticas de la ultima carga (ver LoadStats).
+  Codecs        map[string]Codec   // Codificaciones del texto de elementos, por nombre ("local" o "{uri}local"), revertidas al cargar y aplicadas al salvar (ver Codec).
+  SpillSize     int                // Tamano en bytes a partir del cual los valores de atributos se guardan en archivos temporales al cargar (ver spill.go); 0 nunca.
+  SpillDir      string             // Directorio de esos archivos; os.TempDir() si esta vacio.
+  spilled       []string           // Archivos temporales creados por spillAttrs.
+  free          []*Node            // Nodos liberados por DocumentPool.Put, reutilizados en la siguiente carga.
+  dtdAttrTypes  map[string]string  // Tipos de atributos declarados en el DTD interno del ultimo documento.
 }
 
+// Politicas ante atributos duplicados (despues de expandir namespaces) en un
+// mismo elemento. encoding/xml los acepta en silencio.
+const (
+  DUPATTR_IGNORE = iota  // Se conservan todos, como hace encoding/xml.
+  DUPATTR_WARN           // Se agrega una advertencia a Document.Warnings.
+  DUPATTR_ERROR          // La carga termina con error.
+)
+
+// Politicas ante caracteres no permitidos por XML 1.0 (caracteres de control,
+// surrogates sueltos), al cargar y al salvar. Los cambios hechos se reportan
+// en Document.Warnings.
+const (
+  BADCHAR_ERROR = iota   // La carga o el salvado terminan con error.
+  BADCHAR_STRIP          // Se eliminan los caracteres.
+  BADCHAR_REPLACE        // Se reemplazan por U+FFFD.
+)
+
 // Funcion para crear una instancia nueva y vacia de documento XML.
 func New() *Document {
   return &Document{
@@ -62,11 +122,68 @@ func New() *Document {
     Encoding:    "UTF-8",
     StandAlone:  "yes",
     SaveDocType: true,
+    Strict:      true,
     Entity:      make(map[string]string),
     Namespaces:  make(map[string]string),
   }
 }
 
+// Devuelve una copia independiente del documento: el arbol completo (ver
+// Node.Clone), los mapas Entity, Namespaces, AttrTypes y Codecs, y las
+// opciones de carga y salvado. Modificar la copia no afecta al original, por
+// lo que una plantilla cargada una sola vez puede clonarse en cada peticion
+// de un servidor. Las funciones (Normalize, Attr.Func, OnSave, codecs) se
+// comparten, igual que los archivos de atributos guardados por SpillSize,
+// que siguen perteneciendo al original (ver RemoveSpilled).
+func (this *Document) Clone() *Document {
+  doc := this.cloneSettings()
+  if this.Root != nil {
+    doc.Root = this.Root.Clone()
+  }
+  doc.dtdAttrTypes = copyStringMap(this.dtdAttrTypes)
+  doc.Warnings = append([]error(nil), this.Warnings...)
+  doc.Stats = this.Stats
+  return doc
+}
+
+// Devuelve un documento vacio con las opciones de carga y salvado de este y
+// copias de sus mapas Entity, Namespaces, AttrTypes y Codecs.
+func (this *Document) cloneSettings() *Document {
+  doc := *this
+  doc.Root = nil
+  doc.Warnings = nil
+  doc.Stats = LoadStats{}
+  doc.free = nil
+  doc.spilled = nil
+  doc.dtdAttrTypes = nil
+  doc.Entity = copyStringMap(this.Entity)
+  doc.Namespaces = copyStringMap(this.Namespaces)
+  doc.AttrTypes = copyStringMap(this.AttrTypes)
+  if this.Codecs != nil {
+    doc.Codecs = make(map[string]Codec, len(this.Codecs))
+    for k, v := range this.Codecs {
+      doc.Codecs[k] = v
+    }
+  }
+  doc.AutoClose = append([]string(nil), this.AutoClose...)
+  doc.OmitEmpty = append([]string(nil), this.OmitEmpty...)
+  doc.NoIndent = append([]string(nil), this.NoIndent...)
+  doc.Inline = append([]string(nil), this.Inline...)
+  doc.Redact = append([]RedactRule(nil), this.Redact...)
+  return &doc
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+  if m == nil {
+    return nil
+  }
+  c := make(map[string]string, len(m))
+  for k, v := range m {
+    c[k] = v
+  }
+  return c
+}
+
 // Esta funcion carga una tabla masiva de secuencias de escape XML no
 // convencionales.
 // Se necesita para hacer que el parser las mapee apropiadamente. Se aconseja
@@ -78,6 +195,17 @@ func (this *Document) LoadExtendedEntityMap() {
   loadNonStandardEntities(this.Entity)
 }
 
+// Prepara el documento para cargar HTML de la era previa a XHTML: desactiva el
+// modo estricto, cierra automaticamente los elementos vacios de HTML (como
+// <br>) y agrega las entidades HTML conocidas por encoding/xml.
+func (this *Document) UseHTMLMode() {
+  this.Strict = false
+  this.AutoClose = xml.HTMLAutoClose
+  for k, v := range xml.HTMLEntity {
+    this.Entity[k] = v
+  }
+}
+
 // Selecciona un nodo simple con un nombre y namespace dados. Devuelve 'nil'
 // si no se encuentra un nodo que haga match.
 func (this *Document) SelectNode(namespace, name string) *Node {
@@ -100,85 +228,549 @@ func (this *Document) SelectNodesRecursive(namespace, name string) []*Node {
   return this.Root.SelectNodesRecursive(namespace, name)
 }
 
+// Selecciona a lo mas limit nodos con un nombre y namespace dados, en orden de
+// documento, terminando la busqueda al encontrarlos. Ver Node.SelectNodesN.
+func (this *Document) SelectNodesN(namespace, name string, limit int) []*Node {
+  return this.Root.SelectNodesN(namespace, name, limit)
+}
+
+// Llama a fn con cada nodo del documento con un nombre y namespace dados, en
+// orden de documento, sin armar una lista. La busqueda termina cuando fn
+// devuelve falso.
+func (this *Document) SelectNodesStream(namespace, name string, fn func(*Node) bool) {
+  this.Root.SelectNodesStream(namespace, name, fn)
+}
+
+// Selecciona todos los nodos con un nombre y namespace dados en orden de
+// documento inverso, empezando por el ultimo. Ver Node.SelectNodesReverse.
+func (this *Document) SelectNodesReverse(namespace, name string) []*Node {
+  return this.Root.SelectNodesReverse(namespace, name)
+}
+
+// Llama a fn con cada nodo con un nombre y namespace dados en orden de
+// documento inverso, hasta que fn devuelva falso. Ver
+// Node.SelectNodesStreamReverse.
+func (this *Document) SelectNodesStreamReverse(namespace, name string, fn func(*Node) bool) {
+  this.Root.SelectNodesStreamReverse(namespace, name, fn)
+}
+
+// Selecciona los nodos del nivel superior del documento para los que fn
+// devuelve verdadero.
+func (this *Document) SelectNodesFunc(fn func(*Node) bool) []*Node {
+  return this.Root.SelectNodesFunc(fn)
+}
+
+// Selecciona todos los nodos del documento para los que fn devuelve
+// verdadero, en orden de documento.
+func (this *Document) SelectNodesRecursiveFunc(fn func(*Node) bool) []*Node {
+  return this.Root.SelectNodesRecursiveFunc(fn)
+}
+
+// Recorre todos los nodos del documento en orden de documento, llamando a fn
+// con cada nodo y su profundidad; los nodos del nivel superior (como el
+// elemento raiz) tienen profundidad 0. Ver Node.Walk.
+func (this *Document) Walk(fn func(n *Node, depth int) WalkAction) {
+  rec_Walk(this.Root, fn, 0)
+}
+
+// Selecciona todos los elementos con un nombre y namespace dados que tengan
+// el atributo attrName con el valor attrValue. Ver Node.SelectNodesByAttr.
+func (this *Document) SelectNodesByAttr(namespace, name, attrName, attrValue string) []*Node {
+  return this.Root.SelectNodesByAttr(namespace, name, attrName, attrValue)
+}
+
+// Busca los elementos del documento cuyo texto propio coincide con la
+// expresion regular re. Ver Node.SearchText.
+func (this *Document) SearchText(re *regexp.Regexp) []*Node {
+  return this.Root.SearchText(re)
+}
+
+// Busca los elementos del documento con algun atributo cuyo valor coincide con
+// la expresion regular re. Ver Node.SearchAttr.
+func (this *Document) SearchAttr(re *regexp.Regexp) []*Node {
+  return this.Root.SearchAttr(re)
+}
+
+// Selecciona todos los nodos con un nombre y namespace dados, descendiendo a
+// lo mas maxDepth niveles desde la raiz del documento. Con maxDepth 1 solo se
+// revisa el nivel superior del documento.
+func (this *Document) SelectNodesDepth(namespace, name string, maxDepth int) []*Node {
+  return this.Root.SelectNodesDepth(namespace, name, maxDepth)
+}
+
+// Selecciona el primer nodo al que lleva la ruta dada, empezando por el
+// elemento raiz (ej: "order/items/item" o "soap:Envelope/soap:Body"). Ver
+// Node.SelectNodeByPath.
+func (this *Document) SelectNodeByPath(expr string) *Node {
+  return this.Root.SelectNodeByPath(expr)
+}
+
+// Selecciona todos los nodos a los que lleva la ruta dada, empezando por el
+// elemento raiz, en orden de documento. Ver Node.SelectNodesByPath.
+func (this *Document) SelectNodesByPath(expr string) []*Node {
+  return this.Root.SelectNodesByPath(expr)
+}
+
+// Envuelve el elemento raiz actual en un nuevo elemento raiz con el namespace
+// (URI), nombre y atributos dados. Si ningun atributo declara el namespace, se
+// declara como namespace por defecto del nuevo elemento, y el elemento
+// envuelto recibe xmlns="" si no tenia namespace. Los nodos fuera del elemento
+// raiz (comentarios, instrucciones de proceso) no se mueven. Devuelve el nuevo
+// elemento raiz.
+func (this *Document) Envelope(namespace, name string, attrs ...*Attr) *Node {
+  env := NewNode(NT_ELEMENT)
+  env.Name = xml.Name{Space: namespace, Local: name}
+  env.Attributes = append(env.Attributes, attrs...)
+
+  declared := false
+  if namespace != "" {
+    if _, ok := lookupPrefix(env.NamespaceContext(), namespace); !ok {
+      env.setNamespaceDecl("", namespace)
+      declared = true
+    }
+  }
+
+  if this.Root == nil {
+    this.Root = NewNode(NT_ROOT)
+  }
+
+  root := this.documentElement()
+  if root == nil {
+    this.Root.AddChild(env)
+    return env
+  }
+
+  for i, v := range this.Root.Children {
+    if v == root {
+      this.Root.Children[i] = env
+      env.Parent = this.Root
+      break
+    }
+  }
+  root.Parent = nil
+  if declared && root.NamespaceURI() == "" && !root.declares("") {
+    root.setNamespaceDecl("", "")
+  }
+  env.AddChild(root)
+  return env
+}
+
+// Elimina el elemento raiz actual, promoviendo a raiz su unico elemento hijo.
+// Devuelve el elemento eliminado (con sus demas hijos, como cabeceras en
+// texto o comentarios) o un error si la raiz no tiene exactamente un elemento
+// hijo.
+func (this *Document) Unenvelope() (*Node, error) {
+  env := this.documentElement()
+  if env == nil {
+    return nil, errors.New("xmlx: document has no root element")
+  }
+
+  var inner *Node
+  for _, v := range env.Children {
+    if v.Type != NT_ELEMENT {
+      continue
+    }
+    if inner != nil {
+      return nil, fmt.Errorf("xmlx: <%s> has more than one child element", env.Name.Local)
+    }
+    inner = v
+  }
+  if inner == nil {
+    return nil, fmt.Errorf("xmlx: <%s> has no child element", env.Name.Local)
+  }
+
+  env.RemoveChild(inner)
+  for i, v := range this.Root.Children {
+    if v == env {
+      this.Root.Children[i] = inner
+      inner.Parent = this.Root
+      break
+    }
+  }
+  env.Parent = nil
+  return env, nil
+}
+
+// Devuelve el primer elemento bajo el nodo NT_ROOT, o nil si no existe.
+func (this *Document) documentElement() *Node {
+  if this.Root == nil {
+    return nil
+  }
+  return this.Root.FirstChildElement()
+}
+
 // Carga el contenido de este documento desde el reader proporcionado.
 func (this *Document) LoadStream(r io.Reader, charset CharsetFunc) (err error) {
-  xp := xml.NewDecoder(r)          // Tipo de retorno: *Decoder <-- Crea un parser XMl desde el reader r
-  xp.Entity = this.Entity          // Asigna al parser el area de memoria para mapa de entidades del documento
-  xp.CharsetReader = charset       // Crea una instancia de la funcion de mapeo para el parser
+  ld, err := this.newLoader(r, charset)
+  if err != nil {
+    return err
+  }
 
-  this.Root = NewNode(NT_ROOT)
+  this.Root = this.newNode(NT_ROOT)
   ct := this.Root                  // Tipo *Node - corresponde al current node
 
   var tok xml.Token
-  var t *Node
-  var doctype string
-    
+
+  this.Warnings = nil
+  this.dtdAttrTypes = nil
   for {
-    if tok, err = xp.Token(); err != nil {
+    if tok, err = ld.xp.Token(); err != nil {
       if err == io.EOF {
-        return nil
+        return this.checkRoot(ld.xp, nil)
       }
       return err
     }
 
-    switch tt := tok.(type) {
-    case xml.SyntaxError:
-      return errors.New(tt.Error())
-    case xml.CharData:
-      t := NewNode(NT_TEXT)
-      t.Value = string([]byte(tt))
-      ct.AddChild(t)
-    case xml.Comment:
-      t := NewNode(NT_COMMENT)
-      t.Value = strings.TrimSpace(string([]byte(tt)))
-      ct.AddChild( t )
-    case xml.Directive:
-      t = NewNode(NT_DIRECTIVE)
-      t.Value = strings.TrimSpace(string([]byte(tt)))
-      ct.AddChild(t)
-    case xml.StartElement:
-      t = NewNode(NT_ELEMENT)
-      t.Name = tt.Name
+    if ct, err = this.loadToken(ld, ct, tok); err != nil || ct == nil {
+      return err
+    }
+  }
+}
+
+// Estado de una carga en curso, compartido por LoadStream y los lectores de
+// subarboles (ver stream.go)
+type loader struct {
+  xp       *xml.Decoder
+  prefixes map[string]string // Ultimo URI asociado a cada prefijo
+  redact   []redactRule      // Reglas de Document.Redact ya compiladas
+  masked   *Node             // Elemento abierto cuyo texto se enmascara (ver redact.go)
+  maskRule *redactRule       // Regla que corresponde a masked
+}
+
+func (this *Document) newLoader(r io.Reader, charset CharsetFunc) (*loader, error) {
+  redact, err := compileRedact(this.Redact)
+  if err != nil {
+    return nil, err
+  }
+
+  r = skipBOM(r)
+  if this.BadChars != BADCHAR_ERROR {
+    r = newCharFilter(r, this.BadChars, func(err error) {
+      this.Warnings = append(this.Warnings, err)
+    })
+  }
+  this.Stats = LoadStats{}
+  if this.MaxEntities > 0 || this.MaxEntitySize > 0 || len(this.Entity) > 0 {
+    r = this.newEntityCounter(r)
+  } else {
+    r = &byteCounter{r: r, n: &this.Stats.InputBytes}
+  }
+  return &loader{
+    xp:       this.newDecoder(r, charset),
+    prefixes: make(map[string]string),
+    redact:   redact,
+  }, nil
+}
+
+// Agrega el token leido al arbol bajo el nodo actual ct y devuelve el nuevo
+// nodo actual. Devuelve nil cuando se cierra el elemento superior.
+func (this *Document) loadToken(ld *loader, ct *Node, tok xml.Token) (*Node, error) {
+  var t *Node
+  var err error
+  xp := ld.xp
+
+  switch tt := tok.(type) {
+  case xml.SyntaxError:
+    return nil, errors.New(tt.Error())
+  case xml.CharData:
+    if ct == this.Root {
+      if err = this.checkRoot(xp, tt); err != nil {
+        return nil, err
+      }
+    }
+    if ld.masked != nil {
+      tt = xml.CharData(ld.redactText(ct, string(tt)))
+    }
+    if err = this.addText(xp, ct, tt); err != nil {
+      return nil, err
+    }
+  case xml.Comment:
+    t = this.newNode(NT_COMMENT)
+    t.Value = normalizeNewlines(strings.TrimSpace(string([]byte(tt))))
+    ct.AddChild( t )
+  case xml.Directive:
+    t = this.newNode(NT_DIRECTIVE)
+    t.Value = normalizeNewlines(strings.TrimSpace(string([]byte(tt))))
+    if strings.HasPrefix(t.Value, "DOCTYPE") {
+      t.Type = NT_DOCTYPE
+      if this.NormalizeAttr {
+        this.readAttlists(t.Value)
+      }
+    }
+    ct.AddChild(t)
+  case xml.StartElement:
+    if ct == this.Root {
+      if err = this.checkRoot(xp, tt); err != nil {
+        return nil, err
+      }
+    }
+    if err = this.checkDupAttrs(xp, tt); err != nil {
+      return nil, err
+    }
+    if err = this.checkPrefixes(xp, tt); err != nil {
+      return nil, err
+    }
+    t = this.newNode(NT_ELEMENT)
+    t.Name = tt.Name
+    if cap(t.Attributes) < len(tt.Attr) {
       t.Attributes = make([]*Attr, len(tt.Attr))
-      for i, v := range tt.Attr {
-        if v.Name.Space == "" && v.Name.Local == "xmlns" {                  // Crear mapa de namespaces
-          this.Namespaces[v.Value] = ""                                     // ...
-        } else if v.Name.Space == "xmlns" {                                 // ...
-          this.Namespaces[v.Value] = v.Name.Local                           // ...
-        }                                                                   // ...
-        t.Attributes[i] = new(Attr)
-        t.Attributes[i].Name = v.Name
-        t.Attributes[i].Value = v.Value
-        if alias, ok := this.Namespaces[t.Attributes[i].Name.Space]; ok {   // ...
-          t.Attributes[i].Name.Space = alias                                // ...
-        }                                                                   // ...
+    }
+    t.Attributes = t.Attributes[:len(tt.Attr)]
+    for i, v := range tt.Attr {
+      if v.Name.Space == "" && v.Name.Local == "xmlns" {                    // Crear mapa de namespaces
+        this.declareNamespace(xp, ld.prefixes, "", v.Value)                 // ...
+      } else if v.Name.Space == "xmlns" {                                   // ...
+        this.declareNamespace(xp, ld.prefixes, v.Name.Local, v.Value)       // ...
       }                                                                     // ...
-      if alias, ok := this.Namespaces[t.Name.Space]; ok {                   // ...
-        t.Name.Space = alias                                                // ...
+      t.Attributes[i] = new(Attr)
+      t.Attributes[i].Name = v.Name
+      t.Attributes[i].Value = v.Value
+      if this.NormalizeAttr {
+        t.Attributes[i].Value = this.normalizeAttr(tt.Name.Local, v.Name.Local, v.Value)
+      }
+      t.Attributes[i].Value = this.normalizeText(t.Attributes[i].Value)
+      if alias, ok := this.Namespaces[t.Attributes[i].Name.Space]; ok && !this.URISpaces {
+        t.Attributes[i].Name.Space = alias                                  // ...
       }                                                                     // ...
-      ct.AddChild( t )
-      ct = t
-    case xml.ProcInst:
-      if tt.Target == "xml" { // xml doctype
-        doctype = strings.TrimSpace(string(tt.Inst))
-        if i := strings.Index(doctype, `standalone="`); i > -1 {
-          this.StandAlone = doctype[i+len(`standalone="`) : len(doctype)]
-          i = strings.Index(this.StandAlone, `"`) 
-          this.StandAlone = this.StandAlone[0:i] 
-        }
-      } else {
-        t = NewNode(NT_PROCINST)
-        t.Target = strings.TrimSpace(tt.Target)
-        t.Value = strings.TrimSpace(string(tt.Inst))
-        ct.AddChild(t)
+    }                                                                       // ...
+    if alias, ok := this.Namespaces[t.Name.Space]; ok && !this.URISpaces {
+      t.Name.Space = alias                                                  // ...
+    }                                                                       // ...
+    ct.AddChild( t )
+    t.setLoaded( tt.Name.Space )
+    if len(ld.redact) > 0 {
+      ld.redactElement(t)
+    }
+    if this.SpillSize > 0 {
+      if err = this.spillAttrs(t); err != nil {
+        line, _ := xp.InputPos()
+        return nil, fmt.Errorf("xmlx: line %d: %s", line, err)
       }
-    case xml.EndElement:
-      if ct = ct.Parent; ct == nil {
-        return
+    }
+    ct = t
+  case xml.ProcInst:
+    if tt.Target == "xml" { // xml doctype
+      doctype := strings.TrimSpace(string(tt.Inst))
+      if i := strings.Index(doctype, `standalone="`); i > -1 {
+        this.StandAlone = doctype[i+len(`standalone="`) : len(doctype)]
+        i = strings.Index(this.StandAlone, `"`) 
+        this.StandAlone = this.StandAlone[0:i] 
+      }
+    } else {
+      t = this.newNode(NT_PROCINST)
+      t.Target = strings.TrimSpace(tt.Target)
+      t.Value = normalizeNewlines(strings.TrimSpace(string(tt.Inst)))
+      ct.AddChild(t)
+    }
+  case xml.EndElement:
+    if len(this.Codecs) > 0 {
+      if err = this.decodeElement(xp, ct); err != nil {
+        return nil, err
       }
     }
+    ct = ct.Parent
   }
-  return
+
+  return ct, nil
+}
+
+// Crea un parser XML desde el reader r, configurado con las opciones de carga
+// del documento.
+func (this *Document) newDecoder(r io.Reader, charset CharsetFunc) *xml.Decoder {
+  xp := xml.NewDecoder(r)          // Tipo de retorno: *Decoder <-- Crea un parser XMl desde el reader r
+  xp.Entity = this.Entity          // Asigna al parser el area de memoria para mapa de entidades del documento
+  xp.CharsetReader = charset       // Crea una instancia de la funcion de mapeo para el parser
+  xp.Strict = this.Strict          // Modo estricto o tolerante (HTML)
+  xp.AutoClose = this.AutoClose    // Elementos que se cierran automaticamente en modo tolerante
+  return xp
+}
+
+// Con Document.StrictRoot activo, verifica que el token encontrado al nivel
+// del documento no agregue un segundo elemento raiz ni texto fuera de la raiz.
+// Un token nil indica el fin del documento.
+func (this *Document) checkRoot(xp *xml.Decoder, tok xml.Token) error {
+  if !this.StrictRoot {
+    return nil
+  }
+
+  hasRoot := false
+  for _, v := range this.Root.Children {
+    if v.Type == NT_ELEMENT {
+      hasRoot = true
+      break
+    }
+  }
+
+  line, _ := xp.InputPos()
+  switch tt := tok.(type) {
+  case nil:
+    if !hasRoot {
+      return errors.New("xmlx: document has no root element")
+    }
+  case xml.StartElement:
+    if hasRoot {
+      return fmt.Errorf("xmlx: line %d: multiple root elements (<%s>)", line, tt.Name.Local)
+    }
+  case xml.CharData:
+    if len(bytes.TrimSpace(tt)) > 0 {
+      return fmt.Errorf("xmlx: line %d: text content outside the root element", line)
+    }
+  }
+  return nil
+}
+
+// Registra en Document.Namespaces la declaracion de un namespace. Como el
+// mapa es global al documento, se agrega una advertencia a Document.Warnings
+// cuando un URI recibe un segundo prefijo o un prefijo se asocia a otro URI.
+// Las declaraciones con URI vacio (que anulan un prefijo) no se registran.
+func (this *Document) declareNamespace(xp *xml.Decoder, prefixes map[string]string, prefix, uri string) {
+  if uri == "" {
+    return
+  }
+
+  line, _ := xp.InputPos()
+  if old, ok := this.Namespaces[uri]; ok && old != prefix {
+    this.Warnings = append(this.Warnings, fmt.Errorf("xmlx: line %d: namespace %s bound to prefix %q and %q", line, uri, old, prefix))
+  }
+  if old, ok := prefixes[prefix]; ok && old != uri {
+    this.Warnings = append(this.Warnings, fmt.Errorf("xmlx: line %d: prefix %q rebound from %s to %s", line, prefix, old, uri))
+  }
+
+  prefixes[prefix] = uri
+  this.Namespaces[uri] = prefix
+}
+
+// Agrega un bloque de texto al nodo actual, uniendolo con el nodo de texto
+// anterior si Document.CoalesceText esta activo, y respetando
+// Document.MaxTextSize. Note que encoding/xml ya ha leido el bloque completo
+// en memoria; el limite evita que el arbol siga creciendo con el.
+func (this *Document) addText(xp *xml.Decoder, ct *Node, tt xml.CharData) error {
+  var last *Node
+  if n := len(ct.Children); this.CoalesceText && n > 0 && ct.Children[n-1].Type == NT_TEXT {
+    last = ct.Children[n-1]
+  }
+
+  size := len(tt)
+  if last != nil {
+    size += len(last.Value)
+  }
+  if this.MaxTextSize > 0 && size > this.MaxTextSize {
+    line, _ := xp.InputPos()
+    return fmt.Errorf("xmlx: line %d: text node exceeds %d bytes", line, this.MaxTextSize)
+  }
+
+  if last != nil {
+    last.Value = this.normalizeText(last.Value + string(tt))
+    return nil
+  }
+
+  t := this.newNode(NT_TEXT)
+  t.Value = this.normalizeText(string(tt))
+  ct.AddChild(t)
+  return nil
+}
+
+// Convierte los fines de linea "\r\n" y "\r" en "\n", como exige la seccion
+// 2.11 de la especificacion XML. encoding/xml ya lo hace en textos y valores
+// de atributos, pero no en comentarios, directivas ni instrucciones de
+// proceso.
+func normalizeNewlines(s string) string {
+  if strings.IndexByte(s, '\r') < 0 {
+    return s
+  }
+  return strings.Replace(strings.Replace(s, "\r\n", "\n", -1), "\r", "\n", -1)
+}
+
+// Verifica que el elemento no repita atributos, segun la politica indicada en
+// Document.DupAttrs.
+func (this *Document) checkDupAttrs(xp *xml.Decoder, tt xml.StartElement) error {
+  if this.DupAttrs == DUPATTR_IGNORE || len(tt.Attr) < 2 {
+    return nil
+  }
+
+  seen := make(map[xml.Name]bool, len(tt.Attr))
+  for _, v := range tt.Attr {
+    if !seen[v.Name] {
+      seen[v.Name] = true
+      continue
+    }
+
+    name := v.Name.Local
+    if v.Name.Space != "" {
+      name = "{" + v.Name.Space + "}" + name
+    }
+    line, _ := xp.InputPos()
+    err := fmt.Errorf("xmlx: line %d: duplicate attribute %s on element <%s>", line, name, tt.Name.Local)
+    if this.DupAttrs == DUPATTR_ERROR {
+      return err
+    }
+    this.Warnings = append(this.Warnings, err)
+  }
+  return nil
+}
+
+// Con Document.StrictNS activo, verifica que los prefijos del elemento y de
+// sus atributos esten declarados. encoding/xml deja el prefijo en lugar del
+// URI cuando no lo estan.
+func (this *Document) checkPrefixes(xp *xml.Decoder, tt xml.StartElement) error {
+  if !this.StrictNS {
+    return nil
+  }
+
+  own := make(map[string]bool)
+  for _, v := range tt.Attr {
+    if v.Name.Space == "xmlns" || (v.Name.Space == "" && v.Name.Local == "xmlns") {
+      own[v.Value] = true
+    }
+  }
+
+  names := []xml.Name{tt.Name}
+  for _, v := range tt.Attr {
+    names = append(names, v.Name)
+  }
+  for _, v := range names {
+    if v.Space == "" || v.Space == "xmlns" || v.Space == xmlURL || own[v.Space] {
+      continue
+    }
+    if _, ok := this.Namespaces[v.Space]; ok {
+      continue
+    }
+    line, _ := xp.InputPos()
+    return fmt.Errorf("xmlx: line %d: unbound prefix %q in <%s>", line, v.Space, tt.Name.Local)
+  }
+  return nil
+}
+
+// Carga el contenido de este documento desde el reader proporcionado, copiando
+// a la vez los bytes leidos, tal como llegan, al writer w (ej: un archivo de
+// respaldo). La entrada se lee una sola vez. Si la carga falla, w puede haber
+// recibido solo parte de la entrada; un error al escribir en w termina la
+// carga.
+func (this *Document) LoadStreamTee( r io.Reader, w io.Writer, charset CharsetFunc ) (err error) {
+  return this.LoadStream( &teeReader{ r: r, w: w }, charset )
+}
+
+// Como io.TeeReader, pero un error al escribir se repite en todas las lecturas
+// siguientes. De lo contrario un bufio.Reader intermedio (ver skipBOM) podria
+// descartarlo y seguir leyendo sin copiar.
+type teeReader struct {
+  r   io.Reader
+  w   io.Writer
+  err error
+}
+
+func (this *teeReader) Read(p []byte) (int, error) {
+  if this.err != nil {
+    return 0, this.err
+  }
+  n, err := this.r.Read(p)
+  if n > 0 {
+    if _, werr := this.w.Write(p[:n]); werr != nil {
+      this.err = werr
+      return 0, werr
+    }
+  }
+  return n, err
 }
 
 // Carga el contenido de este documento desde la seccion de bytes proporcionada.
@@ -202,13 +794,30 @@ func (this *Document) LoadFile( filename string, charset CharsetFunc ) (err erro
 }
 
 // Carga el contenido de este documento desde la URI proporcionads usando el cliente especificado.
+// Si la URI trae un fragmento XPointer (ej: "doc.xml#chapter2"), el documento
+// queda reducido al subarbol referenciado. Ver Document.ResolvePointer().
 func (this *Document) LoadUriClient( uri string, client *http.Client, charset CharsetFunc ) (err error) {
+  var fragment string
+  if i := strings.Index( uri, "#" ); i > -1 {
+    uri, fragment = uri[:i], uri[i+1:]
+  }
+
   var r *http.Response
   if r, err = client.Get( uri ); err != nil {
     return
   }
   defer r.Body.Close( )
-  return this.LoadStream( r.Body, charset )
+  if err = this.LoadStream( r.Body, charset ); err != nil || fragment == "" {
+    return
+  }
+
+  var n *Node
+  if n, err = this.ResolvePointer( fragment ); err != nil {
+    return
+  }
+  this.Root = NewNode( NT_ROOT )
+  this.Root.AddChild( n )
+  return
 }
 
 // Carga el contenido de este documento desde el URI proporcionado. (llama a LoadUriClient con http.DefaultClient).
@@ -218,36 +827,143 @@ func (this *Document) LoadUri( uri string, charset CharsetFunc ) (err error) {
 
 // Salva el contenido de este documento en el archivo proporcionado.
 func (this *Document) SaveFile( path string ) error {
-  return ioutil.WriteFile( path, this.SaveBytes( ), 0600 )
+  b, err := this.SaveBytesE( )
+  if err != nil {
+    return err
+  }
+  return ioutil.WriteFile( path, b, 0600 )
 }
 
-// Salva el contenido de este documento como una seccion de bytes.
+// Salva el contenido de este documento como una seccion de bytes. Si se
+// excede MaxSaveSize o MaxSaveDepth, o si con ValidateSave el documento no
+// pasa Validate, devuelve nil; SaveBytesE, SaveFile y SaveStream reportan el
+// error correspondiente. La salida lleva la codificacion de Encoding y, con
+// SaveBOM, la marca de orden de bytes.
 func (this *Document) SaveBytes( ) []byte {
-  var b bytes.Buffer
+  b, err := this.save( )
+  if err != nil {
+    return nil
+  }
+  return this.encodeOutput( b )
+}
+
+// Salva el contenido de este documento como una seccion de bytes, igual que
+// SaveBytes, pero devuelve el error en vez de nil si se excede MaxSaveSize o
+// MaxSaveDepth (ErrSaveLimit) o si con ValidateSave el documento no pasa
+// Validate.
+func (this *Document) SaveBytesE( ) ([]byte, error) {
+  b, err := this.save( )
+  if err != nil {
+    return nil, err
+  }
+  return this.encodeOutput( b ), nil
+}
+
+// Serializa el documento respetando los limites MaxSaveSize y MaxSaveDepth.
+// Todas las funciones Save pasan por aqui, asi que siguen la misma politica:
+// el documento solo debe pasar Validate si ValidateSave esta activo y no se
+// esta en modo Fragment. Por omision no se valida, de modo que un documento
+// vacio o cargado con PROFILE_LENIENT (varios elementos raiz) se salva igual
+// en un archivo, un writer o un string. La salida es UTF-8 sin marca de orden
+// de bytes; ver encodeOutput.
+func (this *Document) save( ) ([]byte, error) {
+  if this.ValidateSave && !this.Fragment {
+    if err := this.Validate( ); err != nil {
+      return nil, err
+    }
+  }
+
+  p := newPrinter( )
+  p.maxSize = this.MaxSaveSize
+  p.maxDepth = this.MaxSaveDepth
+  p.omitEmpty = this.OmitEmpty
+  if this.Newline != "" {
+    p.lineEnd = this.Newline
+  }
+  p.noIndent = this.NoIndent
+  p.maxIndent = this.MaxIndent
+  p.inline = this.Inline
+  p.codecs = this.Codecs
+  p.hooks = hasHooks( this.Root )
 
   if this.SaveDocType {
-    b.WriteString( fmt.Sprintf(`<?xml version="%s" encoding="%s" standalone="%s"?>`, this.Version, this.Encoding, this.StandAlone) )
+    p.WriteString( fmt.Sprintf(`<?xml version="%s" encoding="%s" standalone="%s"?>`, this.Version, this.Encoding, this.StandAlone) )
     if len( IndentPrefix ) > 0 {
-      b.WriteByte( '\n' )
+      p.WriteString( p.lineEnd )
+    }
+  }
+  if this.Root != nil {
+    p.print( this.Root, 0 )
+  }
+  if p.checkSize( ); p.err != nil {
+    return nil, p.err
+  }
+  b, err := this.checkChars( p.Bytes( ) )
+  if err != nil {
+    return nil, err
+  }
+  return b, nil
+}
+
+// Verifica que el documento tenga exactamente un elemento raiz y ningun texto
+// fuera de el, como exige XML. Documentos armados por programa pueden tener
+// varios elementos bajo Root; en modo Fragment estos se aceptan y se salvan
+// tal cual, por lo que Validate solo verifica que haya un Root. Con
+// ValidateSave, las funciones Save la invocan antes de serializar.
+func (this *Document) Validate( ) error {
+  if this.Root == nil {
+    return errors.New( "xmlx: document is empty" )
+  }
+  if this.Fragment {
+    return nil
+  }
+
+  var root *Node
+  for _, v := range this.Root.Children {
+    switch v.Type {
+    case NT_ELEMENT:
+      if root != nil {
+        return fmt.Errorf( "xmlx: multiple root elements (<%s> and <%s>)", root.QualifiedName( ), v.QualifiedName( ) )
+      }
+      root = v
+    case NT_TEXT:
+      if len( strings.TrimSpace( v.Value ) ) > 0 {
+        return errors.New( "xmlx: text content outside the root element" )
+      }
+    case NT_CDATA, NT_ENTITYREF:
+      return errors.New( "xmlx: text content outside the root element" )
     }
   }
-  b.Write( this.Root.Bytes( ) )
-  return b.Bytes( )
+  if root == nil {
+    return errors.New( "xmlx: document has no root element" )
+  }
+  return nil
 }
 
-// Salva el contenido de este documento como un string.
+// Salva el contenido de este documento como un string. Como SaveBytes,
+// devuelve "" si se excede un limite o si con ValidateSave el documento no
+// pasa Validate. El string es texto UTF-8 sin marca de orden de bytes, sea
+// cual sea Encoding o SaveBOM.
 func (this *Document) SaveString( ) string {
-  return string( this.SaveBytes( ) )
+  b, err := this.save( )
+  if err != nil {
+    return ""
+  }
+  return string( b )
 }
 
 // Alias de Document.SaveString().
 // Esta funcion es invocada por todo lo que se refiera al metodo estandar String( ) (ej: fmt.Printf("%s\n", mydoc).
 func (this *Document) String( ) string {
-  return string( this.SaveBytes( ) )
+  return this.SaveString( )
 }
 
 // Salva el contenido de este documento en el writer proporcionado.
 func (this *Document) SaveStream( w io.Writer ) (err error) {
-  _, err = w.Write( this.SaveBytes( ) )
+  var b []byte
+  if b, err = this.SaveBytesE( ); err != nil {
+    return
+  }
+  _, err = w.Write( b )
   return
 }
\ No newline at end of file
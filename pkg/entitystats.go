@@ -0,0 +1,256 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "fmt"
+  "io"
+  "strings"
+)
+
+// LoadStats describes the last load of a document, for monitoring inputs
+// that use entities to blow up their size. Entity references are replaced
+// by encoding/xml from Document.Entity, which does not tell when it does so;
+// they are counted while the input is read instead. Only references in text
+// and attribute values to entities found in Document.Entity count, not
+// character references or the predefined entities (&lt; and the like).
+// The input must be UTF-8 or another ASCII compatible encoding for the
+// counts to be right. With Document.Entity empty and no limits set, there is
+// nothing to count and the input is not scanned.
+type LoadStats struct {
+  InputBytes       int64 // Bytes read from the input.
+  EntityExpansions int   // Entity references replaced by their text.
+  EntityBytes      int64 // Total size of the text the references were replaced by.
+}
+
+// ExpansionFactor returns the size of the input with entity references
+// replaced, relative to the size of the input; 1 if no entities were used.
+func (this LoadStats) ExpansionFactor() float64 {
+  if this.InputBytes == 0 {
+    return 1
+  }
+  return float64(this.InputBytes+this.EntityBytes) / float64(this.InputBytes)
+}
+
+// States of entityCounter.
+const (
+  ecText    = iota // Character data.
+  ecMarkup         // After '<', until the kind of markup is known.
+  ecTag            // Start or end tag, attribute values included.
+  ecComment        // <!-- ... -->
+  ecCDATA          // <![CDATA[ ... ]]>
+  ecPI             // <? ... ?>
+  ecDecl           // <!DOCTYPE ...> and other declarations.
+)
+
+// byteCounter passes the input on to the decoder, counting its bytes into n.
+type byteCounter struct {
+  r io.Reader
+  n *int64
+}
+
+func (this *byteCounter) Read(p []byte) (int, error) {
+  n, err := this.r.Read(p)
+  *this.n += int64(n)
+  return n, err
+}
+
+// entityCounter passes the input on to the decoder, counting the entity
+// references in it into stats. It fails the load once Document.MaxEntities
+// or Document.MaxEntitySize is exceeded. The scanner only knows enough of
+// the XML syntax to tell text and attribute values from comments, CDATA
+// sections, processing instructions and declarations, where references are
+// not replaced.
+type entityCounter struct {
+  r       io.Reader
+  entity  map[string]string
+  stats   *LoadStats
+  maxRefs int
+  maxSize int
+  state   int
+  ret     int    // State to go back to after a comment or PI; ecText or ecDecl.
+  quote   byte   // Open quote in a tag or declaration.
+  depth   int    // '[' nesting in a declaration.
+  buf     []byte // Markup start, end of a comment, CDATA or PI, or reference name.
+  inRef   bool
+  err     error
+}
+
+func (this *Document) newEntityCounter(r io.Reader) *entityCounter {
+  return &entityCounter{
+    r:       r,
+    entity:  this.Entity,
+    stats:   &this.Stats,
+    maxRefs: this.MaxEntities,
+    maxSize: this.MaxEntitySize,
+  }
+}
+
+func (this *entityCounter) Read(p []byte) (int, error) {
+  if this.err != nil {
+    return 0, this.err
+  }
+  n, err := this.r.Read(p)
+  this.stats.InputBytes += int64(n)
+  for _, c := range p[:n] {
+    if this.err = this.scan(c); this.err != nil {
+      return 0, this.err
+    }
+  }
+  return n, err
+}
+
+func (this *entityCounter) scan(c byte) error {
+  if this.inRef {
+    if c == ';' {
+      this.inRef = false
+      return this.ref(string(this.buf))
+    }
+    if len(this.buf) < 64 && strings.IndexByte(" \t\r\n&<>\"'", c) < 0 {
+      this.buf = append(this.buf, c)
+      return nil
+    }
+    this.inRef = false
+  }
+
+  switch this.state {
+  case ecText:
+    if c == '&' {
+      this.inRef, this.buf = true, this.buf[:0]
+    } else if c == '<' {
+      this.state, this.ret, this.buf = ecMarkup, ecText, append(this.buf[:0], c)
+    }
+  case ecMarkup:
+    this.markup(c)
+  case ecTag:
+    this.scanTag(c)
+  case ecComment:
+    if this.endsWith(c, "-->") {
+      this.state = this.ret
+    }
+  case ecCDATA:
+    if this.endsWith(c, "]]>") {
+      this.state = ecText
+    }
+  case ecPI:
+    if this.endsWith(c, "?>") {
+      this.state = this.ret
+    }
+  case ecDecl:
+    this.scanDecl(c)
+  }
+  return nil
+}
+
+// markup finds out what kind of markup follows a '<'.
+func (this *entityCounter) markup(c byte) {
+  this.buf = append(this.buf, c)
+
+  switch {
+  case string(this.buf) == "<?":
+    this.state, this.buf = ecPI, this.buf[:0]
+  case string(this.buf) == "<!--":
+    this.state, this.buf = ecComment, this.buf[:0]
+  case string(this.buf) == "<![CDATA[" && this.ret == ecText:
+    this.state, this.buf = ecCDATA, this.buf[:0]
+  case this.bufPrefixOf("<!--") || (this.bufPrefixOf("<![CDATA[") && this.ret == ecText):
+  case this.ret == ecDecl:
+    // Markup declaration inside the internal subset of a DOCTYPE.
+    this.state = ecDecl
+    for _, b := range this.buf[1:] {
+      this.scanDecl(b)
+    }
+  case this.buf[1] == '!':
+    this.state, this.quote, this.depth = ecDecl, 0, 0
+    for _, b := range this.buf[2:] {
+      this.scanDecl(b)
+    }
+  default:
+    this.state, this.quote = ecTag, 0
+    this.scanTag(c)
+  }
+}
+
+// bufPrefixOf reports whether the markup read so far is the start of s.
+func (this *entityCounter) bufPrefixOf(s string) bool {
+  return len(this.buf) <= len(s) && string(this.buf) == s[:len(this.buf)]
+}
+
+func (this *entityCounter) scanTag(c byte) {
+  if this.quote != 0 {
+    if c == this.quote {
+      this.quote = 0
+    } else if c == '&' {
+      this.inRef, this.buf = true, this.buf[:0]
+    }
+    return
+  }
+
+  switch c {
+  case '"', '\'':
+    this.quote = c
+  case '>':
+    this.state = ecText
+  }
+}
+
+func (this *entityCounter) scanDecl(c byte) {
+  if this.quote != 0 {
+    if c == this.quote {
+      this.quote = 0
+    }
+    return
+  }
+
+  switch c {
+  case '"', '\'':
+    this.quote = c
+  case '[':
+    this.depth++
+  case ']':
+    this.depth--
+  case '<':
+    if this.depth > 0 {
+      this.state, this.ret, this.buf = ecMarkup, ecDecl, append(this.buf[:0], c)
+    }
+  case '>':
+    if this.depth <= 0 {
+      this.state = ecText
+    }
+  }
+}
+
+// endsWith adds c to the last bytes seen and reports whether they end with
+// the given delimiter.
+func (this *entityCounter) endsWith(c byte, delim string) bool {
+  if len(this.buf) >= len(delim) {
+    copy(this.buf, this.buf[1:])
+    this.buf = this.buf[:len(this.buf)-1]
+  }
+  this.buf = append(this.buf, c)
+  return string(this.buf) == delim
+}
+
+// ref counts a reference to the named entity and checks the limits.
+func (this *entityCounter) ref(name string) error {
+  switch name {
+  case "", "lt", "gt", "amp", "apos", "quot":
+    return nil
+  }
+  val, ok := this.entity[name]
+  if !ok || name[0] == '#' {
+    return nil
+  }
+
+  this.stats.EntityExpansions++
+  this.stats.EntityBytes += int64(len(val))
+  if this.maxRefs > 0 && this.stats.EntityExpansions > this.maxRefs {
+    return fmt.Errorf("xmlx: more than %d entity expansions", this.maxRefs)
+  }
+  if this.maxSize > 0 && this.stats.EntityBytes > int64(this.maxSize) {
+    return fmt.Errorf("xmlx: entity expansions exceed %d bytes", this.maxSize)
+  }
+  return nil
+}
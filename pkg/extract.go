@@ -0,0 +1,112 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "errors"
+  "fmt"
+  "sort"
+  "strconv"
+  "strings"
+)
+
+// FieldType selects the Go type a mapped value is converted to by Extract.
+type FieldType int
+
+const (
+  FIELD_STRING FieldType = iota // string
+  FIELD_INT                     // int64
+  FIELD_UINT                    // uint64
+  FIELD_FLOAT                   // float64
+  FIELD_BOOL                    // bool
+)
+
+// Field declares a single value to extract.
+type Field struct {
+  Path     string    // Path to the value, e.g. "order/customer/@id".
+  Type     FieldType // Type to convert the value to.
+  Required bool      // Report an error if the path matches nothing.
+  Multiple bool      // Extract all matches as a slice instead of the first one.
+}
+
+// Mapping declares the values to extract from a document, keyed by the name
+// they are stored under in the result.
+type Mapping map[string]Field
+
+// Extract evaluates every field of the mapping against this node and returns
+// the converted values keyed by field name. Optional fields that match
+// nothing are left out. All problems (bad paths, missing required fields,
+// values that do not convert) are collected and returned together; the
+// result holds every field that could be extracted regardless.
+func (this *Node) Extract(m Mapping) (map[string]interface{}, error) {
+  res := make(map[string]interface{}, len(m))
+  var errs []error
+
+  names := make([]string, 0, len(m))
+  for name := range m {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+
+  for _, name := range names {
+    f := m[name]
+
+    p, err := parsePath(f.Path)
+    if err != nil {
+      errs = append(errs, fmt.Errorf("field %s: %s", name, err))
+      continue
+    }
+
+    limit := 1
+    if f.Multiple {
+      limit = 0
+    }
+    vals := p.values(this, limit)
+    if len(vals) == 0 {
+      if f.Required {
+        errs = append(errs, fmt.Errorf("field %s: %s not found", name, f.Path))
+      }
+      continue
+    }
+
+    conv := make([]interface{}, 0, len(vals))
+    for _, s := range vals {
+      v, err := convertField(s, f.Type)
+      if err != nil {
+        errs = append(errs, fmt.Errorf("field %s: %s", name, err))
+        continue
+      }
+      conv = append(conv, v)
+    }
+
+    if !f.Multiple && len(conv) > 0 {
+      res[name] = conv[0]
+    } else if f.Multiple {
+      res[name] = conv
+    }
+  }
+
+  return res, errors.Join(errs...)
+}
+
+// Extract evaluates the mapping against the document root. See Node.Extract.
+func (this *Document) Extract(m Mapping) (map[string]interface{}, error) {
+  return this.Root.Extract(m)
+}
+
+func convertField(s string, t FieldType) (interface{}, error) {
+  s = strings.TrimSpace(s)
+  switch t {
+  case FIELD_INT:
+    return strconv.ParseInt(s, 10, 64)
+  case FIELD_UINT:
+    return strconv.ParseUint(s, 10, 64)
+  case FIELD_FLOAT:
+    return strconv.ParseFloat(s, 64)
+  case FIELD_BOOL:
+    return strconv.ParseBool(s)
+  }
+  return s, nil
+}
@@ -0,0 +1,272 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// Namespaces of GPX 1.1 and KML 2.2 documents.
+const (
+  GPXNS = "http://www.topografix.com/GPX/1/1"
+  KMLNS = "http://www.opengis.net/kml/2.2"
+)
+
+// GeoPoint is a single position. Ele and Time are zero when unknown.
+type GeoPoint struct {
+  Lat  float64
+  Lon  float64
+  Ele  float64
+  Time time.Time
+  Name string
+}
+
+// GPX holds the waypoints and tracks of a GPX 1.1 document.
+type GPX struct {
+  Creator   string
+  Waypoints []GeoPoint
+  Tracks    []GPXTrack
+}
+
+// GPXTrack is a track made of one or more segments of points.
+type GPXTrack struct {
+  Name     string
+  Segments [][]GeoPoint
+}
+
+// KMLPlacemark is a KML placemark with a Point or LineString geometry.
+type KMLPlacemark struct {
+  Name        string
+  Description string
+  Points      []GeoPoint // A single point for Point geometries.
+}
+
+// GPX reads the document as a GPX 1.1 file.
+func (this *Document) GPX() (*GPX, error) {
+  root := this.documentElement()
+  if root == nil || !root.matches(GPXNS, "gpx") {
+    return nil, fmt.Errorf("xmlx: document is not a GPX file")
+  }
+
+  g := &GPX{Creator: root.As("", "creator")}
+  for _, v := range root.SelectNodes(GPXNS, "wpt") {
+    p, err := gpxPoint(v)
+    if err != nil {
+      return nil, err
+    }
+    g.Waypoints = append(g.Waypoints, p)
+  }
+
+  for _, trk := range root.SelectNodes(GPXNS, "trk") {
+    track := GPXTrack{}
+    if v := childElement(trk, GPXNS, "name"); v != nil {
+      track.Name = v.GetValue()
+    }
+    for _, seg := range trk.SelectNodes(GPXNS, "trkseg") {
+      points := make([]GeoPoint, 0, 16)
+      for _, v := range seg.SelectNodes(GPXNS, "trkpt") {
+        p, err := gpxPoint(v)
+        if err != nil {
+          return nil, err
+        }
+        points = append(points, p)
+      }
+      track.Segments = append(track.Segments, points)
+    }
+    g.Tracks = append(g.Tracks, track)
+  }
+
+  return g, nil
+}
+
+func gpxPoint(n *Node) (GeoPoint, error) {
+  var p GeoPoint
+  var err error
+
+  if p.Lat, err = strconv.ParseFloat(n.As("", "lat"), 64); err != nil {
+    return p, fmt.Errorf("xmlx: %s: invalid lat %q", n.Name.Local, n.As("", "lat"))
+  }
+  if p.Lon, err = strconv.ParseFloat(n.As("", "lon"), 64); err != nil {
+    return p, fmt.Errorf("xmlx: %s: invalid lon %q", n.Name.Local, n.As("", "lon"))
+  }
+  if v := childElement(n, GPXNS, "ele"); v != nil {
+    if p.Ele, err = strconv.ParseFloat(v.GetValue(), 64); err != nil {
+      return p, fmt.Errorf("xmlx: %s: invalid ele %q", n.Name.Local, v.GetValue())
+    }
+  }
+  if v := childElement(n, GPXNS, "time"); v != nil {
+    if p.Time, err = time.Parse(time.RFC3339Nano, v.GetValue()); err != nil {
+      return p, fmt.Errorf("xmlx: %s: invalid time %q", n.Name.Local, v.GetValue())
+    }
+  }
+  if v := childElement(n, GPXNS, "name"); v != nil {
+    p.Name = v.GetValue()
+  }
+  return p, nil
+}
+
+// Document builds a GPX 1.1 document from g, ready to be saved.
+func (this *GPX) Document() *Document {
+  doc := New()
+  doc.Root = NewNode(NT_ROOT)
+
+  root := geoElement(doc.Root, "gpx", "")
+  root.SetAttr("xmlns", GPXNS)
+  root.SetAttr("version", "1.1")
+  root.SetAttr("creator", this.Creator)
+
+  for _, p := range this.Waypoints {
+    gpxPointNode(root, "wpt", p)
+  }
+  for _, t := range this.Tracks {
+    trk := geoElement(root, "trk", "")
+    if t.Name != "" {
+      geoElement(trk, "name", t.Name)
+    }
+    for _, seg := range t.Segments {
+      s := geoElement(trk, "trkseg", "")
+      for _, p := range seg {
+        gpxPointNode(s, "trkpt", p)
+      }
+    }
+  }
+
+  return doc
+}
+
+func gpxPointNode(parent *Node, name string, p GeoPoint) {
+  t := geoElement(parent, name, "")
+  t.SetAttr("lat", formatCoord(p.Lat))
+  t.SetAttr("lon", formatCoord(p.Lon))
+  if p.Ele != 0 {
+    geoElement(t, "ele", formatCoord(p.Ele))
+  }
+  if !p.Time.IsZero() {
+    geoElement(t, "time", p.Time.UTC().Format(time.RFC3339Nano))
+  }
+  if p.Name != "" {
+    geoElement(t, "name", p.Name)
+  }
+}
+
+// KMLPlacemarks returns the placemarks of a KML document, including those
+// nested in folders, in document order. Placemarks with other geometries
+// than Point and LineString are returned without points.
+func (this *Document) KMLPlacemarks() ([]KMLPlacemark, error) {
+  root := this.documentElement()
+  if root == nil || !root.matches(KMLNS, "kml") {
+    return nil, fmt.Errorf("xmlx: document is not a KML file")
+  }
+
+  list := make([]KMLPlacemark, 0, 8)
+  for _, n := range root.SelectNodesRecursive(KMLNS, "Placemark") {
+    pm := KMLPlacemark{}
+    if v := childElement(n, KMLNS, "name"); v != nil {
+      pm.Name = v.GetValue()
+    }
+    if v := childElement(n, KMLNS, "description"); v != nil {
+      pm.Description = v.GetValue()
+    }
+
+    geom := childElement(n, KMLNS, "Point")
+    if geom == nil {
+      geom = childElement(n, KMLNS, "LineString")
+    }
+    if geom != nil {
+      if v := childElement(geom, KMLNS, "coordinates"); v != nil {
+        points, err := parseKMLCoords(v.GetValue())
+        if err != nil {
+          return nil, fmt.Errorf("xmlx: placemark %q: %s", pm.Name, err)
+        }
+        pm.Points = points
+      }
+    }
+    list = append(list, pm)
+  }
+
+  return list, nil
+}
+
+// parseKMLCoords parses a KML coordinate list, "lon,lat[,alt]" tuples
+// separated by white space.
+func parseKMLCoords(s string) ([]GeoPoint, error) {
+  fields := strings.Fields(s)
+  list := make([]GeoPoint, 0, len(fields))
+
+  for _, f := range fields {
+    parts := strings.Split(f, ",")
+    if len(parts) < 2 || len(parts) > 3 {
+      return nil, fmt.Errorf("invalid coordinates %q", f)
+    }
+    var vals [3]float64
+    for i, v := range parts {
+      var err error
+      if vals[i], err = strconv.ParseFloat(v, 64); err != nil {
+        return nil, fmt.Errorf("invalid coordinates %q", f)
+      }
+    }
+    list = append(list, GeoPoint{Lon: vals[0], Lat: vals[1], Ele: vals[2]})
+  }
+
+  return list, nil
+}
+
+// KMLDocument builds a KML 2.2 document holding the given placemarks, ready
+// to be saved. Placemarks with a single point get a Point geometry, those
+// with more a LineString.
+func KMLDocument(placemarks []KMLPlacemark) *Document {
+  doc := New()
+  doc.Root = NewNode(NT_ROOT)
+
+  root := geoElement(doc.Root, "kml", "")
+  root.SetAttr("xmlns", KMLNS)
+  d := geoElement(root, "Document", "")
+
+  for _, pm := range placemarks {
+    t := geoElement(d, "Placemark", "")
+    if pm.Name != "" {
+      geoElement(t, "name", pm.Name)
+    }
+    if pm.Description != "" {
+      geoElement(t, "description", pm.Description)
+    }
+    if len(pm.Points) == 0 {
+      continue
+    }
+
+    geom := "Point"
+    if len(pm.Points) > 1 {
+      geom = "LineString"
+    }
+    coords := make([]string, len(pm.Points))
+    for i, p := range pm.Points {
+      coords[i] = formatCoord(p.Lon) + "," + formatCoord(p.Lat)
+      if p.Ele != 0 {
+        coords[i] += "," + formatCoord(p.Ele)
+      }
+    }
+    geoElement(geoElement(t, geom, ""), "coordinates", strings.Join(coords, " "))
+  }
+
+  return doc
+}
+
+// geoElement adds a new element with the given name and text to parent.
+func geoElement(parent *Node, name, value string) *Node {
+  t := NewNode(NT_ELEMENT)
+  t.Name.Local = name
+  if value != "" {
+    t.SetValue(value)
+  }
+  parent.AddChild(t)
+  return t
+}
+
+func formatCoord(f float64) string {
+  return strconv.FormatFloat(f, 'f', -1, 64)
+}
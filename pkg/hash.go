@@ -0,0 +1,188 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "crypto/sha256"
+  "encoding/binary"
+  "hash"
+  "sort"
+)
+
+// Hash returns the SHA-256 digest of the content of this node and everything
+// below it. Equal content gives equal digests, whatever the document it came
+// from, so digests can be used to find repeated fragments. The digest
+// depends on:
+//
+//   - element names, by namespace URI rather than prefix
+//   - attributes, in any order; namespace declarations are left out
+//   - text, with adjacent text and CDATA nodes joined; whitespace counts
+//   - processing instructions, directives and entity references
+//
+// Comments are ignored. The digest of an element also covers its children
+// in order, but not its position in the document.
+func (this *Node) Hash() []byte {
+  return hashNode(this, nil)
+}
+
+// HashIndex maps subtree digests, as returned by Node.Hash, to the elements
+// they were computed for. Elements of several documents can be added to the
+// same index to find content they share.
+type HashIndex struct {
+  nodes map[string][]*Node
+}
+
+// NewHashIndex returns an empty index.
+func NewHashIndex() *HashIndex {
+  return &HashIndex{nodes: make(map[string][]*Node)}
+}
+
+// Add indexes n and all elements below it. The digests are computed
+// bottom-up in a single pass. Changes made to the tree afterwards are not
+// seen by the index; add the changed nodes again, or build a new index.
+func (this *HashIndex) Add(n *Node) {
+  hashNode(n, this.nodes)
+}
+
+// Find returns the indexed elements with the given digest, in the order they
+// were added.
+func (this *HashIndex) Find(digest []byte) []*Node {
+  return this.nodes[string(digest)]
+}
+
+// Len returns the number of distinct digests in the index.
+func (this *HashIndex) Len() int {
+  return len(this.nodes)
+}
+
+// FindByHash returns the elements of the document whose content has the
+// given digest (see Node.Hash), in document order. The index is built anew
+// on every call; use a HashIndex for repeated lookups or lookups across
+// documents.
+func (this *Document) FindByHash(digest []byte) []*Node {
+  if this.Root == nil {
+    return []*Node{}
+  }
+  idx := NewHashIndex()
+  idx.Add(this.Root)
+  if list := idx.Find(digest); list != nil {
+    return list
+  }
+  return []*Node{}
+}
+
+// hashNode computes the digest of n. If index is not nil, the digests of n
+// and of all elements below it are recorded there.
+func hashNode(n *Node, index map[string][]*Node) []byte {
+  h := sha256.New()
+
+  switch n.Type {
+  case NT_ELEMENT:
+    h.Write([]byte{'E'})
+    hashString(h, n.NamespaceURI())
+    hashString(h, n.Name.Local)
+    hashAttrs(h, n)
+  case NT_TEXT, NT_CDATA:
+    h.Write([]byte{'T'})
+    hashString(h, n.Value)
+  case NT_PROCINST:
+    h.Write([]byte{'P'})
+    hashString(h, n.Target)
+    hashString(h, n.Value)
+  case NT_DIRECTIVE, NT_DOCTYPE:
+    h.Write([]byte{'D'})
+    hashString(h, n.Value)
+  case NT_ENTITYREF:
+    h.Write([]byte{'R'})
+    hashString(h, n.Value)
+  case NT_COMMENT:
+    h.Write([]byte{'C'})
+  default:
+    h.Write([]byte{'N'})
+  }
+
+  text, inText := "", false
+  for _, v := range n.Children {
+    if v.IsText() {
+      text, inText = text+v.Value, true
+      continue
+    }
+    if v.Type == NT_COMMENT {
+      continue
+    }
+    if inText {
+      hashText(h, text)
+      text, inText = "", false
+    }
+    h.Write([]byte{'c'})
+    h.Write(hashNode(v, index))
+  }
+  if inText {
+    hashText(h, text)
+  }
+
+  sum := h.Sum(nil)
+  if index != nil && n.Type == NT_ELEMENT {
+    index[string(sum)] = append(index[string(sum)], n)
+  }
+  return sum
+}
+
+// hashAttrs adds the attributes of n to h, sorted by namespace and name.
+func hashAttrs(h hash.Hash, n *Node) {
+  type attr struct{ space, local, value string }
+  attrs := make([]attr, 0, len(n.Attributes))
+  var ctx map[string]string
+
+  for _, a := range n.Attributes {
+    if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+      continue
+    }
+    space := a.Name.Space
+    if space == "xml" {
+      space = xmlURL
+    } else if space != "" {
+      if ctx == nil {
+        ctx = n.NamespaceContext()
+      }
+      if uri, ok := ctx[space]; ok {
+        space = uri
+      }
+    }
+    attrs = append(attrs, attr{space, a.Name.Local, a.value()})
+  }
+
+  sort.Slice(attrs, func(i, j int) bool {
+    if attrs[i].space != attrs[j].space {
+      return attrs[i].space < attrs[j].space
+    }
+    return attrs[i].local < attrs[j].local
+  })
+
+  for _, a := range attrs {
+    h.Write([]byte{'a'})
+    hashString(h, a.space)
+    hashString(h, a.local)
+    hashString(h, a.value)
+  }
+}
+
+// hashText adds a run of text children to h, the same way a single text
+// node is hashed, so splitting text over several nodes does not matter.
+func hashText(h hash.Hash, text string) {
+  t := sha256.New()
+  t.Write([]byte{'T'})
+  hashString(t, text)
+  h.Write([]byte{'c'})
+  h.Write(t.Sum(nil))
+}
+
+// hashString writes s prefixed with its length, so that consecutive fields
+// cannot run into each other.
+func hashString(h hash.Hash, s string) {
+  var buf [binary.MaxVarintLen64]byte
+  h.Write(buf[:binary.PutUvarint(buf[:], uint64(len(s)))])
+  h.Write([]byte(s))
+}
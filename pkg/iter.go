@@ -0,0 +1,64 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "iter"
+)
+
+// Descendants returns an iterator over all nodes below this one, in document
+// order, for use with range-over-func:
+//
+//   for n := range doc.Root.Descendants() {
+//     ...
+//   }
+//
+// The loop body may change the tree, with the same results as a callback of
+// SelectNodesRecursiveFunc (see Node). No list of nodes is built up front.
+func (this *Node) Descendants() iter.Seq[*Node] {
+  return func(yield func(*Node) bool) {
+    // Nodes still to visit, last one first. Pushing the children of a node
+    // when the walk reaches it takes the snapshot Node describes.
+    type entry struct {
+      parent *Node
+      n      *Node
+      i      int
+    }
+    stack := make([]entry, 0, 32)
+    push := func(p *Node) {
+      for i := len(p.Children) - 1; i >= 0; i-- {
+        stack = append(stack, entry{p, p.Children[i], i})
+      }
+    }
+
+    push(this)
+    for len(stack) > 0 {
+      e := stack[len(stack)-1]
+      stack = stack[:len(stack)-1]
+      if !e.parent.hasChild(e.n, e.i) {
+        continue
+      }
+      if !yield(e.n) {
+        return
+      }
+      if e.parent.hasChild(e.n, e.i) {
+        push(e.n)
+      }
+    }
+  }
+}
+
+// ChildrenSeq returns an iterator over the children of this node. Children
+// removed by the loop body before their turn are skipped; children added are
+// not visited.
+func (this *Node) ChildrenSeq() iter.Seq[*Node] {
+  return func(yield func(*Node) bool) {
+    for i, v := range this.childSnapshot() {
+      if this.hasChild(v, i) && !yield(v) {
+        return
+      }
+    }
+  }
+}
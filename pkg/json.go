@@ -0,0 +1,278 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "reflect"
+  "sort"
+  "strconv"
+  "strings"
+)
+
+// JSONValue maps this node onto values encoding/json can marshal. Attributes
+// become "@name" keys, text becomes "#text" and child elements are keyed by
+// their [prefix:]name, with repeated elements collected in arrays. Elements
+// with neither attributes nor child elements map to their trimmed text.
+// Namespace declarations are left out.
+func (this *Node) JSONValue() interface{} {
+  return this.jsonValue(nil, nil, nil, "")
+}
+
+// ConversionWarning reports a part of the tree that could not be represented
+// exactly by a conversion like JSONValue, and what was done instead.
+type ConversionWarning struct {
+  Path   string // Location of the node concerned, as given by Node.Path.
+  Issue  string // What could not be represented.
+  Policy string // What the conversion did about it.
+}
+
+func (this ConversionWarning) String() string {
+  return this.Path + ": " + this.Issue + "; " + this.Policy
+}
+
+// JSONValueWarnings is like JSONValue, but also reports what the mapping
+// loses: text mixed with child elements, repeated elements that are not
+// adjacent (their order relative to other elements is lost), attributes
+// mapping to the same key, and comments, processing instructions and entity
+// references, which are dropped.
+func (this *Node) JSONValueWarnings() (interface{}, []ConversionWarning) {
+  warn := make([]ConversionWarning, 0)
+  return this.jsonValue(&warn, nil, nil, ""), warn
+}
+
+// jsonValue maps this node, found at the given path relative to the node
+// the conversion started at (see JSONTypes), collecting warnings in warn
+// if it is not nil. Keys are mapped by names.
+func (this *Node) jsonValue(warn *[]ConversionWarning, types *JSONTypes, names *JSONNames, path string) interface{} {
+  obj := make(map[string]interface{})
+
+  for _, a := range this.Attributes {
+    if (a.Name.Space == "" && a.Name.Local == "xmlns") || a.Name.Space == "xmlns" {
+      continue
+    }
+    name := "@" + qualifiedKey(a.Name.Space, a.Name.Local)
+    key := "@" + names.key(a.Name.Space, a.Name.Local)
+    if _, ok := obj[key]; ok && warn != nil {
+      *warn = append(*warn, ConversionWarning{this.Path(), "attributes with the same key " + key, "last value kept"})
+    }
+    obj[key] = types.typed(joinPath(path, name), a.value())
+  }
+
+  text, hasElems, last := "", false, ""
+  var split map[string]bool
+  for _, c := range this.Children {
+    switch c.Type {
+    case NT_TEXT, NT_CDATA:
+      text += strings.TrimSpace(c.Value)
+    case NT_ELEMENT:
+      hasElems = true
+      name := qualifiedKey(c.Name.Space, c.Name.Local)
+      key := names.key(c.Name.Space, c.Name.Local)
+      val := c.jsonValue(warn, types, names, joinPath(path, name))
+      if _, ok := obj[key]; ok && key != last && warn != nil && !split[key] {
+        if split == nil {
+          split = make(map[string]bool)
+        }
+        split[key] = true
+        *warn = append(*warn, ConversionWarning{c.Path(), "repeated element " + key + " not adjacent to the previous one", "collected into an array; order relative to other elements lost"})
+      }
+      last = key
+      switch prev := obj[key].(type) {
+      case nil:
+        if types != nil && types.Arrays[joinPath(path, name)] {
+          obj[key] = []interface{}{val}
+        } else {
+          obj[key] = val
+        }
+      case []interface{}:
+        obj[key] = append(prev, val)
+      default:
+        obj[key] = []interface{}{prev, val}
+      }
+    case NT_COMMENT, NT_PROCINST, NT_ENTITYREF:
+      if warn != nil {
+        *warn = append(*warn, ConversionWarning{c.Path(), strings.ToLower(strings.TrimPrefix(c.Type.String(), "NT_")), "dropped"})
+      }
+    }
+  }
+
+  if text != "" && hasElems && warn != nil {
+    *warn = append(*warn, ConversionWarning{this.Path(), "mixed content", "text joined into #text; its position among child elements lost"})
+  }
+
+  if len(obj) == 0 && this.Type == NT_ELEMENT {
+    return types.typed(path, text)
+  }
+  if text != "" {
+    obj["#text"] = types.typed(path, text)
+  }
+  return obj
+}
+
+// ToJSON returns the JSON encoding of JSONValue, without HTML escaping.
+func (this *Node) ToJSON() ([]byte, error) {
+  return encodeJSON(this.JSONValue())
+}
+
+// ToJSONWarnings is like ToJSON, but also reports what the conversion loses.
+// See JSONValueWarnings.
+func (this *Node) ToJSONWarnings() ([]byte, []ConversionWarning, error) {
+  val, warn := this.JSONValueWarnings()
+  b, err := encodeJSON(val)
+  return b, warn, err
+}
+
+func encodeJSON(val interface{}) ([]byte, error) {
+  var b bytes.Buffer
+  enc := json.NewEncoder(&b)
+  enc.SetEscapeHTML(false)
+  if err := enc.Encode(val); err != nil {
+    return nil, err
+  }
+  return bytes.TrimRight(b.Bytes(), "\n"), nil
+}
+
+// qualifiedKey returns the [prefix:]local name the JSON conversions use
+// before any JSONNames mapping.
+func qualifiedKey(space, local string) string {
+  if space != "" {
+    return space + ":" + local
+  }
+  return local
+}
+
+// NodeFromMap builds an element from nested maps, such as a JSON config
+// decoded into interface{}, using the conventions of JSONValue. m holds a
+// single key, the [prefix:]name of the element; its value is either text
+// (a string, number or boolean), nil for an empty element, or a map whose
+// "@name" keys become attributes, whose "#text" key becomes text and whose
+// other keys become child elements, with slices giving repeated elements.
+// Prefixes are declared with "@xmlns:prefix" keys. Map keys are sorted, as
+// maps have no order, with namespace declarations first. Keys that are not
+// XML names are an error.
+func NodeFromMap(m map[string]interface{}) (*Node, error) {
+  return nodeFromMap(m, nil)
+}
+
+func nodeFromMap(m map[string]interface{}, names *JSONNames) (*Node, error) {
+  if len(m) != 1 {
+    return nil, fmt.Errorf("xmlx: map must have a single key naming the element, found %d", len(m))
+  }
+  for name, v := range m {
+    if name == "" || name[0] == '@' || name[0] == '#' {
+      return nil, fmt.Errorf("xmlx: invalid element name %q", name)
+    }
+    return rec_NodeFromMap(names.fromJSON(name), reflect.ValueOf(v), names)
+  }
+  return nil, nil
+}
+
+func rec_NodeFromMap(name string, v reflect.Value, names *JSONNames) (*Node, error) {
+  if !isQName(name) {
+    return nil, fmt.Errorf("xmlx: invalid element name %q", name)
+  }
+  n := NewNode(NT_ELEMENT)
+  n.Name = splitQName(name)
+  v = mapElem(v)
+  if !v.IsValid() {
+    return n, nil
+  }
+  if s, ok := mapText(v); ok {
+    n.SetValue(s)
+    return n, nil
+  }
+  if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+    return nil, fmt.Errorf("xmlx: <%s>: cannot convert %s", name, v.Type())
+  }
+
+  keys := make([]string, 0, v.Len())
+  for _, k := range v.MapKeys() {
+    keys = append(keys, k.String())
+  }
+  sort.Slice(keys, func(i, j int) bool {
+    xi, xj := isXmlnsName(strings.TrimPrefix(keys[i], "@")), isXmlnsName(strings.TrimPrefix(keys[j], "@"))
+    if xi != xj {
+      return xi
+    }
+    return keys[i] < keys[j]
+  })
+
+  for _, k := range keys {
+    val := mapElem(v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key())))
+    switch {
+    case k == "#text":
+      s, ok := mapText(val)
+      if !ok && val.IsValid() {
+        return nil, fmt.Errorf("xmlx: <%s>: #text must be text, not %s", name, val.Type())
+      }
+      t := NewNode(NT_TEXT)
+      t.Value = s
+      n.AddChild(t)
+    case strings.HasPrefix(k, "@"):
+      s, ok := mapText(val)
+      if !ok && val.IsValid() {
+        return nil, fmt.Errorf("xmlx: <%s>: attribute %s must be text, not %s", name, k[1:], val.Type())
+      }
+      attr := names.fromJSON(k[1:])
+      if !isQName(attr) {
+        return nil, fmt.Errorf("xmlx: <%s>: invalid attribute name %q", name, attr)
+      }
+      n.Attributes = append(n.Attributes, &Attr{Name: splitQName(attr), Value: s})
+    case val.IsValid() && (val.Kind() == reflect.Slice || val.Kind() == reflect.Array) && val.Type().Elem().Kind() != reflect.Uint8:
+      for i := 0; i < val.Len(); i++ {
+        c, err := rec_NodeFromMap(names.fromJSON(k), val.Index(i), names)
+        if err != nil {
+          return nil, err
+        }
+        n.AddChild(c)
+      }
+    default:
+      c, err := rec_NodeFromMap(names.fromJSON(k), val, names)
+      if err != nil {
+        return nil, err
+      }
+      n.AddChild(c)
+    }
+  }
+  return n, nil
+}
+
+// mapElem looks through interfaces and pointers; nil values come out as the
+// zero Value.
+func mapElem(v reflect.Value) reflect.Value {
+  for v.IsValid() && (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) {
+    if v.IsNil() {
+      return reflect.Value{}
+    }
+    v = v.Elem()
+  }
+  return v
+}
+
+// mapText returns the text for a string, number or boolean value.
+func mapText(v reflect.Value) (string, bool) {
+  if !v.IsValid() {
+    return "", false
+  }
+  if n, ok := v.Interface().(json.Number); ok {
+    return n.String(), true
+  }
+  switch v.Kind() {
+  case reflect.String:
+    return v.String(), true
+  case reflect.Bool:
+    return strconv.FormatBool(v.Bool()), true
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    return strconv.FormatInt(v.Int(), 10), true
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    return strconv.FormatUint(v.Uint(), 10), true
+  case reflect.Float32, reflect.Float64:
+    return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()), true
+  }
+  return "", false
+}
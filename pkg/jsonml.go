@@ -0,0 +1,292 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "bytes"
+  "encoding/json"
+  "encoding/xml"
+  "fmt"
+  "io"
+  "sort"
+  "strings"
+)
+
+// JsonML maps this element onto the JsonML convention (http://jsonml.org):
+// every element becomes an array holding its qualified name, an object with
+// its attributes (left out if there are none) and its children in order.
+// Text, including whitespace, becomes a string. Namespace declarations are
+// kept as xmlns attributes, so names keep their meaning. The conversion is
+// not lossless: JsonML has no place for comments, processing instructions
+// and entity references, which are left out, nor for CDATA sections, which
+// become plain text. Attributes are held in a map, so their order is only
+// kept by ToJsonML and ParseJsonML. Attribute functions are called for the
+// values but the tree is not changed.
+func (this *Node) JsonML() interface{} {
+  return this.jsonML(false, nil)
+}
+
+// jsonML builds the JsonML value of this element, with the names mapped by
+// names. With ordered set, the attributes are a jsonMLAttrs, which is
+// encoded in document order.
+func (this *Node) jsonML(ordered bool, names *JSONNames) interface{} {
+  list := []interface{}{names.toJSON(this.QualifiedName())}
+
+  if len(this.Attributes) > 0 {
+    var attrs jsonMLAttrs
+    for _, a := range this.Attributes {
+      val := a.value()
+      if a.Func != nil {
+        val = a.Func(this)
+      }
+      name := a.Name.Local
+      if a.Name.Space != "" {
+        name = this.spacePrefix(a.Name.Space) + ":" + a.Name.Local
+      }
+      attrs = append(attrs, [2]string{names.toJSON(name), val})
+    }
+    if ordered {
+      list = append(list, attrs)
+    } else {
+      m := make(map[string]interface{}, len(attrs))
+      for _, a := range attrs {
+        m[a[0]] = a[1]
+      }
+      list = append(list, m)
+    }
+  }
+
+  for _, c := range this.Children {
+    switch c.Type {
+    case NT_TEXT, NT_CDATA:
+      list = append(list, c.Value)
+    case NT_ELEMENT:
+      list = append(list, c.jsonML(ordered, names))
+    }
+  }
+  return list
+}
+
+// jsonMLAttrs holds attribute names and values in document order, and is
+// encoded as a JSON object in that order.
+type jsonMLAttrs [][2]string
+
+func (this jsonMLAttrs) MarshalJSON() ([]byte, error) {
+  var buf bytes.Buffer
+  buf.WriteRune('{')
+  for i, a := range this {
+    if i > 0 {
+      buf.WriteRune(',')
+    }
+    for j, s := range a {
+      b, err := encodeJSON(s)
+      if err != nil {
+        return nil, err
+      }
+      buf.Write(b)
+      if j == 0 {
+        buf.WriteRune(':')
+      }
+    }
+  }
+  buf.WriteRune('}')
+  return buf.Bytes(), nil
+}
+
+// ToJsonML returns the JSON encoding of JsonML, without HTML escaping and
+// with attributes in document order.
+func (this *Node) ToJsonML() ([]byte, error) {
+  return encodeJSON(this.jsonML(true, nil))
+}
+
+// ParseJsonML builds an element from its JsonML encoding, as written by
+// ToJsonML. Names keep their prefixes, which are bound by the xmlns
+// attributes of the element or of the elements it is added to. Attributes
+// are added in the order they appear in data.
+func ParseJsonML(data []byte) (*Node, error) {
+  return parseJsonML(data, nil)
+}
+
+func parseJsonML(data []byte, names *JSONNames) (*Node, error) {
+  dec := json.NewDecoder(bytes.NewReader(data))
+  dec.UseNumber()
+  tok, err := dec.Token()
+  if err != nil {
+    return nil, err
+  }
+  if tok != json.Delim('[') {
+    return nil, fmt.Errorf("xmlx: JsonML element must be a non-empty array")
+  }
+  n, err := parseJsonMLElement(dec, names)
+  if err != nil {
+    return nil, err
+  }
+  if _, err = dec.Token(); err != io.EOF {
+    return nil, fmt.Errorf("xmlx: JsonML data continues after the element")
+  }
+  return n, nil
+}
+
+// parseJsonMLElement reads the rest of an element array from dec, whose
+// opening '[' has been read, mapping the names back by names.
+func parseJsonMLElement(dec *json.Decoder, names *JSONNames) (*Node, error) {
+  tok, err := dec.Token()
+  if err != nil {
+    return nil, err
+  }
+  name, ok := tok.(string)
+  if tok == json.Delim(']') {
+    return nil, fmt.Errorf("xmlx: JsonML element must be a non-empty array")
+  }
+  if !ok || name == "" {
+    return nil, fmt.Errorf("xmlx: JsonML element name must be a non-empty string")
+  }
+
+  n := NewNode(NT_ELEMENT)
+  n.Name = splitQName(names.fromJSON(name))
+  for first := true; ; first = false {
+    if tok, err = dec.Token(); err != nil {
+      return nil, err
+    }
+
+    switch tok {
+    case json.Delim(']'):
+      return n, nil
+    case json.Delim('['):
+      t, err := parseJsonMLElement(dec, names)
+      if err != nil {
+        return nil, err
+      }
+      n.AddChild(t)
+      continue
+    case json.Delim('{'):
+      if !first {
+        return nil, fmt.Errorf("xmlx: JsonML attributes of <%s> must follow its name", name)
+      }
+      if err = parseJsonMLAttrs(dec, n, name, names); err != nil {
+        return nil, err
+      }
+      continue
+    }
+
+    s, ok := jsonMLText(tok)
+    if !ok {
+      return nil, fmt.Errorf("xmlx: JsonML child of <%s> must be an array or text", name)
+    }
+    t := NewNode(NT_TEXT)
+    t.Value = s
+    n.AddChild(t)
+  }
+}
+
+// parseJsonMLAttrs reads the attribute object of element n from dec, whose
+// opening '{' has been read.
+func parseJsonMLAttrs(dec *json.Decoder, n *Node, name string, names *JSONNames) error {
+  for {
+    tok, err := dec.Token()
+    if err != nil {
+      return err
+    }
+    if tok == json.Delim('}') {
+      return nil
+    }
+    k := tok.(string)
+    if tok, err = dec.Token(); err != nil {
+      return err
+    }
+    val, ok := jsonMLText(tok)
+    if !ok {
+      return fmt.Errorf("xmlx: JsonML attribute %s of <%s> must be a string", k, name)
+    }
+    n.Attributes = append(n.Attributes, &Attr{Name: splitQName(names.fromJSON(k)), Value: val})
+  }
+}
+
+// NodeFromJsonML builds an element from a decoded JsonML value: a
+// []interface{} holding the name, optionally a map[string]interface{} of
+// attributes, and the children. Numbers and booleans are accepted as text.
+// As maps have no order, namespace declarations are added first and the
+// other attributes in name order; ParseJsonML keeps the order of its input.
+func NodeFromJsonML(v interface{}) (*Node, error) {
+  list, ok := v.([]interface{})
+  if !ok || len(list) == 0 {
+    return nil, fmt.Errorf("xmlx: JsonML element must be a non-empty array")
+  }
+  name, ok := list[0].(string)
+  if !ok || name == "" {
+    return nil, fmt.Errorf("xmlx: JsonML element name must be a non-empty string")
+  }
+
+  n := NewNode(NT_ELEMENT)
+  n.Name = splitQName(name)
+  list = list[1:]
+
+  if len(list) > 0 {
+    if attrs, ok := list[0].(map[string]interface{}); ok {
+      keys := make([]string, 0, len(attrs))
+      for k := range attrs {
+        keys = append(keys, k)
+      }
+      sort.Slice(keys, func(i, j int) bool {
+        // Namespace declarations first, then in name order.
+        xi, xj := isXmlnsName(keys[i]), isXmlnsName(keys[j])
+        if xi != xj {
+          return xi
+        }
+        return keys[i] < keys[j]
+      })
+      for _, k := range keys {
+        val, ok := jsonMLText(attrs[k])
+        if !ok {
+          return nil, fmt.Errorf("xmlx: JsonML attribute %s of <%s> must be a string", k, name)
+        }
+        n.Attributes = append(n.Attributes, &Attr{Name: splitQName(k), Value: val})
+      }
+      list = list[1:]
+    }
+  }
+
+  for _, c := range list {
+    if s, ok := jsonMLText(c); ok {
+      t := NewNode(NT_TEXT)
+      t.Value = s
+      n.AddChild(t)
+      continue
+    }
+    t, err := NodeFromJsonML(c)
+    if err != nil {
+      return nil, err
+    }
+    n.AddChild(t)
+  }
+  return n, nil
+}
+
+func jsonMLText(v interface{}) (string, bool) {
+  switch t := v.(type) {
+  case string:
+    return t, true
+  case json.Number:
+    return t.String(), true
+  case float64:
+    return fmt.Sprint(t), true
+  case bool:
+    return fmt.Sprint(t), true
+  }
+  return "", false
+}
+
+// splitQName splits a prefix:local name into the form the loader uses,
+// with the prefix in Space. "xmlns" alone stays a plain name.
+func splitQName(qname string) xml.Name {
+  if i := strings.IndexByte(qname, ':'); i > 0 {
+    return xml.Name{Space: qname[:i], Local: qname[i+1:]}
+  }
+  return xml.Name{Local: qname}
+}
+
+func isXmlnsName(name string) bool {
+  return name == "xmlns" || strings.HasPrefix(name, "xmlns:")
+}
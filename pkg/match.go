@@ -0,0 +1,188 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "fmt"
+  "strings"
+)
+
+// Match checks the element got against pattern, an XML snippet holding a
+// single element, and returns an error naming the path (see Node.Path) of
+// the first difference, or nil if they match. It is meant for assertions in
+// tests:
+//
+//   err := xmlx.Match(resp, `<order id="..."><status>ok</status>...</order>`)
+//
+// Whitespace does not matter: text is compared with leading and trailing
+// whitespace removed and inner runs collapsed, and whitespace-only text is
+// ignored, as are comments and processing instructions. Names are compared
+// by namespace URI and local name, so prefixes may differ. Attributes must
+// be the same on both sides, namespace declarations aside. In the pattern,
+// <any/> stands for any one element, an attribute value or text "..." for
+// any value, and a "..." between elements for any number of nodes.
+//
+// Called with an NT_ROOT node, the document element is matched.
+func Match(got *Node, pattern string) error {
+  doc := New()
+  if err := doc.LoadString(pattern, nil); err != nil {
+    return fmt.Errorf("xmlx: bad pattern: %s", err)
+  }
+  pat := doc.documentElement()
+  if pat == nil {
+    return fmt.Errorf("xmlx: bad pattern: no element")
+  }
+
+  if got != nil && got.Type == NT_ROOT {
+    got = got.FirstChildElement()
+  }
+  if got == nil {
+    return fmt.Errorf("xmlx: /: expected <%s>, found nothing", pat.QualifiedName())
+  }
+  return matchNode(got, pat)
+}
+
+func matchNode(got, pat *Node) error {
+  if pat.Type != NT_ELEMENT {
+    if !got.IsText() {
+      return fmt.Errorf("xmlx: %s: expected text %q, found %s", got.Path(), matchText(pat.Value), matchLabel(got))
+    }
+    if want := matchText(pat.Value); want != matchText(got.Value) {
+      return fmt.Errorf("xmlx: %s: expected text %q, found %q", got.Path(), want, matchText(got.Value))
+    }
+    return nil
+  }
+
+  if got.Type != NT_ELEMENT {
+    return fmt.Errorf("xmlx: %s: expected <%s>, found %s", got.Path(), pat.QualifiedName(), matchLabel(got))
+  }
+  if isAnyPattern(pat) {
+    return nil
+  }
+  if got.Name.Local != pat.Name.Local || got.NamespaceURI() != pat.NamespaceURI() {
+    return fmt.Errorf("xmlx: %s: expected <%s>, found <%s>", got.Path(), pat.QualifiedName(), got.QualifiedName())
+  }
+
+  if err := matchAttrs(got, pat); err != nil {
+    return err
+  }
+  _, err := matchSeq(got, matchChildren(got), matchChildren(pat))
+  return err
+}
+
+func matchAttrs(got, pat *Node) error {
+  for _, a := range pat.Attributes {
+    if isNamespaceDecl(a) {
+      continue
+    }
+    b := findAttr(got, pat, a)
+    switch {
+    case b == nil:
+      return fmt.Errorf("xmlx: %s: missing attribute %s", got.Path(), a.Name.Local)
+    case a.Value != "..." && a.Value != b.value():
+      return fmt.Errorf("xmlx: %s: attribute %s is %q, expected %q", got.Path(), a.Name.Local, b.value(), a.Value)
+    }
+  }
+  for _, b := range got.Attributes {
+    if !isNamespaceDecl(b) && findAttr(pat, got, b) == nil {
+      return fmt.Errorf("xmlx: %s: unexpected attribute %s", got.Path(), b.Name.Local)
+    }
+  }
+  return nil
+}
+
+// findAttr returns the attribute of n with the name of a, an attribute of
+// owner.
+func findAttr(n, owner *Node, a *Attr) *Attr {
+  for _, b := range n.Attributes {
+    if b.Name.Local == a.Name.Local && attrURI(n, b) == attrURI(owner, a) {
+      return b
+    }
+  }
+  return nil
+}
+
+// attrURI returns the namespace URI of a, which holds a prefix or a URI in
+// Name.Space, as seen from n.
+func attrURI(n *Node, a *Attr) string {
+  if a.Name.Space == "" {
+    return ""
+  }
+  if uri, ok := n.NamespaceContext()[a.Name.Space]; ok {
+    return uri
+  }
+  return a.Name.Space
+}
+
+func isNamespaceDecl(a *Attr) bool {
+  return a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns")
+}
+
+// matchSeq matches the children of parent got against the pattern nodes,
+// where "..." text stands for any number of nodes. It returns how many of
+// the nodes were matched before a difference, so that of the ways to place
+// a "..." the error of the one matching most nodes after it is reported.
+func matchSeq(parent *Node, got, pat []*Node) (int, error) {
+  if len(pat) == 0 {
+    if len(got) > 0 {
+      return 0, fmt.Errorf("xmlx: %s: unexpected %s", got[0].Path(), matchLabel(got[0]))
+    }
+    return 0, nil
+  }
+
+  if isEllipsis(pat[0]) {
+    best, skip, bestErr := -1, 0, error(nil)
+    for i := 0; i <= len(got); i++ {
+      n, err := matchSeq(parent, got[i:], pat[1:])
+      if err == nil {
+        return 0, nil
+      }
+      if n >= best {
+        best, skip, bestErr = n, i, err
+      }
+    }
+    return skip + best, bestErr
+  }
+
+  if len(got) == 0 {
+    return 0, fmt.Errorf("xmlx: %s: missing %s", parent.Path(), matchLabel(pat[0]))
+  }
+  if err := matchNode(got[0], pat[0]); err != nil {
+    return 0, err
+  }
+  n, err := matchSeq(parent, got[1:], pat[1:])
+  return n + 1, err
+}
+
+// matchChildren returns the children of n that take part in a match:
+// elements and text that is not whitespace only.
+func matchChildren(n *Node) []*Node {
+  list := make([]*Node, 0, len(n.Children))
+  for _, v := range n.Children {
+    if v.Type == NT_ELEMENT || (v.IsText() && matchText(v.Value) != "") {
+      list = append(list, v)
+    }
+  }
+  return list
+}
+
+func matchText(s string) string {
+  return strings.Join(strings.Fields(s), " ")
+}
+
+func isEllipsis(n *Node) bool {
+  return n.IsText() && matchText(n.Value) == "..."
+}
+
+func isAnyPattern(n *Node) bool {
+  return n.Name.Local == "any" && n.Name.Space == "" && len(n.Attributes) == 0 && len(matchChildren(n)) == 0
+}
+
+func matchLabel(n *Node) string {
+  if n.Type == NT_ELEMENT {
+    return "<" + n.QualifiedName() + ">"
+  }
+  return fmt.Sprintf("text %q", matchText(n.Value))
+}
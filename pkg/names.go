@@ -0,0 +1,159 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "encoding/json"
+  "strings"
+  "unicode"
+)
+
+// JSONNames maps element and attribute names to the keys of the JSON
+// conversions and back, e.g. CamelCase to get keys matching Go and
+// JavaScript conventions. It is passed to the conversions that should use
+// it; the plain ones, like Node.JSONValue, use the names as they are.
+// ToJSON is given names in [prefix:]local form, and attribute keys get
+// their '@' afterwards. FromJSON undoes ToJSON when a tree is built from
+// JSON; without it keys are taken as names. Names that map to the same key
+// are collected in an array, like repeated elements. Namespace
+// declarations are never mapped. A nil *JSONNames maps nothing.
+type JSONNames struct {
+  ToJSON   func(name string) string // Maps a name to a key.
+  FromJSON func(key string) string  // Maps a key back to a name.
+}
+
+// key returns the key for a name in the given namespace.
+func (this *JSONNames) key(space, local string) string {
+  return this.toJSON(qualifiedKey(space, local))
+}
+
+func (this *JSONNames) toJSON(name string) string {
+  if this == nil || this.ToJSON == nil || isXmlnsName(name) {
+    return name
+  }
+  return this.ToJSON(name)
+}
+
+func (this *JSONNames) fromJSON(key string) string {
+  if this == nil || this.FromJSON == nil || isXmlnsName(key) {
+    return key
+  }
+  return this.FromJSON(key)
+}
+
+// Value is like Node.JSONValue, with the names mapped.
+func (this *JSONNames) Value(n *Node) interface{} {
+  return n.jsonValue(nil, nil, this, "")
+}
+
+// Marshal is like Node.ToJSON, with the names mapped.
+func (this *JSONNames) Marshal(n *Node) ([]byte, error) {
+  return encodeJSON(this.Value(n))
+}
+
+// Decode is like Node.DecodeJSON, with the names mapped. It lets XML be
+// decoded into structs that use JSON naming conventions.
+func (this *JSONNames) Decode(n *Node, v interface{}) error {
+  b, err := this.Marshal(n)
+  if err != nil {
+    return err
+  }
+  return json.Unmarshal(b, v)
+}
+
+// NodeFromMap is like the function NodeFromMap, with the keys mapped back
+// to names.
+func (this *JSONNames) NodeFromMap(m map[string]interface{}) (*Node, error) {
+  return nodeFromMap(m, this)
+}
+
+// ToJsonML is like Node.ToJsonML, with the names mapped.
+func (this *JSONNames) ToJsonML(n *Node) ([]byte, error) {
+  return encodeJSON(n.jsonML(true, this))
+}
+
+// ParseJsonML is like the function ParseJsonML, with the names mapped back.
+func (this *JSONNames) ParseJsonML(data []byte) (*Node, error) {
+  return parseJsonML(data, this)
+}
+
+// StripPrefix drops the namespace prefix from a name: "cbc:ID" becomes "ID".
+func StripPrefix(name string) string {
+  if i := strings.IndexByte(name, ':'); i > -1 {
+    return name[i+1:]
+  }
+  return name
+}
+
+// CamelCase turns the local part of a name into lower camel case:
+// "Order-Line", "order_line" and "OrderLine" all become "orderLine", and
+// "customerID" becomes "customerId". A prefix is kept as it is.
+func CamelCase(name string) string {
+  prefix, local := splitPrefix(name)
+  words := nameWords(local)
+  for i, w := range words {
+    w = strings.ToLower(w)
+    if i > 0 {
+      r := []rune(w)
+      r[0] = unicode.ToUpper(r[0])
+      w = string(r)
+    }
+    words[i] = w
+  }
+  return prefix + strings.Join(words, "")
+}
+
+// SnakeCase turns the local part of a name into snake case: "OrderLine"
+// and "order-line" become "order_line", and "customerID" becomes
+// "customer_id". A prefix is kept as it is.
+func SnakeCase(name string) string {
+  prefix, local := splitPrefix(name)
+  words := nameWords(local)
+  for i, w := range words {
+    words[i] = strings.ToLower(w)
+  }
+  return prefix + strings.Join(words, "_")
+}
+
+func splitPrefix(name string) (string, string) {
+  if i := strings.IndexByte(name, ':'); i > -1 {
+    return name[:i+1], name[i+1:]
+  }
+  return "", name
+}
+
+// nameWords splits a name into words at '-', '_' and '.', and where the
+// case changes: "XMLHttpRequest" gives XML, Http and Request.
+func nameWords(name string) []string {
+  words := make([]string, 0, 4)
+  r := []rune(name)
+  start := 0
+  for i := 0; i <= len(r); i++ {
+    if i == len(r) || r[i] == '-' || r[i] == '_' || r[i] == '.' {
+      if i > start {
+        words = append(words, string(r[start:i]))
+      }
+      start = i + 1
+      continue
+    }
+    if i > start && unicode.IsUpper(r[i]) &&
+      (!unicode.IsUpper(r[i-1]) || (i+1 < len(r) && unicode.IsLower(r[i+1]))) {
+      words = append(words, string(r[start:i]))
+      start = i
+    }
+  }
+  return words
+}
+
+// DecodeJSON stores the JSON form of this node, as given by JSONValue, in
+// the value pointed to by v, using encoding/json. JSONNames.Decode does the
+// same with the names mapped.
+func (this *Node) DecodeJSON(v interface{}) error {
+  b, err := this.ToJSON()
+  if err != nil {
+    return err
+  }
+  return json.Unmarshal(b, v)
+}
@@ -7,41 +7,100 @@ package xmlx
 import (
   "bytes"
   "encoding/xml"
+  "errors"
   "fmt"
+  "regexp"
   "strconv"
   "strings"
+  "time"
+  "unicode"
 )
 
+// NodeType identifies what kind of content a Node holds. The NT_* values are
+// stable: new types are only ever appended, so they can be safely persisted
+// or switched on.
+type NodeType byte
+
 const (
-  NT_ROOT = iota
-  NT_DIRECTIVE
-  NT_PROCINST
-  NT_COMMENT
-  NT_TEXT
-  NT_ELEMENT
+  NT_ROOT      NodeType = iota // Document root; has no representation itself.
+  NT_DIRECTIVE                 // <!...> directives other than DOCTYPE.
+  NT_PROCINST                  // <?target value?>
+  NT_COMMENT                   // <!-- value -->
+  NT_TEXT                      // Character data.
+  NT_ELEMENT                   // <name attr="...">...</name>
+  NT_CDATA                     // <![CDATA[value]]>
+  NT_DOCTYPE                   // <!DOCTYPE ...>
+  NT_ENTITYREF                 // &value; left unexpanded.
 )
 
+var nodeTypeNames = [...]string{
+  NT_ROOT:      "NT_ROOT",
+  NT_DIRECTIVE: "NT_DIRECTIVE",
+  NT_PROCINST:  "NT_PROCINST",
+  NT_COMMENT:   "NT_COMMENT",
+  NT_TEXT:      "NT_TEXT",
+  NT_ELEMENT:   "NT_ELEMENT",
+  NT_CDATA:     "NT_CDATA",
+  NT_DOCTYPE:   "NT_DOCTYPE",
+  NT_ENTITYREF: "NT_ENTITYREF",
+}
+
+// String returns the name of the node type constant, e.g. "NT_ELEMENT".
+func (this NodeType) String() string {
+  if int(this) < len(nodeTypeNames) {
+    return nodeTypeNames[this]
+  }
+  return "NodeType(" + strconv.Itoa(int(this)) + ")"
+}
+
 // IndentPrefix holds the value for a single identation level, if one
 // chooses to want indentation in the node.String() and node.Bytes() output.
 // This would normally be set to a single tab, or a number of spaces.
 var IndentPrefix = ""
 
+// Serialization hints, set on individual nodes through Node.Hints. They let
+// parts of a document be written differently from the rest.
+const (
+  HINT_CDATA    = 1 << iota // Write this text node as a CDATA section.
+  HINT_EXPANDED             // Write this element as <a></a> even when empty.
+  HINT_NOINDENT             // Do not indent anything inside this element.
+)
+
 type Attr struct {
-  Name  xml.Name // Attribute namespace and name.
-  Value string   // Attribute value.
+  Name  xml.Name              // Attribute namespace and name.
+  Value string                // Attribute value.
+  Func  func(n *Node) string  // If set, recomputes Value whenever the owning node is serialized.
+
+  spill *spillFile             // Temporary file holding the value, if spilled (see spill.go).
 }
 
+// Node is a single node of the document tree.
+//
+// Functions that call back into user code while walking the tree, like Walk,
+// SelectNodesFunc, SelectNodesRecursiveFunc and OnSave hooks, may have the
+// callback change the tree. Each list of children is copied when the walk
+// reaches it: children added after that are not visited, children removed
+// before their turn are skipped, and a node is only descended into if it is
+// still in place once its callback returns.
 type Node struct {
-  Type       byte     // Node type.
-  Name       xml.Name // Node namespace and name.
-  Children   []*Node  // Child nodes.
-  Attributes []*Attr  // Node attributes.
-  Parent     *Node    // Parent node.
-  Value      string   // Node value.
-  Target     string   // procinst field.
+  Type       NodeType      // Node type.
+  Name       xml.Name      // Node namespace and name.
+  Children   []*Node       // Child nodes.
+  Attributes []*Attr       // Node attributes.
+  Parent     *Node         // Parent node.
+  Value      string        // Node value.
+  Target     string        // procinst field.
+  Hints      int           // Serialization hints (HINT_*).
+  OnSave     func(n *Node) // Called right before this node is serialized.
+
+  // Namespace of the node as it was loaded; see NamespaceURI and Prefix.
+  loadedSpace  string // Name.Space as it was set by the loader.
+  loadedURI    string // Namespace URI the element was found in.
+  loadedPrefix string // Prefix the element was written with.
+  loaded       bool   // The three fields above are set.
 }
 
-func NewNode(tid byte) *Node {
+func NewNode(tid NodeType) *Node {
   n := new(Node)
   n.Type = tid
   n.Children = make([]*Node, 0, 128)
@@ -55,10 +114,58 @@ func (this *Node) Unmarshal(obj interface{}) error {
   return xml.NewDecoder(bytes.NewBuffer(this.bytes())).Decode(obj)
 }
 
+// NodeFromValue is the reverse of Unmarshal: it marshals v with xml.Marshal
+// and returns the element written, with no parent, ready to be added to a
+// tree (e.g. inside a hand-built envelope) with AddChild. Namespaces set with
+// struct tags are declared on the element by encoding/xml, so it keeps them
+// wherever it is put.
+func NodeFromValue(v interface{}) (*Node, error) {
+  b, err := xml.Marshal(v)
+  if err != nil {
+    return nil, err
+  }
+  doc := New()
+  if err = doc.LoadBytes(b, nil); err != nil {
+    return nil, err
+  }
+
+  var elem *Node
+  for _, n := range doc.Root.Children {
+    if n.Type != NT_ELEMENT {
+      continue
+    }
+    if elem != nil {
+      return nil, fmt.Errorf("xmlx: %T marshals to more than one element", v)
+    }
+    elem = n
+  }
+  if elem == nil {
+    return nil, fmt.Errorf("xmlx: %T marshals to no element", v)
+  }
+  elem.Parent = nil
+  return elem, nil
+}
+
+// Returns true if the node is of any of the given types.
+func (this *Node) Is(types ...NodeType) bool {
+  for _, t := range types {
+    if this.Type == t {
+      return true
+    }
+  }
+  return false
+}
+
+// Returns true if this is an element node.
+func (this *Node) IsElement() bool { return this.Type == NT_ELEMENT }
+
+// Returns true if this is a text or CDATA node.
+func (this *Node) IsText() bool { return this.Type == NT_TEXT || this.Type == NT_CDATA }
+
 func (this *Node) GetValue() string {
   res := ""
   for _, node := range this.Children {
-    if node.Type == NT_TEXT {
+    if node.IsText() {
       res += strings.TrimSpace(node.Value)
     }
   }
@@ -213,11 +320,157 @@ func (this *Node) B(namespace, name string) bool {
   return false
 }
 
+// The E getters below work like the ones without E, but report a value that
+// does not convert instead of returning zero. The error names the path of the
+// node (see Path) and wraps the *strconv.NumError. A missing node or an empty
+// value still gives zero and no error.
+
+// Get node value as int64, or an error
+func (this *Node) I64E(namespace, name string) (int64, error) {
+  value, t := this.valueE(namespace, name)
+  if value == "" {
+    return 0, nil
+  }
+  n, err := strconv.ParseInt(value, 10, 64)
+  return n, valueError(t, err)
+}
+
+// Get node value as uint64, or an error
+func (this *Node) U64E(namespace, name string) (uint64, error) {
+  value, t := this.valueE(namespace, name)
+  if value == "" {
+    return 0, nil
+  }
+  n, err := strconv.ParseUint(value, 10, 64)
+  return n, valueError(t, err)
+}
+
+// Get node value as float64, or an error
+func (this *Node) F64E(namespace, name string) (float64, error) {
+  value, t := this.valueE(namespace, name)
+  if value == "" {
+    return 0, nil
+  }
+  n, err := strconv.ParseFloat(value, 64)
+  return n, valueError(t, err)
+}
+
+// Get node value as bool, or an error
+func (this *Node) BE(namespace, name string) (bool, error) {
+  value, t := this.valueE(namespace, name)
+  if value == "" {
+    return false, nil
+  }
+  n, err := strconv.ParseBool(value)
+  return n, valueError(t, err)
+}
+
+// TimeLayouts are the layouts T and At try when none are given: RFC 3339
+// and the XSD dateTime, date and time forms, with and without a zone.
+// Fractional seconds are accepted by all of those with a seconds field.
+var TimeLayouts = []string{
+  time.RFC3339,
+  "2006-01-02T15:04:05",
+  "2006-01-02Z07:00",
+  "2006-01-02",
+  "15:04:05Z07:00",
+  "15:04:05",
+}
+
+// Get node value as a time, parsed with the first of layouts that fits, or
+// with TimeLayouts if none are given. A missing node or an empty value gives
+// the zero time and no error.
+func (this *Node) T(namespace, name string, layouts ...string) (time.Time, error) {
+  value, t := this.valueE(namespace, name)
+  if value == "" {
+    return time.Time{}, nil
+  }
+  tm, ok := parseTime(value, layouts)
+  if !ok {
+    return tm, fmt.Errorf("xmlx: %s: cannot parse %q as a time", t.Path(), value)
+  }
+  return tm, nil
+}
+
+func parseTime(value string, layouts []string) (time.Time, bool) {
+  if len(layouts) == 0 {
+    layouts = TimeLayouts
+  }
+  for _, layout := range layouts {
+    if tm, err := time.Parse(layout, value); err == nil {
+      return tm, true
+    }
+  }
+  return time.Time{}, false
+}
+
+func (this *Node) valueE(namespace, name string) (string, *Node) {
+  t := rec_SelectNode(this, namespace, name)
+  if t == nil {
+    return "", nil
+  }
+  return t.GetValue(), t
+}
+
+func valueError(t *Node, err error) error {
+  if err == nil {
+    return nil
+  }
+  return fmt.Errorf("xmlx: %s: %w", t.Path(), err)
+}
+
+// Set node value as string. Like S, this looks for the first node with the
+// given name at or below this one, and replaces its content with a single
+// text node; if there is none, a child element with that name is added
+// (namespace "*" adds it in no namespace). Returns the node set, or nil if
+// none was found and name is "*".
+func (this *Node) SetS(namespace, name, value string) *Node {
+  n := rec_SelectNode(this, namespace, name)
+  if n == nil {
+    if name == "*" {
+      return nil
+    }
+    n = NewNode(NT_ELEMENT)
+    n.Name.Local = name
+    if namespace != "*" {
+      n.Name.Space = namespace
+    }
+    this.AddChild(n)
+  }
+  n.SetValue(value)
+  return n
+}
+
+// Set node value as int64
+func (this *Node) SetI64(namespace, name string, value int64) *Node {
+  return this.SetS(namespace, name, strconv.FormatInt(value, 10))
+}
+
+// Set node value as uint64
+func (this *Node) SetU64(namespace, name string, value uint64) *Node {
+  return this.SetS(namespace, name, strconv.FormatUint(value, 10))
+}
+
+// Set node value as float64, in the shortest form that reads back the same
+func (this *Node) SetF64(namespace, name string, value float64) *Node {
+  return this.SetS(namespace, name, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// Set node value as bool ("true" or "false")
+func (this *Node) SetBool(namespace, name string, value bool) *Node {
+  return this.SetS(namespace, name, strconv.FormatBool(value))
+}
+
+// Set node value as a time, formatted with layout (e.g. time.RFC3339)
+func (this *Node) SetTime(namespace, name, layout string, value time.Time) *Node {
+  return this.SetS(namespace, name, value.Format(layout))
+}
+
 // Get attribute value as string
 func (this *Node) As(namespace, name string) string {
   for _, v := range this.Attributes {
     if (namespace == "*" || namespace == v.Name.Space) && name == v.Name.Local {
-      return v.Value
+      return v.value()
     }
   }
   return ""
@@ -353,6 +606,19 @@ func (this *Node) Ab(namespace, name string) bool {
   return false
 }
 
+// Get attribute value as a time, as T does for node values
+func (this *Node) At(namespace, name string, layouts ...string) (time.Time, error) {
+  s := this.As(namespace, name)
+  if s == "" {
+    return time.Time{}, nil
+  }
+  tm, ok := parseTime(s, layouts)
+  if !ok {
+    return tm, fmt.Errorf("xmlx: %s/@%s: cannot parse %q as a time", this.Path(), name, s)
+  }
+  return tm, nil
+}
+
 // Returns true if this node has the specified attribute. False otherwise.
 func (this *Node) HasAttr(namespace, name string) bool {
   for _, v := range this.Attributes {
@@ -368,13 +634,76 @@ func (this *Node) HasAttr(namespace, name string) bool {
   return false
 }
 
-// Select single node by name
+// Calls fn for every attribute of this node, in declaration order. The list
+// is copied first, so fn may safely add or remove attributes of this node.
+func (this *Node) EachAttr(fn func(*Attr)) {
+  attrs := make([]*Attr, len(this.Attributes))
+  copy(attrs, this.Attributes)
+  for _, v := range attrs {
+    fn(v)
+  }
+}
+
+// Returns the attributes of this node in the given namespace, in declaration
+// order. The namespace may be given as URI or as prefix, as with the Select
+// functions. Namespace declarations (xmlns attributes) are not included.
+func (this *Node) AttrsInNamespace(uri string) []*Attr {
+  list := make([]*Attr, 0, 4)
+  var ctx map[string]string
+
+  for _, v := range this.Attributes {
+    space := v.Name.Space
+    if space == "" || space == "xmlns" {
+      continue
+    }
+    if space != uri {
+      if ctx == nil {
+        ctx = this.NamespaceContext()
+      }
+      if bound, ok := ctx[space]; !ok || bound != uri {
+        if p, ok := lookupPrefix(ctx, space); !ok || p != uri {
+          continue
+        }
+      }
+    }
+    list = append(list, v)
+  }
+
+  return list
+}
+
+// matches returns true if this node has the given name and namespace, where
+// "*" matches anything. A "*" name only matches elements, so wildcards never
+// select text, comments or the document root. The namespace is compared
+// against Name.Space as well as against NamespaceURI() and Prefix(), so
+// callers can select by URI or by prefix regardless of which one the loader
+// left in Name.Space.
+func (this *Node) matches(namespace, name string) bool {
+  if name == "*" {
+    if this.Type != NT_ELEMENT {
+      return false
+    }
+  } else if this.Name.Local != name {
+    return false
+  }
+  if namespace == "*" || this.Name.Space == namespace {
+    return true
+  }
+  if this.Type != NT_ELEMENT {
+    return false
+  }
+  prefix, uri := this.resolveSpace()
+  return namespace == prefix || (uri != "" && namespace == uri)
+}
+
+// Select single node by name. Either namespace or name may be "*" to match
+// any namespace or any element name.
 func (this *Node) SelectNode(namespace, name string) *Node {
   return rec_SelectNode(this, namespace, name)
 }
 
 func rec_SelectNode(cn *Node, namespace, name string) *Node {
-  if (namespace == "*" || cn.Name.Space == namespace) && (name == "*" || cn.Name.Local == name) {
+  if cn.matches(namespace, name) {
     return cn
   }
 
@@ -404,7 +733,7 @@ func (this *Node) SelectNodesRecursive(namespace, name string) []*Node {
 
 func rec_SelectNodes(cn *Node, namespace, name string, list *[]*Node, recurse bool) {
   for _, v := range cn.Children {
-    if (namespace == "*" || v.Name.Space == namespace) && (name == "*" || v.Name.Local == name) {
+    if v.matches(namespace, name) {
       *list = append(*list, v)
     }
     if recurse {
@@ -413,6 +742,245 @@ func rec_SelectNodes(cn *Node, namespace, name string, list *[]*Node, recurse bo
   }
 }
 
+// Select at most limit descendant nodes by name, in document order. The
+// search stops once limit nodes are found; it finds the same nodes as
+// SelectNodesRecursive otherwise. A limit of 0 or less means no limit.
+func (this *Node) SelectNodesN(namespace, name string, limit int) []*Node {
+  list := make([]*Node, 0, 16)
+  rec_SelectNodesN(this, namespace, name, &list, limit)
+  return list
+}
+
+func rec_SelectNodesN(cn *Node, namespace, name string, list *[]*Node, limit int) bool {
+  for _, v := range cn.Children {
+    if v.matches(namespace, name) {
+      if *list = append(*list, v); limit > 0 && len(*list) >= limit {
+        return false
+      }
+    }
+    if !rec_SelectNodesN(v, namespace, name, list, limit) {
+      return false
+    }
+  }
+  return true
+}
+
+// Calls fn for every descendant node with the given name, in document order,
+// as SelectNodesRecursive would return them, but without building a list.
+// The search ends as soon as fn returns false.
+func (this *Node) SelectNodesStream(namespace, name string, fn func(*Node) bool) {
+  rec_Walk(this, func(n *Node, depth int) WalkAction {
+    if n.matches(namespace, name) && !fn(n) {
+      return WALK_STOP
+    }
+    return WALK_CONTINUE
+  }, 1)
+}
+
+// Select all descendant nodes with the given name in reverse document order:
+// the same nodes as SelectNodesRecursive, last one first.
+func (this *Node) SelectNodesReverse(namespace, name string) []*Node {
+  list := make([]*Node, 0, 16)
+  this.SelectNodesStreamReverse(namespace, name, func(n *Node) bool {
+    list = append(list, n)
+    return true
+  })
+  return list
+}
+
+// Calls fn for every descendant node with the given name in reverse document
+// order, starting with the last match, e.g. the newest entries of a log
+// written to the end of a document. The search ends as soon as fn returns
+// false. Nodes inside a match are visited before it, as their start tags
+// come after its own.
+func (this *Node) SelectNodesStreamReverse(namespace, name string, fn func(*Node) bool) {
+  rec_SelectNodesReverse(this, namespace, name, fn)
+}
+
+func rec_SelectNodesReverse(cn *Node, namespace, name string, fn func(*Node) bool) bool {
+  children := cn.childSnapshot()
+  for i := len(children) - 1; i >= 0; i-- {
+    v := children[i]
+    if !cn.hasChild(v, i) {
+      continue
+    }
+    if !rec_SelectNodesReverse(v, namespace, name, fn) {
+      return false
+    }
+    if cn.hasChild(v, i) && v.matches(namespace, name) && !fn(v) {
+      return false
+    }
+  }
+  return true
+}
+
+// Select the child nodes for which fn returns true.
+func (this *Node) SelectNodesFunc(fn func(*Node) bool) []*Node {
+  list := make([]*Node, 0, 16)
+  rec_SelectNodesFunc(this, fn, &list, false)
+  return list
+}
+
+// Select all descendant nodes for which fn returns true, in document order.
+func (this *Node) SelectNodesRecursiveFunc(fn func(*Node) bool) []*Node {
+  list := make([]*Node, 0, 16)
+  rec_SelectNodesFunc(this, fn, &list, true)
+  return list
+}
+
+func rec_SelectNodesFunc(cn *Node, fn func(*Node) bool, list *[]*Node, recurse bool) {
+  for i, v := range cn.childSnapshot() {
+    if !cn.hasChild(v, i) {
+      continue
+    }
+    if fn(v) {
+      *list = append(*list, v)
+    }
+    if recurse && cn.hasChild(v, i) {
+      rec_SelectNodesFunc(v, fn, list, recurse)
+    }
+  }
+}
+
+// WalkAction tells Walk how to go on after visiting a node.
+type WalkAction int
+
+const (
+  WALK_CONTINUE WalkAction = iota // Visit the children of the node, then go on.
+  WALK_SKIP                       // Do not visit the children of the node.
+  WALK_STOP                       // End the walk.
+)
+
+// Walk calls fn for this node and every node below it, in document order,
+// along with its depth relative to this node, which has depth 0. The
+// result of fn decides whether the walk descends into the node, skips its
+// children or stops altogether. fn may change the tree; see Node.
+func (this *Node) Walk(fn func(n *Node, depth int) WalkAction) {
+  if fn(this, 0) == WALK_CONTINUE {
+    rec_Walk(this, fn, 1)
+  }
+}
+
+// rec_Walk walks the children of cn, which are at the given depth. It
+// returns false once fn asks to stop.
+func rec_Walk(cn *Node, fn func(*Node, int) WalkAction, depth int) bool {
+  for i, v := range cn.childSnapshot() {
+    if !cn.hasChild(v, i) {
+      continue
+    }
+    switch fn(v, depth) {
+    case WALK_STOP:
+      return false
+    case WALK_SKIP:
+      continue
+    }
+    if cn.hasChild(v, i) && !rec_Walk(v, fn, depth+1) {
+      return false
+    }
+  }
+  return true
+}
+
+// childSnapshot returns a copy of the list of children, for walks that call
+// back into user code. See Node.
+func (this *Node) childSnapshot() []*Node {
+  if len(this.Children) == 0 {
+    return nil
+  }
+  return append([]*Node(nil), this.Children...)
+}
+
+// hasChild reports whether v, found at index i of a snapshot of the
+// children, is still a child of this node. Nodes are not required to have
+// their Parent set, so the list itself is checked.
+func (this *Node) hasChild(v *Node, i int) bool {
+  if i < len(this.Children) && this.Children[i] == v {
+    return true
+  }
+  return childIndex(this, v) > -1
+}
+
+// Select all descendant elements with the given name that carry attribute
+// attrName with value attrValue, in document order. attrName may be prefixed
+// (e.g. "xml:lang"); unprefixed names match attributes without namespace. An
+// attrValue of "*" matches any value, so only the presence of the attribute
+// is checked.
+func (this *Node) SelectNodesByAttr(namespace, name, attrName, attrValue string) []*Node {
+  space, local := "", attrName
+  if i := strings.IndexByte(attrName, ':'); i > -1 {
+    space, local = attrName[:i], attrName[i+1:]
+  }
+
+  return this.SelectNodesRecursiveFunc(func(n *Node) bool {
+    if n.Type != NT_ELEMENT || !n.matches(namespace, name) {
+      return false
+    }
+    for _, a := range n.Attributes {
+      if a.Name.Local != local || (attrValue != "*" && a.value() != attrValue) {
+        continue
+      }
+      if a.Name.Space == space || (space == "xml" && a.Name.Space == xmlURL) {
+        return true
+      }
+      if space != "" && n.NamespaceContext()[space] == a.Name.Space {
+        return true
+      }
+    }
+    return false
+  })
+}
+
+// SearchText returns the descendant elements whose own text, as returned by
+// GetValue, matches re, in document order. Text held by child elements does
+// not count, so an element is found together with its parents only when
+// they have matching text of their own.
+func (this *Node) SearchText(re *regexp.Regexp) []*Node {
+  return this.SelectNodesRecursiveFunc(func(n *Node) bool {
+    return n.Type == NT_ELEMENT && re.MatchString(n.GetValue())
+  })
+}
+
+// SearchAttr returns the descendant elements with an attribute value
+// matching re, in document order. Namespace declarations are skipped.
+func (this *Node) SearchAttr(re *regexp.Regexp) []*Node {
+  return this.SelectNodesRecursiveFunc(func(n *Node) bool {
+    if n.Type != NT_ELEMENT {
+      return false
+    }
+    for _, a := range n.Attributes {
+      if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+        continue
+      }
+      if re.MatchString(a.value()) {
+        return true
+      }
+    }
+    return false
+  })
+}
+
+// Select multiple nodes by name, descending at most maxDepth levels below
+// this node. A maxDepth of 1 only looks at the direct children, 2 at the
+// children and grandchildren, and so on. Matching nodes are descended into
+// as well, as with SelectNodesRecursive.
+func (this *Node) SelectNodesDepth(namespace, name string, maxDepth int) []*Node {
+  list := make([]*Node, 0, 16)
+  rec_SelectNodesDepth(this, namespace, name, &list, maxDepth)
+  return list
+}
+
+func rec_SelectNodesDepth(cn *Node, namespace, name string, list *[]*Node, depth int) {
+  if depth <= 0 {
+    return
+  }
+  for _, v := range cn.Children {
+    if v.matches(namespace, name) {
+      *list = append(*list, v)
+    }
+    rec_SelectNodesDepth(v, namespace, name, list, depth-1)
+  }
+}
+
 func (this *Node) RemoveNameSpace() {
   this.Name.Space = ""
   //        this.RemoveAttr("xmlns") //This is questionable
@@ -423,18 +991,30 @@ func (this *Node) RemoveNameSpace() {
 }
 
 func (this *Node) RemoveAttr(name string) {
-  for i, v := range this.Attributes {
-    if name == v.Name.Local {
-      //Delete it
-      this.Attributes = append(this.Attributes[:i], this.Attributes[i+1:]...)
+  this.RemoveAttrNS("*", name)
+}
+
+// Removes the attributes with the given namespace and name, keeping the order
+// of the others. The namespace is compared with Name.Space, as with As; "*"
+// matches any namespace.
+func (this *Node) RemoveAttrNS(namespace, name string) {
+  keep := this.Attributes[:0]
+  for _, v := range this.Attributes {
+    if (namespace == "*" || namespace == v.Name.Space) && name == v.Name.Local {
+      continue
     }
+    keep = append(keep, v)
+  }
+  for i := len(keep); i < len(this.Attributes); i++ {
+    this.Attributes[i] = nil
   }
+  this.Attributes = keep
 }
 
 func (this *Node) SetAttr(name, value string) {
   for _, v := range this.Attributes {
     if name == v.Name.Local {
-      v.Value = value
+      v.set(value)
       return
     }
   }
@@ -447,28 +1027,53 @@ func (this *Node) SetAttr(name, value string) {
   return
 }
 
+// Sets the value of the attribute with the given namespace and name, which is
+// added after the existing ones if it does not exist yet. The namespace is
+// stored in Name.Space as given, so it should be a prefix declared in scope
+// (or its URI if the document was loaded with URISpaces); "" stands for no
+// namespace.
+func (this *Node) SetAttrNS(namespace, name, value string) {
+  for _, v := range this.Attributes {
+    if namespace == v.Name.Space && name == v.Name.Local {
+      v.set(value)
+      return
+    }
+  }
+  attr := new(Attr)
+  attr.Name.Space = namespace
+  attr.Name.Local = name
+  attr.Value = value
+  this.Attributes = append(this.Attributes, attr)
+}
+
+// Registers a function that computes the value of the given attribute every
+// time this node is serialized (e.g. timestamps, checksums or counts). The
+// attribute is added if it does not exist yet.
+func (this *Node) SetAttrFunc(name string, fn func(n *Node) string) {
+  for _, v := range this.Attributes {
+    if name == v.Name.Local {
+      v.unspill()
+      v.Func = fn
+      return
+    }
+  }
+  attr := new(Attr)
+  attr.Name.Local = name
+  attr.Func = fn
+  this.Attributes = append(this.Attributes, attr)
+}
+
 // Convert node to appropriate []byte representation based on it's @Type.
 // Note that NT_ROOT is a special-case empty node used as the root for a
 // Document. This one has no representation by itself. It merely forwards the
 // String() call to it's child nodes.
 func (this *Node) Bytes() []byte { return this.bytes() }
 
-func (this *Node) bytes() (b []byte) {
-  switch this.Type {
-  case NT_PROCINST:
-    b = this.printProcInst()
-  case NT_COMMENT:
-    b = this.printComment()
-  case NT_DIRECTIVE:
-    b = this.printDirective()
-  case NT_ELEMENT:
-    b = this.printElement()
-  case NT_TEXT:
-    b = this.printText()
-  case NT_ROOT:
-    b = this.printRoot()
-  }
-  return
+func (this *Node) bytes() []byte {
+  p := newPrinter()
+  p.hooks = hasHooks(this)
+  p.print(this, 0)
+  return p.Bytes()
 }
 
 // Convert node to appropriate string representation based on it's @Type.
@@ -479,90 +1084,305 @@ func (this *Node) String() (s string) {
   return string(this.bytes())
 }
 
-func (this *Node) printRoot() []byte {
-  var b bytes.Buffer
-  for _, v := range this.Children {
-    b.Write(v.bytes())
-  }
-  return b.Bytes()
+// ErrSaveLimit is returned when serializing a document would exceed its
+// MaxSaveSize or MaxSaveDepth.
+var ErrSaveLimit = errors.New("xmlx: save limit exceeded")
+
+// attrEscaper escapes attribute values as xml.EscapeText does. It replaces
+// single bytes, so a spilled value can be escaped piece by piece as it is
+// copied to the output.
+var attrEscaper = strings.NewReplacer(
+  "&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&#34;", "'", "&#39;",
+  "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+
+// textEscaper escapes text mixed with other nodes. Unlike xml.EscapeText it
+// keeps line breaks and tabs, which are part of the layout of such content.
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+
+// printer holds the state of a single serialization run.
+type printer struct {
+  bytes.Buffer
+  indent    bool               // Indentation is in effect for the node being printed.
+  maxSize   int                // Maximum output size in bytes; 0 for no limit.
+  maxDepth  int                // Maximum element nesting; 0 for no limit.
+  omitEmpty []string           // Names of elements left out when empty; see omit.
+  lineEnd   string             // Line break written when indenting; see Document.Newline.
+  noIndent  []string           // Names of elements whose content is not indented; see Document.NoIndent.
+  maxIndent int                // Levels of indentation; 0 for no limit. See Document.MaxIndent.
+  inline    []string           // Names of elements that are part of running text; see Document.Inline.
+  codecs    map[string]Codec   // Codecs encoding element text; see Codec.
+  hooks     bool               // The tree has callbacks that may change it; see children.
+  err       error              // First error encountered; stops all further output.
 }
 
-func (this *Node) printProcInst() []byte {
-  return []byte("<?" + this.Target + " " + this.Value + "?>")
+func newPrinter() *printer {
+  return &printer{indent: len(IndentPrefix) > 0, lineEnd: "\n"}
 }
 
-func (this *Node) printComment() []byte {
-  return []byte("<!-- " + this.Value + " -->")
-}
+func (p *printer) print(n *Node, depth int) {
+  if p.checkSize(); p.err != nil || p.omit(n) {
+    return
+  }
+  if n.OnSave != nil {
+    n.OnSave(n)
+  }
 
-func (this *Node) printDirective() []byte {
-  return []byte("<!" + this.Value + "!>")
+  switch n.Type {
+  case NT_PROCINST:
+    p.WriteString("<?" + n.Target + " " + n.Value + "?>")
+  case NT_COMMENT:
+    p.WriteString("<!-- " + n.Value + " -->")
+  case NT_DIRECTIVE:
+    p.WriteString("<!" + n.Value + "!>")
+  case NT_DOCTYPE:
+    p.WriteString("<!" + n.Value + ">")
+  case NT_ENTITYREF:
+    p.WriteString("&" + n.Value + ";")
+  case NT_CDATA:
+    p.printCData(n.Value)
+  case NT_TEXT:
+    p.printText(n)
+  case NT_ELEMENT:
+    p.printElement(n, depth)
+  case NT_ROOT:
+    p.printChildren(n, -1)
+  }
 }
 
-func (this *Node) printText() []byte {
-  val := []byte(this.Value)
-  if len(this.Parent.Children) > 1 {
-    return val
+// checkSize sets p.err once the output has grown beyond p.maxSize.
+func (p *printer) checkSize() {
+  if p.err == nil && p.maxSize > 0 && p.Len() > p.maxSize {
+    p.err = fmt.Errorf("%w: output exceeds %d bytes", ErrSaveLimit, p.maxSize)
   }
-  var b bytes.Buffer
-  xml.EscapeText(&b, val)
-  return b.Bytes()
 }
 
-func (this *Node) printElement() []byte {
-  var b bytes.Buffer
+// A CDATA section cannot contain "]]>", so such values are split over
+// several adjacent sections.
+func (p *printer) printCData(val string) {
+  p.WriteString("<![CDATA[")
+  p.WriteString(strings.Replace(val, "]]>", "]]]]><![CDATA[>", -1))
+  p.WriteString("]]>")
+}
 
-  if len(this.Name.Space) > 0 {
-    b.WriteRune('<')
-    b.WriteString(this.Name.Space)
-    b.WriteRune(':')
-    b.WriteString(this.Name.Local)
-  } else {
-    b.WriteRune('<')
-    b.WriteString(this.Name.Local)
+func (p *printer) printText(n *Node) {
+  if n.Hints&HINT_CDATA != 0 {
+    p.printCData(n.Value)
+    return
   }
+  if n.Parent != nil && len(n.Parent.Children) > 1 {
+    textEscaper.WriteString(p, n.Value)
+    return
+  }
+  xml.EscapeText(p, []byte(n.Value))
+}
 
-  for _, v := range this.Attributes {
+func (p *printer) printElement(n *Node, depth int) {
+  if p.maxDepth > 0 && depth >= p.maxDepth {
+    p.err = fmt.Errorf("%w: elements nested deeper than %d levels", ErrSaveLimit, p.maxDepth)
+    return
+  }
+
+  p.WriteRune('<')
+  p.WriteString(n.QualifiedName())
+
+  for _, v := range n.Attributes {
+    if v.Func != nil {
+      v.Value = v.Func(n)
+    }
+    p.WriteRune(' ')
     if len(v.Name.Space) > 0 {
-      prefix := this.spacePrefix(v.Name.Space)
-      b.WriteString(fmt.Sprintf(` %s:%s="%s"`, prefix, v.Name.Local, v.Value))
+      p.WriteString(n.spacePrefix(v.Name.Space) + ":")
+    }
+    p.WriteString(v.Name.Local + `="`)
+    if v.spill != nil {
+      p.printSpilled(v)
     } else {
-      b.WriteString(fmt.Sprintf(` %s="%s"`, v.Name.Local, v.Value))
+      attrEscaper.WriteString(p, v.Value)
     }
+    p.WriteRune('"')
   }
 
-  if len(this.Children) == 0 && len(this.Value) == 0 {
-    b.WriteString(" />")
-    return b.Bytes()
+  if len(p.codecs) > 0 && p.printEncoded(n, depth) {
+    return
   }
 
-  b.WriteRune('>')
-
-  for _, v := range this.Children {
-    b.Write(v.bytes())
+  if p.omitChildren(n) && len(n.Value) == 0 && n.Hints&HINT_EXPANDED == 0 {
+    p.WriteString(" />")
+    return
   }
 
-  xml.EscapeText(&b, []byte(this.Value))
-  if len(this.Name.Space) > 0 {
-    b.WriteString("</")
-    b.WriteString(this.Name.Space)
-    b.WriteRune(':')
-    b.WriteString(this.Name.Local)
-    b.WriteRune('>')
+  p.WriteRune('>')
+
+  if indent := p.indent; indent && (n.Hints&HINT_NOINDENT != 0 || hasName(n, p.noIndent) ||
+    (p.maxIndent > 0 && depth >= p.maxIndent)) {
+    p.indent = false
+    p.printChildren(n, depth)
+    p.indent = indent
   } else {
-    b.WriteString("</")
-    b.WriteString(this.Name.Local)
-    b.WriteRune('>')
+    p.printChildren(n, depth)
   }
 
-  return b.Bytes()
+  xml.EscapeText(p, []byte(n.Value))
+  p.WriteString("</")
+  p.WriteString(n.QualifiedName())
+  p.WriteRune('>')
 }
 
-// spacePrefix resolves the given space (e.g. a url) to the prefix it was
-// assigned by an attribute by the current node, or one of its parents.
-func (this *Node) spacePrefix(space string) string {
-  for _, attr := range this.Attributes {
-    if attr.Name.Space == "xmlns" && attr.Value == space {
+// printChildren writes the children of n, which lives at the given depth.
+// When indenting, every child goes on a line of its own and whitespace-only
+// text is dropped, unless n has mixed or text-only content: adding or
+// dropping whitespace there would change the text. The document root has
+// depth -1; its children are put on separate lines but not indented.
+func (p *printer) printChildren(n *Node, depth int) {
+  if !p.indent || hasMixedContent(n, p.inline) {
+    indent := p.indent
+    if p.inline != nil {
+      p.indent = false
+    }
+    for i, v := range p.children(n) {
+      if p.hasChild(n, v, i) {
+        p.print(v, depth+1)
+      }
+    }
+    p.indent = indent
+    return
+  }
+
+  wrote := false
+  for i, v := range p.children(n) {
+    if !p.hasChild(n, v, i) || (v.Type == NT_TEXT && len(strings.TrimSpace(v.Value)) == 0) || p.omit(v) {
+      continue
+    }
+    if depth >= 0 || wrote {
+      p.newline(depth + 1)
+    }
+    p.print(v, depth+1)
+    wrote = true
+  }
+
+  if wrote && depth >= 0 {
+    p.newline(depth)
+  }
+}
+
+// children returns the children of n to print. When OnSave hooks or
+// attribute functions may add or remove nodes while the tree is printed, a
+// snapshot is returned, and hasChild tells which of its nodes are still
+// children; otherwise the list is walked in place.
+func (p *printer) children(n *Node) []*Node {
+  if p.hooks {
+    return n.childSnapshot()
+  }
+  return n.Children
+}
+
+func (p *printer) hasChild(n, v *Node, i int) bool {
+  return !p.hooks || n.hasChild(v, i)
+}
+
+// hasHooks reports whether n or a node below it has an OnSave hook or an
+// attribute function.
+func hasHooks(n *Node) bool {
+  if n == nil {
+    return false
+  }
+  if n.OnSave != nil {
+    return true
+  }
+  for _, a := range n.Attributes {
+    if a.Func != nil {
+      return true
+    }
+  }
+  for _, v := range n.Children {
+    if hasHooks(v) {
+      return true
+    }
+  }
+  return false
+}
+
+// omit reports whether n is left out of the output: an element named in
+// omitEmpty, or any element if it holds "*", without attributes and without
+// content other than whitespace and elements that are left out themselves.
+func (p *printer) omit(n *Node) bool {
+  if n.Type != NT_ELEMENT || len(n.Attributes) > 0 || len(n.Value) > 0 || !p.omitListed(n) {
+    return false
+  }
+  return p.omitChildren(n)
+}
+
+func (p *printer) omitListed(n *Node) bool {
+  for _, name := range p.omitEmpty {
+    if name == "*" || name == n.Name.Local {
+      return true
+    }
+  }
+  return false
+}
+
+// omitChildren reports whether none of the children of n is written, so n
+// can be written as an empty element. Whitespace-only text counts as not
+// written in elements named in omitEmpty, and where indenting drops it.
+func (p *printer) omitChildren(n *Node) bool {
+  blank := len(p.omitEmpty) > 0 && (p.omitListed(n) || (p.indent && !hasMixedContent(n, p.inline)))
+  for _, v := range n.Children {
+    if blank && v.Type == NT_TEXT && v.Hints&HINT_CDATA == 0 && len(strings.TrimSpace(v.Value)) == 0 {
+      continue
+    }
+    if !p.omit(v) {
+      return false
+    }
+  }
+  return true
+}
+
+func (p *printer) newline(depth int) {
+  p.WriteString(p.lineEnd)
+  for i := 0; i < depth; i++ {
+    p.WriteString(IndentPrefix)
+  }
+}
+
+// hasMixedContent returns true if n holds text other than whitespace or
+// elements named in inline, or holds nothing but text.
+func hasMixedContent(n *Node, inline []string) bool {
+  textOnly := true
+  for _, v := range n.Children {
+    switch v.Type {
+    case NT_CDATA, NT_ENTITYREF:
+      return true
+    case NT_TEXT:
+      if v.Hints&HINT_CDATA != 0 || len(strings.TrimSpace(v.Value)) > 0 {
+        return true
+      }
+    case NT_ELEMENT:
+      if hasName(v, inline) {
+        return true
+      }
+      textOnly = false
+    default:
+      textOnly = false
+    }
+  }
+  return textOnly
+}
+
+// hasName reports whether the local name of n is in names.
+func hasName(n *Node, names []string) bool {
+  for _, name := range names {
+    if n.Name.Local == name {
+      return true
+    }
+  }
+  return false
+}
+
+// spacePrefix resolves the given space (e.g. a url) to the prefix it was
+// assigned by an attribute by the current node, or one of its parents.
+func (this *Node) spacePrefix(space string) string {
+  for _, attr := range this.Attributes {
+    if attr.Name.Space == "xmlns" && attr.Value == space {
       return attr.Name.Local
     }
   }
@@ -572,6 +1392,248 @@ func (this *Node) spacePrefix(space string) string {
   return this.Parent.spacePrefix(space)
 }
 
+// Clone returns a deep copy of this node and everything below it: attributes,
+// children and values, with the Parent pointers of the copies pointing into
+// the copy. The copy itself has no parent; add it where it belongs with
+// AddChild or InsertBefore. Attribute functions and OnSave hooks are shared
+// with the original.
+//
+// Namespace declarations made by ancestors of this node are not copied. To
+// use the copy in another document, see ToDocument and ImportNode.
+func (this *Node) Clone() *Node {
+  t := this.cloneShallow()
+  if len(this.Children) > 0 {
+    t.Children = make([]*Node, len(this.Children))
+    for i, v := range this.Children {
+      t.Children[i] = v.Clone()
+      t.Children[i].Parent = t
+    }
+  }
+  return t
+}
+
+// cloneShallow copies this node and its attributes, but not its children.
+func (this *Node) cloneShallow() *Node {
+  t := &Node{
+    Type:         this.Type,
+    Name:         this.Name,
+    Value:        this.Value,
+    Target:       this.Target,
+    Hints:        this.Hints,
+    OnSave:       this.OnSave,
+    loadedSpace:  this.loadedSpace,
+    loadedURI:    this.loadedURI,
+    loadedPrefix: this.loadedPrefix,
+    loaded:       this.loaded,
+  }
+
+  if len(this.Attributes) > 0 {
+    t.Attributes = make([]*Attr, len(this.Attributes))
+    for i, a := range this.Attributes {
+      t.Attributes[i] = a.copy()
+    }
+  }
+  return t
+}
+
+// Ancestors returns the parent chain of this node, starting with its parent
+// and ending with the top of the tree (the NT_ROOT node for loaded
+// documents).
+func (this *Node) Ancestors() []*Node {
+  list := make([]*Node, 0, 8)
+  for p := this.Parent; p != nil; p = p.Parent {
+    list = append(list, p)
+  }
+  return list
+}
+
+// FindAncestor returns the nearest ancestor element with the given namespace
+// and name, or nil if there is none. Wildcards work as with the Select
+// functions.
+func (this *Node) FindAncestor(namespace, name string) *Node {
+  for p := this.Parent; p != nil; p = p.Parent {
+    if p.Type == NT_ELEMENT && p.matches(namespace, name) {
+      return p
+    }
+  }
+  return nil
+}
+
+// Path returns the location of this node as an XPath-like string, e.g.
+// "/catalog/book[3]/title", for use in error messages and logs. Elements are
+// given by their qualified name, with their position among siblings of the
+// same name when there is more than one. Other nodes are given as text(),
+// comment(), processing-instruction(target) or node(), numbered the same
+// way among siblings of their kind. The NT_ROOT node is "/". For a subtree
+// that is not attached to a document, the path starts at its top node.
+//
+// Paths of elements can be passed to Document.SelectNodeByPath to find the
+// node again, as long as the tree has not changed.
+func (this *Node) Path() string {
+  if this.Type == NT_ROOT {
+    return "/"
+  }
+
+  steps := make([]string, 0, 8)
+  for n := this; n != nil && n.Type != NT_ROOT; n = n.Parent {
+    steps = append(steps, locationStep(n))
+  }
+
+  for i, j := 0, len(steps)-1; i < j; i, j = i+1, j-1 {
+    steps[i], steps[j] = steps[j], steps[i]
+  }
+  return "/" + strings.Join(steps, "/")
+}
+
+// locationStep returns the step of Path for n, with its position if it has
+// siblings of the same kind.
+func locationStep(n *Node) string {
+  var step string
+  switch n.Type {
+  case NT_ELEMENT:
+    step = n.QualifiedName()
+  case NT_TEXT, NT_CDATA:
+    step = "text()"
+  case NT_COMMENT:
+    step = "comment()"
+  case NT_PROCINST:
+    step = "processing-instruction(" + n.Target + ")"
+  default:
+    step = "node()"
+  }
+
+  if n.Parent == nil {
+    return step
+  }
+
+  pos, count := 0, 0
+  for _, v := range n.Parent.Children {
+    if !sameLocationKind(v, n) {
+      continue
+    }
+    count++
+    if v == n {
+      pos = count
+    }
+  }
+  if count > 1 {
+    step += "[" + strconv.Itoa(pos) + "]"
+  }
+  return step
+}
+
+func sameLocationKind(a, b *Node) bool {
+  switch b.Type {
+  case NT_ELEMENT:
+    return a.Type == NT_ELEMENT && a.Name == b.Name
+  case NT_TEXT, NT_CDATA:
+    return a.IsText()
+  case NT_PROCINST:
+    return a.Type == NT_PROCINST && a.Target == b.Target
+  case NT_COMMENT:
+    return a.Type == NT_COMMENT
+  }
+  return a.Type == NT_DIRECTIVE || a.Type == NT_DOCTYPE || a.Type == NT_ENTITYREF
+}
+
+// FirstChildElement returns the first child element of this node, or nil if
+// it has none. Text, comments and other nodes are skipped.
+func (this *Node) FirstChildElement() *Node {
+  for _, v := range this.Children {
+    if v.Type == NT_ELEMENT {
+      return v
+    }
+  }
+  return nil
+}
+
+// LastChildElement returns the last child element of this node, or nil if
+// it has none.
+func (this *Node) LastChildElement() *Node {
+  for i := len(this.Children) - 1; i >= 0; i-- {
+    if v := this.Children[i]; v.Type == NT_ELEMENT {
+      return v
+    }
+  }
+  return nil
+}
+
+// ChildElements returns the child elements of this node, in document order.
+func (this *Node) ChildElements() []*Node {
+  list := make([]*Node, 0, len(this.Children))
+  for _, v := range this.Children {
+    if v.Type == NT_ELEMENT {
+      list = append(list, v)
+    }
+  }
+  return list
+}
+
+// ChildElementCount returns the number of child elements of this node.
+func (this *Node) ChildElementCount() int {
+  count := 0
+  for _, v := range this.Children {
+    if v.Type == NT_ELEMENT {
+      count++
+    }
+  }
+  return count
+}
+
+// NextSibling returns the node following this one under the same parent, or
+// nil if there is none.
+func (this *Node) NextSibling() *Node {
+  if this.Parent == nil {
+    return nil
+  }
+  if i := childIndex(this.Parent, this); i > -1 && i+1 < len(this.Parent.Children) {
+    return this.Parent.Children[i+1]
+  }
+  return nil
+}
+
+// PrevSibling returns the node preceding this one under the same parent, or
+// nil if there is none.
+func (this *Node) PrevSibling() *Node {
+  if this.Parent == nil {
+    return nil
+  }
+  if i := childIndex(this.Parent, this); i > 0 {
+    return this.Parent.Children[i-1]
+  }
+  return nil
+}
+
+// NextSiblingElement returns the first element following this node under the
+// same parent, skipping text, comments and other nodes, or nil.
+func (this *Node) NextSiblingElement() *Node {
+  if this.Parent == nil {
+    return nil
+  }
+  if i := childIndex(this.Parent, this); i > -1 {
+    for _, v := range this.Parent.Children[i+1:] {
+      if v.Type == NT_ELEMENT {
+        return v
+      }
+    }
+  }
+  return nil
+}
+
+// PrevSiblingElement returns the last element preceding this node under the
+// same parent, skipping text, comments and other nodes, or nil.
+func (this *Node) PrevSiblingElement() *Node {
+  if this.Parent == nil {
+    return nil
+  }
+  for i := childIndex(this.Parent, this) - 1; i >= 0; i-- {
+    if v := this.Parent.Children[i]; v.Type == NT_ELEMENT {
+      return v
+    }
+  }
+  return nil
+}
+
 // Add a child node
 func (this *Node) AddChild(t *Node) {
   if t.Parent != nil {
@@ -581,6 +1643,172 @@ func (this *Node) AddChild(t *Node) {
   this.Children = append(this.Children, t)
 }
 
+// AddChildren adds the given nodes as the last children of this node, in
+// order, as AddChild would one by one, but grows Children only once. A node
+// given more than once ends up where it is given last.
+func (this *Node) AddChildren(nodes ...*Node) {
+  if len(nodes) > 1 {
+    last := make(map[*Node]int, len(nodes))
+    for i, t := range nodes {
+      last[t] = i
+    }
+    if len(last) < len(nodes) {
+      kept := make([]*Node, 0, len(last))
+      for i, t := range nodes {
+        if last[t] == i {
+          kept = append(kept, t)
+        }
+      }
+      nodes = kept
+    }
+  }
+
+  for _, t := range nodes {
+    if t.Parent != nil {
+      t.Parent.RemoveChild(t)
+    }
+  }
+
+  if n := len(this.Children) + len(nodes); n > cap(this.Children) {
+    children := make([]*Node, len(this.Children), n)
+    copy(children, this.Children)
+    this.Children = children
+  }
+  for _, t := range nodes {
+    t.Parent = this
+    this.Children = append(this.Children, t)
+  }
+}
+
+// AddComment adds a comment with the given text as the last child of this
+// node, or, on the NT_ROOT node, before the document element. As comments
+// cannot hold "--", any are broken up with a space. Returns the comment.
+func (this *Node) AddComment(text string) *Node {
+  for strings.Contains(text, "--") {
+    text = strings.Replace(text, "--", "- -", -1)
+  }
+  t := NewNode(NT_COMMENT)
+  t.Value = text
+  this.addMisc(t)
+  return t
+}
+
+// AddProcInst adds the processing instruction <?target inst?> as the last
+// child of this node, or, on the NT_ROOT node, before the document element,
+// where instructions like xml-stylesheet belong. Returns the instruction.
+// The target must be an XML name other than "xml" in any case, which is
+// reserved for the XML declaration. As instructions cannot hold "?>", any
+// are broken up with a space.
+func (this *Node) AddProcInst(target, inst string) (*Node, error) {
+  if err := checkPITarget(target); err != nil {
+    return nil, err
+  }
+  t := NewNode(NT_PROCINST)
+  t.Target = target
+  t.Value = procInstText(inst)
+  this.addMisc(t)
+  return t, nil
+}
+
+func checkPITarget(target string) error {
+  if target == "" {
+    return fmt.Errorf("xmlx: empty processing instruction target")
+  }
+  if strings.EqualFold(target, "xml") {
+    return fmt.Errorf("xmlx: processing instruction target %q is reserved", target)
+  }
+  if !isXMLName(target) {
+    return fmt.Errorf("xmlx: invalid processing instruction target %q", target)
+  }
+  return nil
+}
+
+// isXMLName reports whether s is an XML name. Characters outside the BMP
+// ranges XML allows are not told apart from letters and digits.
+func isXMLName(s string) bool {
+  for i, r := range s {
+    if !(unicode.IsLetter(r) || r == '_' || r == ':' ||
+      (i > 0 && (unicode.IsDigit(r) || unicode.IsMark(r) || r == '-' || r == '.'))) {
+      return false
+    }
+  }
+  return s != ""
+}
+
+// isQName reports whether s is a name with at most one prefix, as
+// namespaces allow in element and attribute names.
+func isQName(s string) bool {
+  i := strings.IndexByte(s, ':')
+  if i < 0 {
+    return isXMLName(s)
+  }
+  return isXMLName(s[:i]) && isXMLName(s[i+1:]) && strings.IndexByte(s[i+1:], ':') < 0
+}
+
+func procInstText(inst string) string {
+  for strings.Contains(inst, "?>") {
+    inst = strings.Replace(inst, "?>", "? >", -1)
+  }
+  return inst
+}
+
+func (this *Node) addMisc(t *Node) {
+  var ref *Node
+  if this.Type == NT_ROOT {
+    ref = this.FirstChildElement()
+  }
+  this.InsertBefore(ref, t)
+}
+
+// Comments returns the comments that are children of this node.
+func (this *Node) Comments() []*Node {
+  list := make([]*Node, 0, 4)
+  for _, v := range this.Children {
+    if v.Type == NT_COMMENT {
+      list = append(list, v)
+    }
+  }
+  return list
+}
+
+// ProcInsts returns the processing instructions with the given target ("*"
+// for any) that are children of this node.
+func (this *Node) ProcInsts(target string) []*Node {
+  list := make([]*Node, 0, 4)
+  for _, v := range this.Children {
+    if v.Type == NT_PROCINST && (target == "*" || v.Target == target) {
+      list = append(list, v)
+    }
+  }
+  return list
+}
+
+// ProcInst returns the first processing instruction with the given target
+// that is a child of this node, or nil.
+func (this *Node) ProcInst(target string) *Node {
+  for _, v := range this.Children {
+    if v.Type == NT_PROCINST && v.Target == target {
+      return v
+    }
+  }
+  return nil
+}
+
+// SetProcInst sets the content of the first processing instruction with
+// the given target among the children of this node, adding one with
+// AddProcInst if there is none. Returns the instruction. Target and content
+// are checked as by AddProcInst.
+func (this *Node) SetProcInst(target, inst string) (*Node, error) {
+  if err := checkPITarget(target); err != nil {
+    return nil, err
+  }
+  if t := this.ProcInst(target); t != nil {
+    t.Value = procInstText(inst)
+    return t, nil
+  }
+  return this.AddProcInst(target, inst)
+}
+
 // Remove a child node
 func (this *Node) RemoveChild(t *Node) {
   p := -1
@@ -599,4 +1827,161 @@ func (this *Node) RemoveChild(t *Node) {
   this.Children = this.Children[0 : len(this.Children)-1]
 
   t.Parent = nil
-}
\ No newline at end of file
+}
+
+// ReplaceChild puts t in place of the child old, which is detached from the
+// tree. As with AddChild, t is first removed from its current parent. An
+// error is returned if old is not a child of this node, or if t is this
+// node or one of its ancestors.
+func (this *Node) ReplaceChild(old, t *Node) error {
+  if old.Parent != this || childIndex(this, old) < 0 {
+    return errors.New("xmlx: node to replace is not a child of this node")
+  }
+  if isWithin(this, t) {
+    return errors.New("xmlx: cannot insert a node into itself")
+  }
+  if t == old {
+    return nil
+  }
+
+  if t.Parent != nil {
+    t.Parent.RemoveChild(t)
+  }
+  this.Children[childIndex(this, old)] = t
+  t.Parent = this
+  old.Parent = nil
+  return nil
+}
+
+// Wrap puts a new element with the given namespace and name in the place of
+// this node, and this node inside it, and returns the new element. The
+// namespace is stored in Name.Space as given, as with SetAttrNS. Returns nil
+// if this node has a parent but is missing from its Children.
+func (this *Node) Wrap(namespace, name string) *Node {
+  w := NewNode(NT_ELEMENT)
+  w.Name = xml.Name{Space: namespace, Local: name}
+  if p := this.Parent; p != nil {
+    i := childIndex(p, this)
+    if i < 0 {
+      return nil
+    }
+    p.Children[i] = w
+    w.Parent = p
+    this.Parent = nil
+  }
+  w.AddChild(this)
+  return w
+}
+
+// Unwrap replaces this node with its children, which keep their order. The
+// namespace declarations of this node are copied onto the child elements,
+// where still needed, so their names keep their meaning. An error is
+// returned if the node has no parent, or is missing from its Children.
+func (this *Node) Unwrap() error {
+  p := this.Parent
+  if p == nil {
+    return errors.New("xmlx: cannot unwrap a node without a parent")
+  }
+  if childIndex(p, this) < 0 {
+    return errors.New("xmlx: node to unwrap is not a child of its parent")
+  }
+
+  var ctx map[string]string
+  for _, v := range this.Children {
+    if v.Type != NT_ELEMENT {
+      continue
+    }
+    if ctx == nil {
+      ctx = p.NamespaceContext()
+    }
+    for _, a := range this.Attributes {
+      prefix := a.Name.Local
+      if a.Name.Space == "" && a.Name.Local == "xmlns" {
+        prefix = ""
+      } else if a.Name.Space != "xmlns" {
+        continue
+      }
+      if !v.declares(prefix) {
+        v.setNamespaceDecl(prefix, a.Value)
+      }
+    }
+    dropRedundantNS(v, ctx)
+  }
+
+  i := childIndex(p, this)
+  list := make([]*Node, 0, len(p.Children)+len(this.Children))
+  list = append(list, p.Children[:i]...)
+  list = append(list, this.Children...)
+  list = append(list, p.Children[i+1:]...)
+  p.Children = list
+  for _, v := range this.Children {
+    v.Parent = p
+  }
+  this.Children = this.Children[:0]
+  this.Parent = nil
+  return nil
+}
+
+// declares reports whether this element declares the given prefix ("" for
+// the default namespace).
+func (this *Node) declares(prefix string) bool {
+  for _, a := range this.Attributes {
+    if (prefix == "" && a.Name.Space == "" && a.Name.Local == "xmlns") || (a.Name.Space == "xmlns" && a.Name.Local == prefix) {
+      return true
+    }
+  }
+  return false
+}
+
+// Remove detaches this node from its parent, if it has one. The node keeps
+// its children and can be added elsewhere.
+func (this *Node) Remove() {
+  if this.Parent != nil {
+    this.Parent.RemoveChild(this)
+  }
+}
+
+// InsertBefore adds t as a child of this node, right before the child ref.
+// With ref nil, t is appended as with AddChild. As with AddChild, t is first
+// removed from its current parent. An error is returned if ref is not a
+// child of this node, or if t is this node or one of its ancestors.
+func (this *Node) InsertBefore(ref, t *Node) error {
+  return this.insertChild(ref, t, 0)
+}
+
+// InsertAfter adds t as a child of this node, right after the child ref.
+// With ref nil, t becomes the first child. See InsertBefore.
+func (this *Node) InsertAfter(ref, t *Node) error {
+  return this.insertChild(ref, t, 1)
+}
+
+// insertChild puts t at the position of ref plus offset, or at the end
+// (offset 0) or start (offset 1) if ref is nil.
+func (this *Node) insertChild(ref, t *Node, offset int) error {
+  if isWithin(this, t) {
+    return errors.New("xmlx: cannot insert a node into itself")
+  }
+  if ref != nil && (ref.Parent != this || childIndex(this, ref) < 0) {
+    return errors.New("xmlx: reference node is not a child of this node")
+  }
+  if t == ref {
+    return nil
+  }
+
+  if t.Parent != nil {
+    t.Parent.RemoveChild(t)
+  }
+
+  i := len(this.Children)
+  if ref != nil {
+    i = childIndex(this, ref) + offset
+  } else if offset == 1 {
+    i = 0
+  }
+
+  this.Children = append(this.Children, nil)
+  copy(this.Children[i+1:], this.Children[i:])
+  this.Children[i] = t
+  t.Parent = this
+  return nil
+}
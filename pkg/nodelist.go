@@ -0,0 +1,206 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "sort"
+  "strconv"
+  "strings"
+)
+
+// SortDocumentOrder sorts the given nodes in document order: a node comes
+// after its ancestors and after the nodes preceding it in the tree. This is
+// the order all Select* functions return their results in, so it is mostly
+// useful for lists combined from several queries. Nodes from different trees
+// are grouped per tree, in the order their trees first appear in the list.
+func SortDocumentOrder(list []*Node) {
+  keys := make(map[*Node][]int, len(list))
+  trees := make(map[*Node]int)
+
+  for _, n := range list {
+    if _, ok := keys[n]; ok {
+      continue
+    }
+    root, key := documentPosition(n)
+    if _, ok := trees[root]; !ok {
+      trees[root] = len(trees)
+    }
+    keys[n] = append([]int{trees[root]}, key...)
+  }
+
+  sort.SliceStable(list, func(i, j int) bool {
+    return comparePositions(keys[list[i]], keys[list[j]]) < 0
+  })
+}
+
+// documentPosition returns the root of the tree n lives in and the child
+// indices leading from that root to n.
+func documentPosition(n *Node) (*Node, []int) {
+  var key []int
+  for n.Parent != nil {
+    key = append(key, childIndex(n.Parent, n))
+    n = n.Parent
+  }
+
+  for i, j := 0, len(key)-1; i < j; i, j = i+1, j-1 {
+    key[i], key[j] = key[j], key[i]
+  }
+  return n, key
+}
+
+func childIndex(parent, n *Node) int {
+  for i, v := range parent.Children {
+    if v == n {
+      return i
+    }
+  }
+  return -1
+}
+
+func comparePositions(a, b []int) int {
+  for i := 0; i < len(a) && i < len(b); i++ {
+    if a[i] != b[i] {
+      if a[i] < b[i] {
+        return -1
+      }
+      return 1
+    }
+  }
+  return len(a) - len(b)
+}
+
+// Union returns every node that is in any of the given lists, without
+// duplicates and in document order. Nodes are compared by identity.
+func Union(lists ...[]*Node) []*Node {
+  seen := make(map[*Node]bool)
+  res := make([]*Node, 0, 16)
+  for _, list := range lists {
+    for _, n := range list {
+      if !seen[n] {
+        seen[n] = true
+        res = append(res, n)
+      }
+    }
+  }
+  SortDocumentOrder(res)
+  return res
+}
+
+// Intersect returns the nodes of a that are also in b, without duplicates and
+// in document order. Nodes are compared by identity.
+func Intersect(a, b []*Node) []*Node {
+  return filterNodes(a, b, true)
+}
+
+// Except returns the nodes of a that are not in b, without duplicates and in
+// document order. Nodes are compared by identity.
+func Except(a, b []*Node) []*Node {
+  return filterNodes(a, b, false)
+}
+
+func filterNodes(a, b []*Node, keep bool) []*Node {
+  inb := make(map[*Node]bool, len(b))
+  for _, n := range b {
+    inb[n] = true
+  }
+
+  seen := make(map[*Node]bool, len(a))
+  res := make([]*Node, 0, len(a))
+  for _, n := range a {
+    if inb[n] == keep && !seen[n] {
+      seen[n] = true
+      res = append(res, n)
+    }
+  }
+  SortDocumentOrder(res)
+  return res
+}
+
+// Values returns the value (see Node.GetValue) of every node in the list.
+func Values(nodes []*Node) []string {
+  res := make([]string, len(nodes))
+  for i, n := range nodes {
+    res[i] = n.GetValue()
+  }
+  return res
+}
+
+// JoinValues concatenates the values of the given nodes, separated by sep.
+func JoinValues(nodes []*Node, sep string) string {
+  return strings.Join(Values(nodes), sep)
+}
+
+// SumInt adds up the values of the given nodes as integers. Like the typed
+// getters, values that do not parse count as zero.
+func SumInt(nodes []*Node) int64 {
+  var sum int64
+  for _, n := range nodes {
+    v, _ := strconv.ParseInt(n.GetValue(), 10, 64)
+    sum += v
+  }
+  return sum
+}
+
+// SumFloat adds up the values of the given nodes as floats. Like the typed
+// getters, values that do not parse count as zero.
+func SumFloat(nodes []*Node) float64 {
+  var sum float64
+  for _, n := range nodes {
+    v, _ := strconv.ParseFloat(n.GetValue(), 64)
+    sum += v
+  }
+  return sum
+}
+
+// SortChildren puts the child elements of this node in the order given by
+// less, keeping elements that compare equal in their current order. Text,
+// comments and other nodes between the elements stay where they are, so
+// indentation is kept. ByName and ByAttr are comparators for common cases.
+func (this *Node) SortChildren(less func(a, b *Node) bool) {
+  var elems []*Node
+  for _, c := range this.Children {
+    if c.Type == NT_ELEMENT {
+      elems = append(elems, c)
+    }
+  }
+  sort.SliceStable(elems, func(i, j int) bool {
+    return less(elems[i], elems[j])
+  })
+
+  i := 0
+  for k, c := range this.Children {
+    if c.Type == NT_ELEMENT {
+      this.Children[k] = elems[i]
+      i++
+    }
+  }
+}
+
+// ByName orders elements by local name, then by namespace.
+func ByName(a, b *Node) bool {
+  if a.Name.Local != b.Name.Local {
+    return a.Name.Local < b.Name.Local
+  }
+  return a.Name.Space < b.Name.Space
+}
+
+// ByAttr returns a comparator ordering elements by the value of the given
+// attribute. Values that are both integers compare as numbers. Elements
+// without the attribute come last.
+func ByAttr(namespace, name string) func(a, b *Node) bool {
+  return func(a, b *Node) bool {
+    ha, hb := a.HasAttr(namespace, name), b.HasAttr(namespace, name)
+    if !ha || !hb {
+      return ha
+    }
+    va, vb := a.As(namespace, name), b.As(namespace, name)
+    if ia, err := strconv.ParseInt(va, 10, 64); err == nil {
+      if ib, err := strconv.ParseInt(vb, 10, 64); err == nil {
+        return ia < ib
+      }
+    }
+    return va < vb
+  }
+}
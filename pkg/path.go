@@ -0,0 +1,363 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+)
+
+// A path is a '/' separated list of [prefix:]name steps, e.g.
+// "order/items/item", evaluated from the children of a context node. '*'
+// matches any prefix or name. A step may give a namespace URI instead of a
+// prefix as {uri}name. A path may end in an "@[prefix:]name" step to
+// address an attribute of the selected elements.
+//
+// Element steps may be followed by predicates in square brackets, applied
+// in order:
+//
+//   item[@id]          items with an id attribute
+//   item[@id='7']      items whose id is 7
+//   item[sku]          items with a sku child element
+//   item[sku="A1"]     items with a sku child whose value is A1
+//   item[2]            the second item, after any preceding predicates
+type path struct {
+  steps []pathStep
+  attr  *pathStep
+}
+
+type pathStep struct {
+  space string
+  local string
+  preds []pathPred
+}
+
+type pathPred struct {
+  pos      int      // Position (1-based) for positional predicates; 0 otherwise.
+  attr     bool     // Test an attribute instead of a child element.
+  name     pathStep // Attribute or child element to test.
+  value    string   // Value to compare with, if hasValue.
+  hasValue bool
+}
+
+// SelectNodeByPath returns the first element the path leads to from this
+// node, or nil if there is none or the path is malformed. The path is a '/'
+// separated list of [prefix:]name steps starting with the children of this
+// node, e.g. "order/items/item". Each step may carry its own prefix, or a
+// namespace URI in {uri}name form; unprefixed steps match any namespace and
+// '*' matches any name. Steps may have predicates like [@id='7'] or [2]; see
+// CompileQuery. Attribute steps are not allowed.
+func (this *Node) SelectNodeByPath(expr string) *Node {
+  if list := this.selectByPath(expr, 1); len(list) > 0 {
+    return list[0]
+  }
+  return nil
+}
+
+// SelectNodesByPath returns all elements the path leads to from this node,
+// in document order. See SelectNodeByPath.
+func (this *Node) SelectNodesByPath(expr string) []*Node {
+  return this.selectByPath(expr, 0)
+}
+
+func (this *Node) selectByPath(expr string, limit int) []*Node {
+  p, err := parsePath(expr)
+  if err != nil || p.attr != nil {
+    return []*Node{}
+  }
+  return p.selectNodes(this, limit)
+}
+
+func parsePath(expr string) (*path, error) {
+  p := new(path)
+
+  trimmed := strings.TrimPrefix(expr, "/")
+  if trimmed == "" {
+    return nil, fmt.Errorf("xmlx: empty path %q", expr)
+  }
+
+  parts := splitPath(trimmed)
+  for i, part := range parts {
+    isAttr := strings.HasPrefix(part, "@")
+    if isAttr {
+      if i != len(parts)-1 {
+        return nil, fmt.Errorf("xmlx: attribute step must come last in path %q", expr)
+      }
+      part = part[1:]
+    }
+
+    var step pathStep
+    var err error
+    if isAttr {
+      step, err = parseName(part)
+    } else {
+      step, err = parseStep(part)
+    }
+    if err != nil {
+      return nil, fmt.Errorf("xmlx: path %q: %s", expr, err)
+    }
+    if isAttr {
+      p.attr = &step
+    } else {
+      p.steps = append(p.steps, step)
+    }
+  }
+
+  return p, nil
+}
+
+// splitPath splits a path into its steps at the '/' characters that are not
+// part of a {uri} or a predicate.
+func splitPath(s string) []string {
+  parts := make([]string, 0, 4)
+  start, inURI, depth := 0, false, 0
+  var quote byte
+
+  for i := 0; i < len(s); i++ {
+    c := s[i]
+    switch {
+    case quote != 0:
+      if c == quote {
+        quote = 0
+      }
+    case depth > 0 && (c == '\'' || c == '"'):
+      quote = c
+    case c == '{':
+      inURI = true
+    case c == '}':
+      inURI = false
+    case c == '[':
+      depth++
+    case c == ']':
+      depth--
+    case c == '/' && !inURI && depth == 0:
+      parts = append(parts, s[start:i])
+      start = i + 1
+    }
+  }
+  return append(parts, s[start:])
+}
+
+// parseStep parses an element step: a name followed by any predicates.
+func parseStep(s string) (pathStep, error) {
+  from := 0 // Skip a {uri}, which may contain brackets.
+  if strings.HasPrefix(s, "{") {
+    if k := strings.IndexByte(s, '}'); k > -1 {
+      from = k
+    }
+  }
+  i := len(s)
+  if j := strings.IndexByte(s[from:], '['); j > -1 {
+    i = from + j
+  }
+
+  step, err := parseName(s[:i])
+  if err != nil {
+    return step, err
+  }
+
+  for rest := s[i:]; rest != ""; {
+    if rest[0] != '[' {
+      return step, fmt.Errorf("malformed step %q", s)
+    }
+    end := predicateEnd(rest)
+    if end < 0 {
+      return step, fmt.Errorf("unterminated predicate in step %q", s)
+    }
+    pred, err := parsePred(strings.TrimSpace(rest[1:end]))
+    if err != nil {
+      return step, fmt.Errorf("step %q: %s", s, err)
+    }
+    step.preds = append(step.preds, pred)
+    rest = rest[end+1:]
+  }
+
+  return step, nil
+}
+
+// predicateEnd returns the index of the ']' closing the predicate s starts
+// with, or -1.
+func predicateEnd(s string) int {
+  var quote byte
+  for i := 1; i < len(s); i++ {
+    switch c := s[i]; {
+    case quote != 0:
+      if c == quote {
+        quote = 0
+      }
+    case c == '\'' || c == '"':
+      quote = c
+    case c == ']':
+      return i
+    }
+  }
+  return -1
+}
+
+func parsePred(s string) (pathPred, error) {
+  var pred pathPred
+  if s == "" {
+    return pred, fmt.Errorf("empty predicate")
+  }
+
+  if n, err := strconv.Atoi(s); err == nil {
+    if n < 1 {
+      return pred, fmt.Errorf("invalid position %d", n)
+    }
+    pred.pos = n
+    return pred, nil
+  }
+
+  name := s
+  if i := strings.IndexByte(s, '='); i > -1 {
+    name = strings.TrimSpace(s[:i])
+    v := strings.TrimSpace(s[i+1:])
+    if len(v) < 2 || (v[0] != '\'' && v[0] != '"') || v[len(v)-1] != v[0] {
+      return pred, fmt.Errorf("value in predicate [%s] must be quoted", s)
+    }
+    pred.value, pred.hasValue = v[1:len(v)-1], true
+  }
+
+  if strings.HasPrefix(name, "@") {
+    pred.attr = true
+    name = name[1:]
+  }
+  var err error
+  pred.name, err = parseName(name)
+  return pred, err
+}
+
+// parseName parses a [prefix:]name or {uri}name.
+func parseName(s string) (pathStep, error) {
+  if s == "" {
+    return pathStep{}, fmt.Errorf("empty step")
+  }
+  if s[0] == '{' {
+    i := strings.IndexByte(s, '}')
+    if i < 0 || i == len(s)-1 {
+      return pathStep{}, fmt.Errorf("malformed step %q", s)
+    }
+    return pathStep{space: s[1:i], local: s[i+1:]}, nil
+  }
+  if i := strings.IndexByte(s, ':'); i > -1 {
+    if i == 0 || i == len(s)-1 {
+      return pathStep{}, fmt.Errorf("malformed step %q", s)
+    }
+    return pathStep{space: s[:i], local: s[i+1:]}, nil
+  }
+  return pathStep{space: "*", local: s}, nil
+}
+
+// selectNodes returns the elements the path leads to from cn, in document
+// order. At most limit nodes are returned when limit > 0. The attribute step,
+// if any, is not applied; see values.
+func (this *path) selectNodes(cn *Node, limit int) []*Node {
+  list := make([]*Node, 0, 4)
+  this.walk(cn, 0, &list, limit)
+  return list
+}
+
+func (this *path) walk(cn *Node, step int, list *[]*Node, limit int) bool {
+  if step == len(this.steps) {
+    *list = append(*list, cn)
+    return limit <= 0 || len(*list) < limit
+  }
+
+  for _, v := range this.steps[step].match(cn) {
+    if !this.walk(v, step+1, list, limit) {
+      return false
+    }
+  }
+  return true
+}
+
+// match returns the children of cn selected by this step.
+func (this *pathStep) match(cn *Node) []*Node {
+  list := make([]*Node, 0, len(cn.Children))
+  for _, v := range cn.Children {
+    if v.Type == NT_ELEMENT && v.matches(this.space, this.local) {
+      list = append(list, v)
+    }
+  }
+
+  for _, pred := range this.preds {
+    if pred.pos > 0 {
+      if pred.pos > len(list) {
+        return nil
+      }
+      list = list[pred.pos-1 : pred.pos]
+      continue
+    }
+    kept := list[:0]
+    for _, v := range list {
+      if pred.test(v) {
+        kept = append(kept, v)
+      }
+    }
+    list = kept
+  }
+
+  return list
+}
+
+// test evaluates a non-positional predicate against n.
+func (this *pathPred) test(n *Node) bool {
+  if this.attr {
+    v, ok := this.name.attrValue(n)
+    return ok && (!this.hasValue || v == this.value)
+  }
+  for _, v := range n.Children {
+    if v.Type == NT_ELEMENT && v.matches(this.name.space, this.name.local) {
+      if !this.hasValue || v.GetValue() == this.value {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+// attrValue returns the value of the attribute of n named by this step. As
+// for elements, the step may name the namespace by prefix or by URI.
+func (this *pathStep) attrValue(n *Node) (string, bool) {
+  for _, a := range n.Attributes {
+    if this.local == a.Name.Local && this.matchesAttr(n, a) {
+      return a.value(), true
+    }
+  }
+  return "", false
+}
+
+func (this *pathStep) matchesAttr(n *Node, a *Attr) bool {
+  if this.space == "*" || this.space == a.Name.Space {
+    return true
+  }
+  if a.Name.Space == "" {
+    return false
+  }
+  prefix, uri := n.attrSpace(a)
+  return this.space == prefix || (uri != "" && this.space == uri)
+}
+
+// values returns the values the path leads to from cn: the matching
+// attribute values if the path ends in an attribute step, the values of the
+// selected elements otherwise.
+func (this *path) values(cn *Node, limit int) []string {
+  nodes := this.selectNodes(cn, 0)
+  res := make([]string, 0, len(nodes))
+
+  for _, n := range nodes {
+    if this.attr == nil {
+      res = append(res, n.GetValue())
+    } else if v, ok := this.attr.attrValue(n); ok {
+      res = append(res, v)
+    }
+    if limit > 0 && len(res) >= limit {
+      break
+    }
+  }
+
+  return res
+}
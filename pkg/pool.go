@@ -0,0 +1,81 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "sync"
+)
+
+// DocumentPool keeps loaded documents around for reuse, along with the
+// nodes of their trees. In servers handling many small messages this
+// avoids allocating a fresh tree for every parse. It is safe for concurrent
+// use.
+type DocumentPool struct {
+  pool sync.Pool
+}
+
+// Get returns an empty document, as created by New(). It may reuse a
+// document, and the nodes of its last tree, previously handed to Put.
+func (this *DocumentPool) Get() *Document {
+  if doc, ok := this.pool.Get().(*Document); ok {
+    return doc
+  }
+  return New()
+}
+
+// Put resets the document and returns it to the pool. Neither the document
+// nor any node that was part of its tree may be used after this call. Its
+// spilled attribute values are removed (see Document.RemoveSpilled).
+func (this *DocumentPool) Put(doc *Document) {
+  if doc == nil {
+    return
+  }
+
+  doc.RemoveSpilled()
+  free := doc.free
+  if doc.Root != nil {
+    free = recycleNodes(doc.Root, free)
+  }
+
+  // New gives fresh Entity and Namespaces maps; the old ones may be shared
+  // with other documents, so they are left alone.
+  *doc = *New()
+  doc.free = free
+  this.pool.Put(doc)
+}
+
+// recycleNodes resets every node in the tree below (and including) n and
+// appends it to free.
+func recycleNodes(n *Node, free []*Node) []*Node {
+  for _, v := range n.Children {
+    free = recycleNodes(v, free)
+  }
+
+  children, attrs := n.Children, n.Attributes
+  for i := range children {
+    children[i] = nil
+  }
+  for i := range attrs {
+    attrs[i] = nil
+  }
+
+  *n = Node{Children: children[:0], Attributes: attrs[:0]}
+  return append(free, n)
+}
+
+// newNode returns a node from the document's free list, falling back to
+// NewNode when the list is empty.
+func (this *Document) newNode(tid NodeType) *Node {
+  n := len(this.free)
+  if n == 0 {
+    return NewNode(tid)
+  }
+
+  t := this.free[n-1]
+  this.free[n-1] = nil
+  this.free = this.free[:n-1]
+  t.Type = tid
+  return t
+}
@@ -0,0 +1,84 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "encoding/xml"
+  "fmt"
+)
+
+// Profiles for Document.SetProfile, each setting the load and save options
+// that decide how much a document is trusted.
+const (
+  // Well-formed XML only: strict decoding, a single root element, declared
+  // prefixes, no duplicate attributes and no characters XML 1.0 forbids.
+  // No size limits are set.
+  PROFILE_STRICT = iota
+
+  // Tolerant decoding for HTML-like or damaged input: unclosed elements,
+  // HTML entities, several root elements and undeclared prefixes are
+  // accepted; duplicate attributes are only warned about and forbidden
+  // characters replaced.
+  PROFILE_LENIENT
+
+  // PROFILE_STRICT plus limits for untrusted input: no entities besides
+  // the predefined ones, and bounded text, expansion and output sizes.
+  PROFILE_HARDENED
+)
+
+// Limits set by PROFILE_HARDENED.
+const (
+  HardenedMaxTextSize   = 8 << 20  // Bytes in a single text node.
+  HardenedMaxEntities   = 1000     // Entity references replaced.
+  HardenedMaxEntitySize = 1 << 20  // Bytes of text entity references are replaced by.
+  HardenedMaxSaveSize   = 64 << 20 // Bytes of serialized output.
+  HardenedMaxSaveDepth  = 256      // Element nesting in serialized output.
+)
+
+// SetProfile sets the options covered by the given profile (PROFILE_*) all
+// at once, replacing their current values. Options the profile does not
+// cover, like SaveDocType or Codecs, are left alone, and any option can
+// still be changed afterwards. An unknown profile is an error, and changes
+// nothing.
+func (this *Document) SetProfile(profile int) error {
+  switch profile {
+  case PROFILE_STRICT, PROFILE_HARDENED:
+    this.Strict = true
+    this.AutoClose = nil
+    this.StrictRoot = true
+    this.StrictNS = true
+    this.DupAttrs = DUPATTR_ERROR
+    this.BadChars = BADCHAR_ERROR
+    this.MaxTextSize, this.MaxEntities, this.MaxEntitySize = 0, 0, 0
+    this.MaxSaveSize, this.MaxSaveDepth = 0, 0
+    // No entities besides the predefined ones; an empty map rather than nil,
+    // so UseHTMLMode and LoadExtendedEntityMap can still add to it.
+    this.Entity = make(map[string]string)
+  case PROFILE_LENIENT:
+    this.Strict = false
+    this.AutoClose = xml.HTMLAutoClose
+    this.StrictRoot = false
+    this.StrictNS = false
+    this.DupAttrs = DUPATTR_WARN
+    this.BadChars = BADCHAR_REPLACE
+    this.MaxTextSize, this.MaxEntities, this.MaxEntitySize = 0, 0, 0
+    this.MaxSaveSize, this.MaxSaveDepth = 0, 0
+    this.Entity = make(map[string]string, len(xml.HTMLEntity))
+    for k, v := range xml.HTMLEntity {
+      this.Entity[k] = v
+    }
+  default:
+    return fmt.Errorf("xmlx: unknown profile %d", profile)
+  }
+
+  if profile == PROFILE_HARDENED {
+    this.MaxTextSize = HardenedMaxTextSize
+    this.MaxEntities = HardenedMaxEntities
+    this.MaxEntitySize = HardenedMaxEntitySize
+    this.MaxSaveSize = HardenedMaxSaveSize
+    this.MaxSaveDepth = HardenedMaxSaveDepth
+  }
+  return nil
+}
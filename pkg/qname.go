@@ -0,0 +1,691 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "encoding/xml"
+  "errors"
+  "fmt"
+  "strings"
+)
+
+// ParseQName splits a qualified name like "soap:Body" and resolves its prefix
+// through nsContext, which maps prefixes to namespace URIs (see
+// Node.NamespaceContext). Unprefixed names get the default namespace,
+// nsContext[""], if there is one. The xml prefix is always known. With a nil
+// nsContext no resolution takes place and the prefix itself is returned as
+// the Space of the name, the way the Select functions expect it.
+func ParseQName(qname string, nsContext map[string]string) (xml.Name, error) {
+  prefix, local := "", qname
+  if i := strings.IndexByte(qname, ':'); i > -1 {
+    prefix, local = qname[:i], qname[i+1:]
+    if prefix == "" {
+      return xml.Name{}, fmt.Errorf("xmlx: empty prefix in qualified name %q", qname)
+    }
+  }
+  if local == "" || strings.IndexByte(local, ':') > -1 {
+    return xml.Name{}, fmt.Errorf("xmlx: invalid qualified name %q", qname)
+  }
+
+  if nsContext == nil {
+    return xml.Name{Space: prefix, Local: local}, nil
+  }
+  if prefix == "xml" {
+    return xml.Name{Space: xmlURL, Local: local}, nil
+  }
+
+  uri, ok := nsContext[prefix]
+  if !ok && prefix != "" {
+    return xml.Name{}, fmt.Errorf("xmlx: unbound prefix %q in qualified name %q", prefix, qname)
+  }
+  return xml.Name{Space: uri, Local: local}, nil
+}
+
+// NamespaceContext returns the prefix to namespace URI bindings in scope at
+// this node, as declared by xmlns attributes on the node and its ancestors.
+// The default namespace, if any, is stored under the empty prefix.
+func (this *Node) NamespaceContext() map[string]string {
+  ctx := make(map[string]string)
+  for n := this; n != nil; n = n.Parent {
+    for _, a := range n.Attributes {
+      prefix, ok := "", false
+      if a.Name.Space == "" && a.Name.Local == "xmlns" {
+        ok = true
+      } else if a.Name.Space == "xmlns" {
+        prefix, ok = a.Name.Local, true
+      }
+      if _, seen := ctx[prefix]; ok && !seen {
+        ctx[prefix] = a.Value
+      }
+    }
+  }
+  return ctx
+}
+
+// QualifiedName returns the name of this node in prefix:local form. Name.Space
+// may hold either a prefix or a namespace URI; URIs are mapped back to the
+// prefix they are bound to in scope, or dropped when they are the default
+// namespace. Unknown URIs are kept as they are.
+func (this *Node) QualifiedName() string {
+  space := this.Name.Space
+  if space == "" {
+    return this.Name.Local
+  }
+
+  for n := this; n != nil; n = n.Parent {
+    for _, a := range n.Attributes {
+      if a.Value != space {
+        continue
+      }
+      if a.Name.Space == "" && a.Name.Local == "xmlns" {
+        return this.Name.Local
+      }
+      if a.Name.Space == "xmlns" {
+        return a.Name.Local + ":" + this.Name.Local
+      }
+    }
+  }
+
+  return space + ":" + this.Name.Local
+}
+
+// NamespaceURI returns the namespace URI of this element, independent of
+// whether Name.Space holds a URI or a prefix. It returns "" for elements in
+// no namespace and for prefixes that are not bound to any URI.
+func (this *Node) NamespaceURI() string {
+  _, uri := this.resolveSpace()
+  return uri
+}
+
+// Prefix returns the prefix this element is written with, independent of
+// whether Name.Space holds a URI or a prefix. It returns "" for elements in
+// the default namespace or in no namespace.
+func (this *Node) Prefix() string {
+  prefix, _ := this.resolveSpace()
+  return prefix
+}
+
+// resolveSpace works out the prefix and namespace URI of this node. For
+// loaded elements whose Name.Space has not been changed since, these are
+// known from the source document. Otherwise Name.Space is looked up in the
+// namespace declarations in scope.
+func (this *Node) resolveSpace() (prefix, uri string) {
+  if this.loaded && this.Name.Space == this.loadedSpace {
+    return this.loadedPrefix, this.loadedURI
+  }
+  return this.lookupSpace()
+}
+
+// attrSpace works out the prefix and namespace URI of the attribute a of
+// this node. Unprefixed attributes are in no namespace, whatever the default
+// namespace is.
+func (this *Node) attrSpace(a *Attr) (prefix, uri string) {
+  space := a.Name.Space
+  switch {
+  case space == "":
+    return "", ""
+  case space == xmlURL || space == "xml":
+    return "xml", xmlURL
+  }
+  ctx := this.NamespaceContext()
+  if u, ok := ctx[space]; ok {
+    return space, u
+  }
+  if p, ok := lookupPrefix(ctx, space); ok {
+    return p, space
+  }
+  if strings.ContainsAny(space, ":/") {
+    return "", space
+  }
+  return space, ""
+}
+
+// lookupSpace works out the prefix and namespace URI of this node from the
+// namespace declarations in scope, as they will be when the tree is saved.
+func (this *Node) lookupSpace() (prefix, uri string) {
+  space := this.Name.Space
+  if space == xmlURL || space == "xml" {
+    return "xml", xmlURL
+  }
+
+  ctx := this.NamespaceContext()
+  if u, ok := ctx[space]; ok {
+    return space, u
+  }
+  if space != "" {
+    if p, ok := lookupPrefix(ctx, space); ok {
+      return p, space
+    }
+  }
+  if strings.ContainsAny(space, ":/") {
+    return "", space
+  }
+  return space, ""
+}
+
+// lookupPrefix returns the prefix bound to uri in ctx. The default namespace
+// is preferred over prefixed bindings; among several prefixes the
+// alphabetically first one is chosen.
+func lookupPrefix(ctx map[string]string, uri string) (string, bool) {
+  if u, ok := ctx[""]; ok && u == uri {
+    return "", true
+  }
+
+  prefix, found := "", false
+  for p, u := range ctx {
+    if u == uri && p != "" && (!found || p < prefix) {
+      prefix, found = p, true
+    }
+  }
+  return prefix, found
+}
+
+// setLoaded records the namespace an element was found in while loading, so
+// NamespaceURI and Prefix need not look it up again. uri is the namespace as
+// reported by encoding/xml, which leaves unbound prefixes in place of it.
+func (this *Node) setLoaded(uri string) {
+  prefix := ""
+  if uri == xmlURL {
+    prefix = "xml"
+  } else if uri != "" {
+    var ok bool
+    if prefix, ok = lookupPrefix(this.NamespaceContext(), uri); !ok {
+      prefix, uri = uri, ""
+    }
+  }
+
+  this.loadedSpace = this.Name.Space
+  this.loadedURI = uri
+  this.loadedPrefix = prefix
+  this.loaded = true
+}
+
+// NamespaceBinding is a single namespace declaration found in a document.
+type NamespaceBinding struct {
+  Prefix string // Declared prefix; "" for the default namespace.
+  URI    string // Namespace URI; "" when the declaration undeclares Prefix.
+  Node   *Node  // Element carrying the declaration.
+}
+
+// NamespaceBindings lists every namespace declaration in the document, in
+// document order. Unlike the document wide Namespaces map, which only keeps
+// one prefix per URI, this shows all bindings along with the element (and so
+// the scope) they belong to. Node.NamespaceContext gives the bindings in
+// effect at any given node.
+func (this *Document) NamespaceBindings() []NamespaceBinding {
+  list := make([]NamespaceBinding, 0, 8)
+  if this.Root != nil {
+    rec_NamespaceBindings(this.Root, &list)
+  }
+  return list
+}
+
+func rec_NamespaceBindings(cn *Node, list *[]NamespaceBinding) {
+  for _, a := range cn.Attributes {
+    if a.Name.Space == "" && a.Name.Local == "xmlns" {
+      *list = append(*list, NamespaceBinding{"", a.Value, cn})
+    } else if a.Name.Space == "xmlns" {
+      *list = append(*list, NamespaceBinding{a.Name.Local, a.Value, cn})
+    }
+  }
+  for _, v := range cn.Children {
+    rec_NamespaceBindings(v, list)
+  }
+}
+
+// Rename changes the name of this element to local in namespace ns, written
+// with the given prefix ("" for the default namespace). Attributes, children
+// and all other properties are kept. If the prefix is not bound to ns where
+// the element is, a namespace declaration is added to it; an empty ns with
+// an empty prefix undeclares the default namespace if needed.
+//
+// An error is returned if the element already declares the prefix for
+// another URI, or if the new declaration would change the namespace of any
+// attribute or element below it. Prefixes other than the default cannot be
+// bound to an empty ns. To move the content along into the new namespace,
+// use RenameTree.
+func (this *Node) Rename(ns, prefix, local string) error {
+  if this.Type != NT_ELEMENT {
+    return fmt.Errorf("xmlx: cannot rename a %s node", this.Type)
+  }
+
+  if uri, ok := this.NamespaceContext()[prefix]; uri != ns || (!ok && prefix != "") {
+    if prefix != "" && ns == "" {
+      return fmt.Errorf("xmlx: prefix %q cannot be bound to an empty namespace", prefix)
+    }
+
+    decl := &Attr{Name: xml.Name{Space: "xmlns", Local: prefix}, Value: ns}
+    if prefix == "" {
+      decl.Name = xml.Name{Local: "xmlns"}
+    }
+    for _, a := range this.Attributes {
+      if a.Name == decl.Name {
+        return fmt.Errorf("xmlx: <%s> already binds prefix %q to %s", this.QualifiedName(), prefix, a.Value)
+      }
+    }
+
+    before := make([]string, 0, 16)
+    rec_NamespaceUses(this, true, &before)
+    this.Attributes = append(this.Attributes, decl)
+    after := make([]string, 0, len(before))
+    rec_NamespaceUses(this, true, &after)
+    for i := range before {
+      if before[i] != after[i] {
+        this.Attributes = this.Attributes[:len(this.Attributes)-1]
+        return fmt.Errorf("xmlx: binding prefix %q to %s on <%s> would change the namespace of its content", prefix, ns, this.QualifiedName())
+      }
+    }
+  }
+
+  space := prefix
+  if prefix == "" {
+    space = ns
+  }
+  this.Name = xml.Name{Space: space, Local: local}
+  this.loaded = false
+  return nil
+}
+
+// RenameTree changes the name of this element to local in namespace ns, and
+// moves along with it every element and attribute below it that is in the
+// same namespace as the element was, like an XSLT identity transform that
+// changes a namespace. The element keeps the prefix it is written with;
+// declarations are added or changed wherever needed so that everything
+// else keeps its namespace, and redundant ones are dropped afterwards. It
+// takes no prefix, unlike Rename, which renames the element alone.
+//
+// An error is returned if ns is empty while any of the nodes to move is
+// written with a prefix, as only the default namespace can be undeclared.
+func (this *Node) RenameTree(ns, local string) error {
+  if this.Type != NT_ELEMENT {
+    return fmt.Errorf("xmlx: cannot rename a %s node", this.Type)
+  }
+
+  _, old := this.resolveSpace()
+  list := make([]nsTarget, 0, 16)
+  rec_NamespaceTargets(this, old, ns, &list)
+  for _, t := range list {
+    if ns == "" && t.moved && t.prefix != "" {
+      return fmt.Errorf("xmlx: cannot move prefix %q into no namespace", t.prefix)
+    }
+  }
+
+  this.Name.Local = local
+  if old != "" {
+    rec_RebindNamespace(this, old, ns)
+  }
+  for _, t := range list {
+    if t.attr == nil {
+      t.node.loaded = false
+    }
+    if t.moved && t.uriForm {
+      if t.attr != nil {
+        t.attr.Name.Space = ns
+      } else {
+        t.node.Name.Space = ns
+      }
+    }
+  }
+
+  for _, t := range list {
+    if t.prefix == "xml" {
+      continue
+    }
+    if uri := t.node.NamespaceContext()[t.prefix]; uri != t.uri {
+      t.node.setNamespaceDecl(t.prefix, t.uri)
+    }
+  }
+
+  outer := make(map[string]string)
+  if this.Parent != nil {
+    outer = this.Parent.NamespaceContext()
+  }
+  rec_DropRedundantNS(this, outer)
+  return nil
+}
+
+// nsTarget is an element name or attribute below a renamed element, with
+// the prefix it is written with and the namespace it must end up in.
+type nsTarget struct {
+  node    *Node
+  attr    *Attr  // nil for the name of node itself.
+  prefix  string
+  uri     string // Namespace after the rename.
+  moved   bool   // Moves from the old namespace to the new one.
+  uriForm bool   // Name.Space holds the namespace URI instead of the prefix.
+}
+
+func rec_NamespaceTargets(cn *Node, old, ns string, list *[]nsTarget) {
+  prefix, uri := cn.resolveSpace()
+  t := nsTarget{node: cn, prefix: prefix, uri: uri, uriForm: cn.Name.Space != prefix}
+  if uri == old {
+    t.uri, t.moved = ns, true
+  }
+  *list = append(*list, t)
+
+  var ctx map[string]string
+  for _, a := range cn.Attributes {
+    if a.Name.Space == "" || a.Name.Space == "xmlns" || a.Name.Space == "xml" || a.Name.Space == xmlURL {
+      continue
+    }
+    if ctx == nil {
+      ctx = cn.NamespaceContext()
+    }
+    t := nsTarget{node: cn, attr: a}
+    if u, ok := ctx[a.Name.Space]; ok {
+      t.prefix, t.uri = a.Name.Space, u
+    } else if p, ok := lookupPrefix(ctx, a.Name.Space); ok && p != "" {
+      t.prefix, t.uri, t.uriForm = p, a.Name.Space, true
+    } else {
+      continue
+    }
+    if t.uri == old {
+      t.uri, t.moved = ns, true
+    }
+    *list = append(*list, t)
+  }
+
+  for _, v := range cn.Children {
+    if v.Type == NT_ELEMENT {
+      rec_NamespaceTargets(v, old, ns, list)
+    }
+  }
+}
+
+// rec_RebindNamespace changes the declarations of old in cn and below it to
+// ns, as everything in their scope moves. Prefixed declarations are dropped
+// if ns is empty.
+func rec_RebindNamespace(cn *Node, old, ns string) {
+  keep := cn.Attributes[:0]
+  for _, a := range cn.Attributes {
+    if a.Value == old && (a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns")) {
+      if ns == "" && a.Name.Space == "xmlns" {
+        continue
+      }
+      a.Value = ns
+    }
+    keep = append(keep, a)
+  }
+  cn.Attributes = keep
+
+  for _, v := range cn.Children {
+    if v.Type == NT_ELEMENT {
+      rec_RebindNamespace(v, old, ns)
+    }
+  }
+}
+
+// DeclareNamespace binds prefix ("" for the default namespace) to uri on
+// this element, so that elements and attributes below it whose Name.Space
+// is set to uri, now or later, are written with that prefix. Nothing is
+// done if the element has that declaration already.
+//
+// An error is returned if the element binds the prefix to another URI
+// already, or if the declaration would change the namespace of the element
+// or of anything below it. The prefixes xml and xmlns are reserved, and
+// prefixes other than the default cannot be bound to an empty uri.
+func (this *Node) DeclareNamespace(prefix, uri string) error {
+  if this.Type != NT_ELEMENT {
+    return fmt.Errorf("xmlx: cannot declare a namespace on a %s node", this.Type)
+  }
+  if prefix == "xml" || prefix == "xmlns" {
+    return fmt.Errorf("xmlx: prefix %q is reserved", prefix)
+  }
+  if prefix != "" && uri == "" {
+    return fmt.Errorf("xmlx: prefix %q cannot be bound to an empty namespace", prefix)
+  }
+
+  for _, a := range this.Attributes {
+    if (prefix == "" && a.Name.Space == "" && a.Name.Local == "xmlns") ||
+      (prefix != "" && a.Name.Space == "xmlns" && a.Name.Local == prefix) {
+      if a.Value == uri {
+        return nil
+      }
+      return fmt.Errorf("xmlx: <%s> already binds prefix %q to %s", this.QualifiedName(), prefix, a.Value)
+    }
+  }
+
+  before := make([]string, 0, 16)
+  rec_NamespaceUses(this, false, &before)
+  this.setNamespaceDecl(prefix, uri)
+  after := make([]string, 0, len(before))
+  rec_NamespaceUses(this, false, &after)
+  for i := range before {
+    if before[i] != after[i] {
+      if prefix == "" {
+        this.RemoveAttrNS("", "xmlns")
+      } else {
+        this.RemoveAttrNS("xmlns", prefix)
+      }
+      return fmt.Errorf("xmlx: binding prefix %q to %s on <%s> would change the namespace of its content", prefix, uri, this.QualifiedName())
+    }
+  }
+  return nil
+}
+
+// DeclareNamespace declares the namespace on n (the document element if n
+// is nil), as Node.DeclareNamespace does, and records prefix for uri in
+// Document.Namespaces, as loading a declaration would.
+func (this *Document) DeclareNamespace(n *Node, prefix, uri string) error {
+  if n == nil {
+    if n = this.documentElement(); n == nil {
+      return errors.New("xmlx: document has no root element")
+    }
+  }
+  if err := n.DeclareNamespace(prefix, uri); err != nil {
+    return err
+  }
+  if uri != "" {
+    if this.Namespaces == nil {
+      this.Namespaces = make(map[string]string)
+    }
+    this.Namespaces[uri] = prefix
+  }
+  return nil
+}
+
+// setNamespaceDecl binds prefix ("" for the default namespace) to uri on
+// this element, changing its declaration of the prefix if it has one.
+func (this *Node) setNamespaceDecl(prefix, uri string) {
+  name := xml.Name{Space: "xmlns", Local: prefix}
+  if prefix == "" {
+    name = xml.Name{Local: "xmlns"}
+  }
+  for _, a := range this.Attributes {
+    if a.Name == name {
+      a.Value = uri
+      return
+    }
+  }
+  if prefix == "" {
+    this.Attributes = append([]*Attr{{Name: name, Value: uri}}, this.Attributes...)
+    return
+  }
+  this.addNamespaceDecl(prefix, uri)
+}
+
+// rec_NamespaceUses lists the namespace URIs of the names of cn and the
+// elements below it, and of their prefixed attributes, as given by the
+// declarations in scope. With self set, the name of cn itself is left out.
+func rec_NamespaceUses(cn *Node, self bool, list *[]string) {
+  if cn.Type != NT_ELEMENT {
+    return
+  }
+  if !self {
+    _, uri := cn.lookupSpace()
+    *list = append(*list, uri)
+  }
+
+  var ctx map[string]string
+  for _, a := range cn.Attributes {
+    if a.Name.Space == "" || a.Name.Space == "xmlns" {
+      continue
+    }
+    if ctx == nil {
+      ctx = cn.NamespaceContext()
+    }
+    if uri, ok := ctx[a.Name.Space]; ok {
+      *list = append(*list, uri)
+    } else {
+      *list = append(*list, a.Name.Space)
+    }
+  }
+
+  for _, v := range cn.Children {
+    rec_NamespaceUses(v, false, list)
+  }
+}
+
+// MinimizeNamespaces tidies the namespace declarations of this element and
+// the elements below it, the way a person writing the document by hand
+// would, before it is saved. Declarations of a prefix that is bound to the
+// same URI everywhere are replaced by a single one on the nearest element
+// enclosing all of them. Declarations that repeat a binding already in
+// scope are removed.
+//
+// Default namespace declarations are only removed when redundant, never
+// moved, as that would change the namespace of unprefixed elements.
+// Declarations are never dropped for being unused, since prefixes may be
+// used in attribute values or text (e.g. xsi:type="ns:T").
+func (this *Node) MinimizeNamespaces() {
+  if this.Type != NT_ELEMENT {
+    return
+  }
+
+  outer := make(map[string]string)
+  if this.Parent != nil {
+    outer = this.Parent.NamespaceContext()
+  }
+
+  list := make([]NamespaceBinding, 0, 8)
+  rec_NamespaceBindings(this, &list)
+  sites := make(map[string][]*Node)
+  uris := make(map[string]string)
+  prefixes := make([]string, 0, len(list))
+  for _, b := range list {
+    if b.Prefix == "" {
+      continue
+    }
+    if uri, ok := uris[b.Prefix]; !ok {
+      uris[b.Prefix] = b.URI
+      prefixes = append(prefixes, b.Prefix)
+    } else if uri != b.URI {
+      uris[b.Prefix] = "\x00" // Bound to several URIs; left alone.
+    }
+    sites[b.Prefix] = append(sites[b.Prefix], b.Node)
+  }
+
+  for _, prefix := range prefixes {
+    uri := uris[prefix]
+    if _, ok := outer[prefix]; ok || uri == "\x00" || uri == "" || len(sites[prefix]) < 2 {
+      continue
+    }
+    top := commonAncestor(sites[prefix])
+    if top.Type != NT_ELEMENT {
+      continue
+    }
+    declared := false
+    for _, n := range sites[prefix] {
+      if n == top {
+        declared = true
+      } else {
+        n.RemoveAttrNS("xmlns", prefix)
+      }
+    }
+    if !declared {
+      top.addNamespaceDecl(prefix, uri)
+    }
+  }
+
+  rec_DropRedundantNS(this, outer)
+}
+
+// MinimizeNamespaces tidies the namespace declarations of the document
+// element and everything below it. See Node.MinimizeNamespaces.
+func (this *Document) MinimizeNamespaces() {
+  if n := this.documentElement(); n != nil {
+    n.MinimizeNamespaces()
+  }
+}
+
+func rec_DropRedundantNS(cn *Node, scope map[string]string) {
+  local := dropRedundantNS(cn, scope)
+  for _, v := range cn.Children {
+    if v.Type == NT_ELEMENT {
+      rec_DropRedundantNS(v, local)
+    }
+  }
+}
+
+// dropRedundantNS removes the namespace declarations of cn that repeat a
+// binding in scope, and returns the bindings in scope inside cn.
+func dropRedundantNS(cn *Node, scope map[string]string) map[string]string {
+  var local map[string]string
+  keep := cn.Attributes[:0]
+  for _, a := range cn.Attributes {
+    prefix, ok := "", false
+    if a.Name.Space == "" && a.Name.Local == "xmlns" {
+      ok = true
+    } else if a.Name.Space == "xmlns" {
+      prefix, ok = a.Name.Local, true
+    }
+    if ok {
+      if uri, bound := scope[prefix]; (bound && uri == a.Value) || (!bound && prefix == "" && a.Value == "") {
+        continue
+      }
+      if local == nil {
+        local = make(map[string]string, len(scope)+1)
+        for k, v := range scope {
+          local[k] = v
+        }
+      }
+      local[prefix] = a.Value
+    }
+    keep = append(keep, a)
+  }
+  cn.Attributes = keep
+
+  if local == nil {
+    return scope
+  }
+  return local
+}
+
+// commonAncestor returns the nearest node that is one of the given nodes or
+// an ancestor of all of them.
+func commonAncestor(nodes []*Node) *Node {
+  chain := make([]*Node, 0, 16)
+  for n := nodes[0]; n != nil; n = n.Parent {
+    chain = append(chain, n)
+  }
+
+  for _, v := range nodes[1:] {
+  search:
+    for n := v; n != nil; n = n.Parent {
+      for i, c := range chain {
+        if c == n {
+          chain = chain[i:]
+          break search
+        }
+      }
+    }
+  }
+  return chain[0]
+}
+
+// addNamespaceDecl declares prefix for uri on this element, after any
+// namespace declarations it already has.
+func (this *Node) addNamespaceDecl(prefix, uri string) {
+  decl := &Attr{Name: xml.Name{Space: "xmlns", Local: prefix}, Value: uri}
+  i := 0
+  for j, a := range this.Attributes {
+    if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+      i = j + 1
+    }
+  }
+  this.Attributes = append(this.Attributes, nil)
+  copy(this.Attributes[i+1:], this.Attributes[i:])
+  this.Attributes[i] = decl
+}
@@ -0,0 +1,86 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+// Query is a compiled path expression. It can be evaluated any number of
+// times, against any number of documents, without parsing the expression
+// again. A Query is immutable and safe for concurrent use.
+type Query struct {
+  expr string
+  p    *path
+}
+
+// CompileQuery parses a path expression for later use. Paths are '/'
+// separated [prefix:]name steps evaluated from the children of the context
+// node, with optional predicates and a final @attribute step, e.g.
+// "order/items/item[@status='open']/@id". See SelectNodeByPath for the
+// name syntax.
+func CompileQuery(expr string) (*Query, error) {
+  p, err := parsePath(expr)
+  if err != nil {
+    return nil, err
+  }
+  return &Query{expr, p}, nil
+}
+
+// MustCompileQuery is like CompileQuery but panics if the expression cannot
+// be parsed. It simplifies initializing package level queries.
+func MustCompileQuery(expr string) *Query {
+  q, err := CompileQuery(expr)
+  if err != nil {
+    panic(err)
+  }
+  return q
+}
+
+// String returns the source text of the query.
+func (this *Query) String() string {
+  return this.expr
+}
+
+// Evaluate returns the elements the query selects from n, in document
+// order. A final attribute step restricts the result to elements carrying
+// that attribute.
+func (this *Query) Evaluate(n *Node) []*Node {
+  list := this.p.selectNodes(n, 0)
+  if this.p.attr == nil {
+    return list
+  }
+  kept := list[:0]
+  for _, v := range list {
+    if _, ok := this.p.attr.attrValue(v); ok {
+      kept = append(kept, v)
+    }
+  }
+  return kept
+}
+
+// First returns the first element the query selects from n, or nil.
+func (this *Query) First(n *Node) *Node {
+  if this.p.attr == nil {
+    if list := this.p.selectNodes(n, 1); len(list) > 0 {
+      return list[0]
+    }
+    return nil
+  }
+  if list := this.Evaluate(n); len(list) > 0 {
+    return list[0]
+  }
+  return nil
+}
+
+// Values returns the values the query selects from n: attribute values if
+// it ends in an attribute step, element values otherwise.
+func (this *Query) Values(n *Node) []string {
+  return this.p.values(n, 0)
+}
+
+// Value returns the first value the query selects from n, or "".
+func (this *Query) Value(n *Node) string {
+  if v := this.p.values(n, 1); len(v) > 0 {
+    return v[0]
+  }
+  return ""
+}
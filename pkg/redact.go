@@ -0,0 +1,128 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "fmt"
+  "strings"
+)
+
+// RedactRule masks values while a document is loaded, before they reach the
+// tree, for documents holding personal data that must not be kept. Rules are
+// set in Document.Redact.
+//
+// Path selects the values, using the path syntax of SelectNodesByPath
+// without predicates:
+//
+//   ssn                   the text of every ssn element
+//   customer/ssn          the text of ssn elements directly inside customer
+//   /order/customer/ssn   the same, starting at the document element
+//   @cardNumber           every cardNumber attribute
+//   card/@number          number attributes of card elements
+//
+// Paths without a leading '/' match at any depth. For elements, all text
+// inside them is masked, including that of child elements; each block of
+// text is masked separately, and whitespace is left alone.
+type RedactRule struct {
+  Path string              // Values to mask.
+  Mask string              // Replacement for the values; may be empty.
+  Func func(string) string // If set, computes the replacement instead of Mask, e.g. to keep the last digits.
+}
+
+type redactRule struct {
+  *RedactRule
+  steps    []pathStep
+  attr     *pathStep
+  absolute bool
+}
+
+func compileRedact(rules []RedactRule) ([]redactRule, error) {
+  list := make([]redactRule, 0, len(rules))
+  for i := range rules {
+    r := &rules[i]
+    p, err := parsePath(r.Path)
+    if err != nil {
+      return nil, err
+    }
+    for _, s := range p.steps {
+      if len(s.preds) > 0 {
+        return nil, fmt.Errorf("xmlx: redaction path %q: predicates are not supported", r.Path)
+      }
+    }
+    list = append(list, redactRule{r, p.steps, p.attr, strings.HasPrefix(r.Path, "/")})
+  }
+  return list, nil
+}
+
+func (this *redactRule) apply(value string) string {
+  if this.Func != nil {
+    return this.Func(value)
+  }
+  return this.Mask
+}
+
+// matchElem reports whether the element steps of the rule lead to t, going
+// up from t through its open ancestors.
+func (this *redactRule) matchElem(t *Node) bool {
+  n := t
+  for i := len(this.steps) - 1; i >= 0; i-- {
+    if n == nil || n.Type != NT_ELEMENT || !n.matches(this.steps[i].space, this.steps[i].local) {
+      return false
+    }
+    n = n.Parent
+  }
+  return !this.absolute || n == nil || n.Type == NT_ROOT
+}
+
+// redactElement applies the rules to the element t that was just opened:
+// its attributes are masked right away, and if the element itself matches,
+// the text inside it is masked until it is closed.
+func (this *loader) redactElement(t *Node) {
+  for i := range this.redact {
+    r := &this.redact[i]
+    if !r.matchElem(t) {
+      continue
+    }
+    if r.attr == nil {
+      if this.masked == nil || !isWithin(t, this.masked) {
+        this.masked, this.maskRule = t, r
+      }
+      continue
+    }
+    for _, a := range t.Attributes {
+      if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+        continue
+      }
+      if (r.attr.space == "*" || r.attr.space == a.Name.Space) && r.attr.local == a.Name.Local {
+        a.set(r.apply(a.value()))
+      }
+    }
+  }
+}
+
+// redactText masks text found under ct, if ct is inside a masked element.
+func (this *loader) redactText(ct *Node, text string) string {
+  if this.masked == nil {
+    return text
+  }
+  if !isWithin(ct, this.masked) {
+    this.masked, this.maskRule = nil, nil
+    return text
+  }
+  if strings.TrimSpace(text) == "" {
+    return text
+  }
+  return this.maskRule.apply(text)
+}
+
+// isWithin reports whether n is anc or lies below it.
+func isWithin(n, anc *Node) bool {
+  for ; n != nil; n = n.Parent {
+    if n == anc {
+      return true
+    }
+  }
+  return false
+}
@@ -0,0 +1,125 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "strconv"
+)
+
+// ResponseNS is the namespace of response envelopes when Response.Namespace
+// is not set.
+const ResponseNS = "urn:xmlx:response"
+
+// Response describes the envelope of an XML API response, which Document
+// turns into:
+//
+//   <response xmlns="urn:xmlx:response" status="error" code="422">
+//     <errors>
+//       <error code="E_RANGE" field="qty">out of range</error>
+//     </errors>
+//     <pagination page="2" size="20" total="135" next="/items?page=3" />
+//     <data>...payload...</data>
+//   </response>
+//
+// Elements without content are left out.
+type Response struct {
+  Namespace string          // Namespace of the envelope elements; ResponseNS if empty.
+  Status    string          // Outcome, e.g. "ok" or "error".
+  Code      int             // Status code, e.g. the HTTP one; left out if 0.
+  Errors    []ResponseError // Errors to report.
+  Page      *Pagination     // Position of the payload in a larger result, if paged.
+  Payload   []*Node         // Nodes put in <data>; copies are made.
+}
+
+// ResponseError is a single error in a Response.
+type ResponseError struct {
+  Code    string // Machine readable error code.
+  Field   string // Input field the error is about, if any.
+  Message string // Human readable description.
+}
+
+// Pagination describes one page of a result in a Response. Attributes with
+// zero values are left out.
+type Pagination struct {
+  Page  int    // Page number.
+  Size  int    // Items per page.
+  Total int    // Items in the whole result.
+  Next  string // Link to the next page.
+  Prev  string // Link to the previous page.
+}
+
+// Document builds the response document. Payload nodes are copied with
+// Document.ImportNode, so they keep the namespaces in scope where they came
+// from, and payload elements in no namespace are kept out of the envelope's
+// default namespace.
+func (this *Response) Document() *Document {
+  ns := this.Namespace
+  if ns == "" {
+    ns = ResponseNS
+  }
+
+  doc := New()
+  b := doc.Build().ElemNS(ns, "response")
+  if this.Status != "" {
+    b.Attr("status", this.Status)
+  }
+  if this.Code != 0 {
+    b.Attr("code", strconv.Itoa(this.Code))
+  }
+
+  if len(this.Errors) > 0 {
+    b.Elem("errors")
+    for _, e := range this.Errors {
+      b.Elem("error")
+      if e.Code != "" {
+        b.Attr("code", e.Code)
+      }
+      if e.Field != "" {
+        b.Attr("field", e.Field)
+      }
+      b.Text(e.Message).End()
+    }
+    b.End()
+  }
+
+  if p := this.Page; p != nil {
+    b.Elem("pagination")
+    for _, a := range []struct {
+      name string
+      val  int
+    }{{"page", p.Page}, {"size", p.Size}, {"total", p.Total}} {
+      if a.val != 0 {
+        b.Attr(a.name, strconv.Itoa(a.val))
+      }
+    }
+    if p.Next != "" {
+      b.Attr("next", p.Next)
+    }
+    if p.Prev != "" {
+      b.Attr("prev", p.Prev)
+    }
+    b.End()
+  }
+
+  if len(this.Payload) > 0 {
+    data := b.Elem("data").Node()
+    for _, n := range this.Payload {
+      doc.ImportNode(n, true).MoveTo(data)
+    }
+    b.End()
+  }
+  return doc
+}
+
+// OKResponse returns a successful response carrying the given payload.
+func OKResponse(payload ...*Node) *Response {
+  return &Response{Status: "ok", Payload: payload}
+}
+
+// ErrorResponse returns a failed response with the given status code and
+// errors.
+func ErrorResponse(code int, errs ...ResponseError) *Response {
+  return &Response{Status: "error", Code: code, Errors: errs}
+}
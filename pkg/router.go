@@ -0,0 +1,141 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "errors"
+)
+
+// ErrNoRoute is returned by Router.Dispatch when no route matches a document
+// and the router has no Fallback handler.
+var ErrNoRoute = errors.New("xmlx: no route matches document")
+
+// Predicate decides whether a document is handled by a route.
+type Predicate func(doc *Document) bool
+
+// Handler processes a document dispatched to it by a Router.
+type Handler func(doc *Document) error
+
+// Router dispatches documents to handlers based on their content, e.g. the
+// name of their root element. Routes are tried in the order they were
+// added; the first one whose predicate holds gets the document. The zero
+// value is an empty router ready for use.
+type Router struct {
+  Fallback Handler // Called when no route matches; may be nil.
+  routes   []route
+}
+
+type route struct {
+  pred    Predicate
+  handler Handler
+}
+
+// Handle adds a route sending documents that satisfy pred to h.
+func (this *Router) Handle(pred Predicate, h Handler) {
+  this.routes = append(this.routes, route{pred, h})
+}
+
+// Dispatch hands the document to the first matching route and returns the
+// handler's result.
+func (this *Router) Dispatch(doc *Document) error {
+  for _, r := range this.routes {
+    if r.pred(doc) {
+      return r.handler(doc)
+    }
+  }
+  if this.Fallback != nil {
+    return this.Fallback(doc)
+  }
+  return ErrNoRoute
+}
+
+// RootIs matches documents whose root element has the given namespace and
+// name. As with the Select functions, "*" matches anything and the namespace
+// may be given as URI or prefix.
+func RootIs(namespace, name string) Predicate {
+  return func(doc *Document) bool {
+    root := doc.documentElement()
+    return root != nil && root.matches(namespace, name)
+  }
+}
+
+// HasPath matches documents in which the given path leads to at least one
+// node, or attribute if it ends in an @name step. Paths are '/' separated
+// [prefix:]name steps starting with the root element, as with Extract. It
+// returns an error if the path cannot be parsed.
+func HasPath(expr string) (Predicate, error) {
+  p, err := parsePath(expr)
+  if err != nil {
+    return nil, err
+  }
+  return func(doc *Document) bool {
+    return doc.Root != nil && len(p.values(doc.Root, 1)) > 0
+  }, nil
+}
+
+// MustHasPath is like HasPath but panics if the path cannot be parsed. It
+// simplifies setting up routes.
+func MustHasPath(expr string) Predicate {
+  pred, err := HasPath(expr)
+  if err != nil {
+    panic(err)
+  }
+  return pred
+}
+
+// ValueIs matches documents in which any value the path leads to equals
+// value. Combined with an attribute step this tests attribute values, e.g.
+// ValueIs("order/@type", "rush"). It returns an error if the path cannot be
+// parsed.
+func ValueIs(expr, value string) (Predicate, error) {
+  p, err := parsePath(expr)
+  if err != nil {
+    return nil, err
+  }
+  return func(doc *Document) bool {
+    if doc.Root == nil {
+      return false
+    }
+    for _, v := range p.values(doc.Root, 0) {
+      if v == value {
+        return true
+      }
+    }
+    return false
+  }, nil
+}
+
+// MustValueIs is like ValueIs but panics if the path cannot be parsed.
+func MustValueIs(expr, value string) Predicate {
+  pred, err := ValueIs(expr, value)
+  if err != nil {
+    panic(err)
+  }
+  return pred
+}
+
+// All matches documents satisfying every one of the given predicates.
+func All(preds ...Predicate) Predicate {
+  return func(doc *Document) bool {
+    for _, p := range preds {
+      if !p(doc) {
+        return false
+      }
+    }
+    return true
+  }
+}
+
+// Any matches documents satisfying at least one of the given predicates.
+func Any(preds ...Predicate) Predicate {
+  return func(doc *Document) bool {
+    for _, p := range preds {
+      if p(doc) {
+        return true
+      }
+    }
+    return false
+  }
+}
@@ -0,0 +1,177 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "fmt"
+  "time"
+)
+
+// Namespace of SAML 2.0 assertions.
+const SAMLAssertionNS = "urn:oasis:names:tc:SAML:2.0:assertion"
+
+const dsigNS = "http://www.w3.org/2000/09/xmldsig#"
+
+// SAMLAssertion holds the commonly needed parts of a SAML 2.0 assertion.
+// Times that are absent from the assertion are left zero.
+type SAMLAssertion struct {
+  ID           string
+  IssueInstant time.Time
+  Issuer       string
+  Subject      SAMLSubject
+  Conditions   SAMLConditions
+  Attributes   []SAMLAttribute
+  Signed       bool  // The assertion carries a ds:Signature. It is not verified.
+  Node         *Node // The saml:Assertion element this was parsed from.
+}
+
+// SAMLSubject is the subject of an assertion along with its bearer
+// confirmation data.
+type SAMLSubject struct {
+  NameID       string
+  Format       string
+  Recipient    string
+  InResponseTo string
+  NotOnOrAfter time.Time
+}
+
+// SAMLConditions restricts the validity of an assertion.
+type SAMLConditions struct {
+  NotBefore    time.Time
+  NotOnOrAfter time.Time
+  Audiences    []string
+}
+
+// SAMLAttribute is a single attribute from an attribute statement.
+type SAMLAttribute struct {
+  Name         string
+  FriendlyName string
+  NameFormat   string
+  Values       []string
+}
+
+// Attribute returns the values of the attribute with the given Name or
+// FriendlyName, or nil if there is no such attribute.
+func (this *SAMLAssertion) Attribute(name string) []string {
+  for _, a := range this.Attributes {
+    if a.Name == name || a.FriendlyName == name {
+      return a.Values
+    }
+  }
+  return nil
+}
+
+// ValidAt reports whether t lies within the validity window set by the
+// assertion's conditions.
+func (this *SAMLAssertion) ValidAt(t time.Time) bool {
+  c := this.Conditions
+  return (c.NotBefore.IsZero() || !t.Before(c.NotBefore)) &&
+    (c.NotOnOrAfter.IsZero() || t.Before(c.NotOnOrAfter))
+}
+
+// SAMLAssertions parses every SAML 2.0 assertion in the document, e.g. the
+// assertions of a samlp:Response, in document order. Encrypted assertions
+// are not decrypted and so not returned.
+func (this *Document) SAMLAssertions() ([]*SAMLAssertion, error) {
+  list := make([]*SAMLAssertion, 0, 1)
+  if this.Root == nil {
+    return list, nil
+  }
+
+  for _, n := range this.Root.SelectNodesRecursive(SAMLAssertionNS, "Assertion") {
+    a, err := ParseSAMLAssertion(n)
+    if err != nil {
+      return nil, err
+    }
+    list = append(list, a)
+  }
+  return list, nil
+}
+
+// ParseSAMLAssertion parses the given saml:Assertion element.
+func ParseSAMLAssertion(n *Node) (*SAMLAssertion, error) {
+  if !n.IsElement() || !n.matches(SAMLAssertionNS, "Assertion") {
+    return nil, fmt.Errorf("xmlx: %s is not a SAML assertion", n.QualifiedName())
+  }
+
+  var err error
+  a := &SAMLAssertion{ID: n.As("", "ID"), Node: n}
+  if a.IssueInstant, err = samlTime(n, "IssueInstant"); err != nil {
+    return nil, err
+  }
+  if v := childElement(n, SAMLAssertionNS, "Issuer"); v != nil {
+    a.Issuer = v.GetValue()
+  }
+  a.Signed = childElement(n, dsigNS, "Signature") != nil
+
+  if s := childElement(n, SAMLAssertionNS, "Subject"); s != nil {
+    if id := childElement(s, SAMLAssertionNS, "NameID"); id != nil {
+      a.Subject.NameID = id.GetValue()
+      a.Subject.Format = id.As("", "Format")
+    }
+    for _, sc := range s.SelectNodes(SAMLAssertionNS, "SubjectConfirmation") {
+      if d := childElement(sc, SAMLAssertionNS, "SubjectConfirmationData"); d != nil {
+        a.Subject.Recipient = d.As("", "Recipient")
+        a.Subject.InResponseTo = d.As("", "InResponseTo")
+        if a.Subject.NotOnOrAfter, err = samlTime(d, "NotOnOrAfter"); err != nil {
+          return nil, err
+        }
+        break
+      }
+    }
+  }
+
+  if c := childElement(n, SAMLAssertionNS, "Conditions"); c != nil {
+    if a.Conditions.NotBefore, err = samlTime(c, "NotBefore"); err != nil {
+      return nil, err
+    }
+    if a.Conditions.NotOnOrAfter, err = samlTime(c, "NotOnOrAfter"); err != nil {
+      return nil, err
+    }
+    for _, r := range c.SelectNodes(SAMLAssertionNS, "AudienceRestriction") {
+      for _, v := range r.SelectNodes(SAMLAssertionNS, "Audience") {
+        a.Conditions.Audiences = append(a.Conditions.Audiences, v.GetValue())
+      }
+    }
+  }
+
+  for _, st := range n.SelectNodes(SAMLAssertionNS, "AttributeStatement") {
+    for _, v := range st.SelectNodes(SAMLAssertionNS, "Attribute") {
+      attr := SAMLAttribute{
+        Name:         v.As("", "Name"),
+        FriendlyName: v.As("", "FriendlyName"),
+        NameFormat:   v.As("", "NameFormat"),
+      }
+      for _, av := range v.SelectNodes(SAMLAssertionNS, "AttributeValue") {
+        attr.Values = append(attr.Values, av.GetValue())
+      }
+      a.Attributes = append(a.Attributes, attr)
+    }
+  }
+
+  return a, nil
+}
+
+// childElement returns the first child element of n with the given name.
+func childElement(n *Node, namespace, name string) *Node {
+  for _, v := range n.Children {
+    if v.Type == NT_ELEMENT && v.matches(namespace, name) {
+      return v
+    }
+  }
+  return nil
+}
+
+func samlTime(n *Node, attr string) (time.Time, error) {
+  s := n.As("", attr)
+  if s == "" {
+    return time.Time{}, nil
+  }
+  t, err := time.Parse(time.RFC3339Nano, s)
+  if err != nil {
+    return time.Time{}, fmt.Errorf("xmlx: %s/@%s: invalid time %q", n.QualifiedName(), attr, s)
+  }
+  return t, nil
+}
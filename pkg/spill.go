@@ -0,0 +1,175 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  "strings"
+  "sync/atomic"
+)
+
+// Attribute values longer than Document.SpillSize bytes, like embedded
+// base64 images, are written to temporary files as they are loaded instead
+// of being kept in the tree. Namespace declarations are never spilled.
+// Spilled attributes have an empty Value; their content is streamed with
+// Attr.Reader or Node.AttrReader, and is written back by the Save functions
+// and Node.String. Functions that look at attribute values, like Node.As,
+// the Select and Search functions, paths, selectors, Hash, Match and the
+// JSON conversions, read it back into memory when they need it.
+//
+// encoding/xml still reads each value into memory once, while its element
+// is parsed, but a document with many large values only holds one of them
+// at a time. The files live in Document.SpillDir (os.TempDir() if empty)
+// until Document.RemoveSpilled is called; cloned nodes share them. Setting
+// the value with SetAttr, SetAttrNS or SetAttrFunc replaces the spilled
+// one, and its file is removed once no clone refers to it.
+
+// spillFile is the temporary file holding a spilled value. refs counts the
+// attributes referring to it.
+type spillFile struct {
+  name string
+  size int64
+  refs int32
+}
+
+// Spilled reports whether the value of this attribute is kept in a
+// temporary file.
+func (this *Attr) Spilled() bool {
+  return this.spill != nil
+}
+
+// Size returns the length in bytes of the value of this attribute.
+func (this *Attr) Size() int64 {
+  if this.spill != nil {
+    return this.spill.size
+  }
+  return int64(len(this.Value))
+}
+
+// Reader returns a reader for the value of this attribute, spilled or not.
+// The caller must close it.
+func (this *Attr) Reader() (io.ReadCloser, error) {
+  if this.spill == nil {
+    return ioutil.NopCloser(strings.NewReader(this.Value)), nil
+  }
+  return os.Open(this.spill.name)
+}
+
+// value returns the value of this attribute, read back from its file if it
+// was spilled. A file that cannot be read gives "".
+func (this *Attr) value() string {
+  if this.spill == nil {
+    return this.Value
+  }
+  b, err := ioutil.ReadFile(this.spill.name)
+  if err != nil {
+    return ""
+  }
+  return string(b)
+}
+
+// set assigns the value of this attribute, dropping its spilled value.
+func (this *Attr) set(value string) {
+  this.unspill()
+  this.Value = value
+}
+
+// unspill detaches this attribute from its temporary file, which is removed
+// if no other attribute refers to it.
+func (this *Attr) unspill() {
+  if this.spill == nil {
+    return
+  }
+  if atomic.AddInt32(&this.spill.refs, -1) == 0 {
+    os.Remove(this.spill.name)
+  }
+  this.spill = nil
+}
+
+// copy returns a copy of this attribute, sharing its temporary file.
+func (this *Attr) copy() *Attr {
+  c := *this
+  if c.spill != nil {
+    atomic.AddInt32(&c.spill.refs, 1)
+  }
+  return &c
+}
+
+// AttrReader returns a reader for the value of the given attribute, or an
+// error if the node does not have it. See Attr.Reader.
+func (this *Node) AttrReader(namespace, name string) (io.ReadCloser, error) {
+  for _, v := range this.Attributes {
+    if (namespace == "*" || namespace == v.Name.Space) && name == v.Name.Local {
+      return v.Reader()
+    }
+  }
+  return nil, fmt.Errorf("xmlx: <%s> has no attribute %s", this.Name.Local, name)
+}
+
+// RemoveSpilled deletes the temporary files holding the attribute values
+// spilled by the loads of this document. Those attributes are left empty.
+func (this *Document) RemoveSpilled() error {
+  var first error
+  for _, f := range this.spilled {
+    if err := os.Remove(f); err != nil && !os.IsNotExist(err) && first == nil {
+      first = err
+    }
+  }
+  this.spilled = nil
+  return first
+}
+
+// spillAttrs moves the values of the attributes of n that are larger than
+// SpillSize to temporary files. Namespace declarations stay in the tree.
+func (this *Document) spillAttrs(n *Node) error {
+  for _, a := range n.Attributes {
+    if len(a.Value) <= this.SpillSize || a.Func != nil || isNamespaceDecl(a) {
+      continue
+    }
+    f, err := ioutil.TempFile(this.SpillDir, "xmlx-attr-")
+    if err != nil {
+      return err
+    }
+    this.spilled = append(this.spilled, f.Name())
+    _, err = io.WriteString(f, a.Value)
+    if cerr := f.Close(); err == nil {
+      err = cerr
+    }
+    if err != nil {
+      return err
+    }
+    a.spill = &spillFile{f.Name(), int64(len(a.Value)), 1}
+    a.Value = ""
+  }
+  return nil
+}
+
+// printSpilled copies the value of a spilled attribute to the output,
+// escaped as attrEscaper does.
+func (p *printer) printSpilled(a *Attr) {
+  r, err := a.Reader()
+  if err == nil {
+    _, err = io.Copy(attrWriter{p}, r)
+    r.Close()
+  }
+  if err != nil {
+    p.err = fmt.Errorf("xmlx: attribute %s: %s", a.Name.Local, err)
+  }
+}
+
+// attrWriter escapes what is written to it with attrEscaper.
+type attrWriter struct {
+  w io.Writer
+}
+
+func (this attrWriter) Write(b []byte) (int, error) {
+  if _, err := attrEscaper.WriteString(this.w, string(b)); err != nil {
+    return 0, err
+  }
+  return len(b), nil
+}
@@ -0,0 +1,424 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "encoding/xml"
+  "fmt"
+  "io"
+  "math"
+  "strings"
+)
+
+// NodeID is the handle of a node in a Store.
+type NodeID int32
+
+// NoNode is returned by Store functions for nodes that do not exist.
+const NoNode NodeID = -1
+
+// Store is a read-only tree held in flat slices, one per node property,
+// with nodes referred to by NodeID instead of pointers. A Node costs a heap
+// object of its own plus its Children and Attributes slices; a Store node
+// costs about 30 bytes in a few large slices, which the garbage collector
+// does not need to scan. This suits documents with millions of small nodes
+// that are loaded to be queried, not changed.
+//
+// The query functions mirror those of Node, with the node to start from as
+// first argument: SelectNode, SelectNodes, SelectNodesRecursive, S, As,
+// GetValue. Node copies a part of the tree into Nodes, for use with the rest
+// of the package.
+//
+// Nodes are numbered in document order, starting with the NT_ROOT node, 0,
+// so the descendants of a node are the nodes that follow it up to End.
+type Store struct {
+  Namespaces map[string]string // Prefixes of the namespaces found, by URI, as in Document.
+
+  kind   []NodeType
+  space  []int32  // Names: namespace, as Document keeps it in Name.Space.
+  uri    []int32  // Names: namespace URI.
+  local  []int32  // Names: local name, or target of processing instructions.
+  parent []int32
+  next   []int32  // Next sibling.
+  first  []int32  // First child.
+  end    []int32  // One past the last descendant.
+  valOff []uint32 // Value: offset in text.
+  valLen []uint32 // Value: length.
+  attrs  []int32  // Index of the first attribute in the attribute columns.
+
+  attrSpace  []int32
+  attrLocal  []int32
+  attrValOff []uint32
+  attrValLen []uint32
+
+  names   []string
+  nameIdx map[string]int32
+  text    []byte
+}
+
+// LoadStore reads a document into a new Store. The Strict, Entity,
+// AutoClose and URISpaces options of this document apply; the document
+// itself is not changed. The other load options are not supported, and
+// LoadStore returns an error if any of them is set (see storeOptions). The
+// text and attribute values of a Store must fit in 4 GiB.
+func (this *Document) LoadStore(r io.Reader, charset CharsetFunc) (*Store, error) {
+  if err := this.storeOptions(); err != nil {
+    return nil, err
+  }
+
+  s := &Store{Namespaces: make(map[string]string), nameIdx: make(map[string]int32)}
+  s.intern("")
+  s.addNode(NT_ROOT, -1)
+
+  xp := this.newDecoder(skipBOM(r), charset)
+  open := []int32{0}
+  last := []int32{-1} // Last child of each open node.
+  for {
+    tok, err := xp.Token()
+    if err == io.EOF {
+      break
+    }
+    if err != nil {
+      return nil, err
+    }
+
+    ct := open[len(open)-1]
+    var id int32
+    switch tt := tok.(type) {
+    case xml.StartElement:
+      for _, a := range tt.Attr {
+        if a.Name.Space == "xmlns" {
+          s.Namespaces[a.Value] = a.Name.Local
+        } else if a.Name.Space == "" && a.Name.Local == "xmlns" && a.Value != "" {
+          s.Namespaces[a.Value] = ""
+        }
+      }
+      id = s.addNode(NT_ELEMENT, ct)
+      s.space[id] = s.intern(s.alias(tt.Name.Space, this.URISpaces))
+      s.uri[id] = s.intern(tt.Name.Space)
+      s.local[id] = s.intern(tt.Name.Local)
+      for _, a := range tt.Attr {
+        s.attrSpace = append(s.attrSpace, s.intern(s.alias(a.Name.Space, this.URISpaces)))
+        s.attrLocal = append(s.attrLocal, s.intern(a.Name.Local))
+        off, n := s.addText(a.Value)
+        s.attrValOff = append(s.attrValOff, off)
+        s.attrValLen = append(s.attrValLen, n)
+      }
+    case xml.EndElement:
+      if len(open) == 1 {
+        continue
+      }
+      s.end[ct] = int32(len(s.kind))
+      open, last = open[:len(open)-1], last[:len(last)-1]
+      continue
+    case xml.CharData:
+      id = s.addNode(NT_TEXT, ct)
+      s.valOff[id], s.valLen[id] = s.addText(string(tt))
+    case xml.Comment:
+      id = s.addNode(NT_COMMENT, ct)
+      s.valOff[id], s.valLen[id] = s.addText(normalizeNewlines(strings.TrimSpace(string(tt))))
+    case xml.Directive:
+      id = s.addNode(NT_DIRECTIVE, ct)
+      val := normalizeNewlines(strings.TrimSpace(string(tt)))
+      if strings.HasPrefix(val, "DOCTYPE") {
+        s.kind[id] = NT_DOCTYPE
+      }
+      s.valOff[id], s.valLen[id] = s.addText(val)
+    case xml.ProcInst:
+      if tt.Target == "xml" {
+        continue
+      }
+      id = s.addNode(NT_PROCINST, ct)
+      s.local[id] = s.intern(strings.TrimSpace(tt.Target))
+      s.valOff[id], s.valLen[id] = s.addText(normalizeNewlines(strings.TrimSpace(string(tt.Inst))))
+    default:
+      continue
+    }
+    // Node numbers are int32 and text offsets uint32; past that they wrap.
+    if len(s.kind) > math.MaxInt32 || uint64(len(s.text)) > math.MaxUint32 {
+      line, _ := xp.InputPos()
+      return nil, fmt.Errorf("xmlx: line %d: document too large for a Store", line)
+    }
+
+    if l := last[len(last)-1]; l < 0 {
+      s.first[ct] = id
+    } else {
+      s.next[l] = id
+    }
+    last[len(last)-1] = id
+    if s.kind[id] == NT_ELEMENT {
+      open, last = append(open, id), append(last, -1)
+    } else {
+      s.end[id] = id + 1
+    }
+  }
+
+  if len(open) > 1 {
+    return nil, fmt.Errorf("xmlx: unexpected end of input in <%s>", s.names[s.local[open[len(open)-1]]])
+  }
+  s.end[0] = int32(len(s.kind))
+  s.attrs = append(s.attrs, int32(len(s.attrLocal)))
+  return s, nil
+}
+
+// storeOptions returns an error naming the load options set in this
+// document that LoadStore does not apply.
+func (this *Document) storeOptions() error {
+  var set []string
+  for _, o := range []struct {
+    name string
+    set  bool
+  }{
+    {"Redact", len(this.Redact) > 0},
+    {"MaxTextSize", this.MaxTextSize > 0},
+    {"MaxEntities", this.MaxEntities > 0},
+    {"MaxEntitySize", this.MaxEntitySize > 0},
+    {"DupAttrs", this.DupAttrs != DUPATTR_IGNORE},
+    {"BadChars", this.BadChars != BADCHAR_ERROR},
+    {"StrictRoot", this.StrictRoot},
+    {"StrictNS", this.StrictNS},
+    {"NormalizeAttr", this.NormalizeAttr},
+    {"Normalize", this.Normalize != nil},
+    {"CoalesceText", this.CoalesceText},
+    {"Codecs", len(this.Codecs) > 0},
+    {"SpillSize", this.SpillSize > 0},
+  } {
+    if o.set {
+      set = append(set, o.name)
+    }
+  }
+  if len(set) > 0 {
+    return fmt.Errorf("xmlx: LoadStore does not support %s", strings.Join(set, ", "))
+  }
+  return nil
+}
+
+func (this *Store) addNode(kind NodeType, parent int32) int32 {
+  id := int32(len(this.kind))
+  this.kind = append(this.kind, kind)
+  this.space = append(this.space, 0)
+  this.uri = append(this.uri, 0)
+  this.local = append(this.local, 0)
+  this.parent = append(this.parent, parent)
+  this.next = append(this.next, -1)
+  this.first = append(this.first, -1)
+  this.end = append(this.end, 0)
+  this.valOff = append(this.valOff, 0)
+  this.valLen = append(this.valLen, 0)
+  this.attrs = append(this.attrs, int32(len(this.attrLocal)))
+  return id
+}
+
+func (this *Store) intern(s string) int32 {
+  if i, ok := this.nameIdx[s]; ok {
+    return i
+  }
+  i := int32(len(this.names))
+  this.names = append(this.names, s)
+  this.nameIdx[s] = i
+  return i
+}
+
+func (this *Store) addText(s string) (uint32, uint32) {
+  off := uint32(len(this.text))
+  this.text = append(this.text, s...)
+  return off, uint32(len(s))
+}
+
+// alias returns what Document keeps in Name.Space for the namespace uri.
+func (this *Store) alias(uri string, uriSpaces bool) string {
+  if prefix, ok := this.Namespaces[uri]; ok && !uriSpaces {
+    return prefix
+  }
+  return uri
+}
+
+func (this *Store) valid(id NodeID) bool {
+  return id >= 0 && int(id) < len(this.kind)
+}
+
+func (this *Store) handle(i int32) NodeID {
+  if i < 0 {
+    return NoNode
+  }
+  return NodeID(i)
+}
+
+// Root returns the NT_ROOT node.
+func (this *Store) Root() NodeID { return 0 }
+
+// Len returns the number of nodes, the NT_ROOT node included.
+func (this *Store) Len() int { return len(this.kind) }
+
+// Type returns the type of the node.
+func (this *Store) Type(id NodeID) NodeType { return this.kind[id] }
+
+// Name returns the name of the node, as Node.Name would hold it.
+func (this *Store) Name(id NodeID) xml.Name {
+  return xml.Name{Space: this.names[this.space[id]], Local: this.names[this.local[id]]}
+}
+
+// Value returns the text of a text node, comment, processing instruction or
+// directive; "" for elements.
+func (this *Store) Value(id NodeID) string {
+  off := this.valOff[id]
+  return string(this.text[off : off+this.valLen[id]])
+}
+
+// Parent returns the parent of the node, or NoNode for the NT_ROOT node.
+func (this *Store) Parent(id NodeID) NodeID { return this.handle(this.parent[id]) }
+
+// FirstChild returns the first child of the node, or NoNode.
+func (this *Store) FirstChild(id NodeID) NodeID { return this.handle(this.first[id]) }
+
+// NextSibling returns the node following this one under the same parent,
+// or NoNode.
+func (this *Store) NextSibling(id NodeID) NodeID { return this.handle(this.next[id]) }
+
+// End returns the node following the last descendant of the node; the
+// descendants are the nodes in between.
+func (this *Store) End(id NodeID) NodeID { return NodeID(this.end[id]) }
+
+// Children returns the children of the node.
+func (this *Store) Children(id NodeID) []NodeID {
+  var list []NodeID
+  for c := this.first[id]; c >= 0; c = this.next[c] {
+    list = append(list, NodeID(c))
+  }
+  return list
+}
+
+// Attrs returns copies of the attributes of the node.
+func (this *Store) Attrs(id NodeID) []Attr {
+  from, to := this.attrs[id], this.attrs[id+1]
+  list := make([]Attr, 0, to-from)
+  for i := from; i < to; i++ {
+    list = append(list, Attr{
+      Name:  xml.Name{Space: this.names[this.attrSpace[i]], Local: this.names[this.attrLocal[i]]},
+      Value: string(this.text[this.attrValOff[i] : this.attrValOff[i]+this.attrValLen[i]]),
+    })
+  }
+  return list
+}
+
+// As returns the value of the given attribute of the node, as Node.As.
+func (this *Store) As(id NodeID, namespace, name string) string {
+  if i := this.attr(id, namespace, name); i >= 0 {
+    return string(this.text[this.attrValOff[i] : this.attrValOff[i]+this.attrValLen[i]])
+  }
+  return ""
+}
+
+// HasAttr reports whether the node has the given attribute, as Node.HasAttr.
+func (this *Store) HasAttr(id NodeID, namespace, name string) bool {
+  return this.attr(id, namespace, name) >= 0
+}
+
+func (this *Store) attr(id NodeID, namespace, name string) int32 {
+  for i := this.attrs[id]; i < this.attrs[id+1]; i++ {
+    if this.names[this.attrLocal[i]] == name && (namespace == "*" || this.names[this.attrSpace[i]] == namespace) {
+      return i
+    }
+  }
+  return -1
+}
+
+// matches reports whether node i has the given name, as Node.matches does.
+func (this *Store) matches(i int32, namespace, name string) bool {
+  if name == "*" {
+    if this.kind[i] != NT_ELEMENT {
+      return false
+    }
+  } else if this.names[this.local[i]] != name {
+    return false
+  }
+  return namespace == "*" || this.names[this.space[i]] == namespace ||
+    (this.kind[i] == NT_ELEMENT && namespace != "" && this.names[this.uri[i]] == namespace)
+}
+
+// SelectNode returns the first node with the given name at or below id, in
+// document order, or NoNode; as Node.SelectNode.
+func (this *Store) SelectNode(id NodeID, namespace, name string) NodeID {
+  for i := int32(id); i < this.end[id]; i++ {
+    if this.matches(i, namespace, name) {
+      return NodeID(i)
+    }
+  }
+  return NoNode
+}
+
+// SelectNodes returns the children of id with the given name, as
+// Node.SelectNodes.
+func (this *Store) SelectNodes(id NodeID, namespace, name string) []NodeID {
+  var list []NodeID
+  for c := this.first[id]; c >= 0; c = this.next[c] {
+    if this.matches(c, namespace, name) {
+      list = append(list, NodeID(c))
+    }
+  }
+  return list
+}
+
+// SelectNodesRecursive returns the descendants of id with the given name,
+// in document order, as Node.SelectNodesRecursive.
+func (this *Store) SelectNodesRecursive(id NodeID, namespace, name string) []NodeID {
+  var list []NodeID
+  for i := int32(id) + 1; i < this.end[id]; i++ {
+    if this.matches(i, namespace, name) {
+      list = append(list, NodeID(i))
+    }
+  }
+  return list
+}
+
+// GetValue returns the text directly inside the node, as Node.GetValue.
+func (this *Store) GetValue(id NodeID) string {
+  res := ""
+  for c := this.first[id]; c >= 0; c = this.next[c] {
+    if this.kind[c] == NT_TEXT {
+      res += strings.TrimSpace(this.Value(NodeID(c)))
+    }
+  }
+  return res
+}
+
+// S returns the text of the first node with the given name at or below id,
+// as Node.S.
+func (this *Store) S(id NodeID, namespace, name string) string {
+  if n := this.SelectNode(id, namespace, name); n != NoNode {
+    return this.GetValue(n)
+  }
+  return ""
+}
+
+// Node copies the node and its descendants into a new tree of Nodes, with
+// no parent. The namespaces declared on its ancestors are not copied.
+func (this *Store) Node(id NodeID) *Node {
+  if !this.valid(id) {
+    return nil
+  }
+  return this.rec_Node(int32(id))
+}
+
+func (this *Store) rec_Node(i int32) *Node {
+  n := NewNode(this.kind[i])
+  switch n.Type {
+  case NT_ELEMENT:
+    n.Name = this.Name(NodeID(i))
+    for _, a := range this.Attrs(NodeID(i)) {
+      c := a
+      n.Attributes = append(n.Attributes, &c)
+    }
+  case NT_PROCINST:
+    n.Target = this.names[this.local[i]]
+    n.Value = this.Value(NodeID(i))
+  case NT_ROOT:
+  default:
+    n.Value = this.Value(NodeID(i))
+  }
+  for c := this.first[i]; c >= 0; c = this.next[c] {
+    n.AddChild(this.rec_Node(c))
+  }
+  return n
+}
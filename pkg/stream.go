@@ -0,0 +1,323 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "bufio"
+  "bytes"
+  "encoding/json"
+  "encoding/xml"
+  "fmt"
+  "io"
+  "sort"
+  "strings"
+)
+
+// SubtreeReader reads the elements found at a given path in a stream one at
+// a time, without holding the rest of the document in memory. This makes it
+// possible to process documents made of many repeating records, such as
+// feeds or exports, that are too large to load as a whole.
+//
+// Load options (Strict, Namespaces, MaxTextSize, ...) are taken from Doc and
+// may be changed before the first call to Next.
+type SubtreeReader struct {
+  Doc    *Document // Supplies the load options.
+  r      io.Reader
+  path   *path
+  ld     *loader
+  stack  []*Node   // Open elements enclosing the current position.
+  offset int64     // Offset in the input of the first byte given to the decoder.
+}
+
+// NewSubtreeReader returns a reader for the elements of r at elementPath, a
+// '/' separated list of [prefix:]name steps starting with the document
+// element, e.g. "feed/entry". '*' matches any prefix or name.
+func NewSubtreeReader(r io.Reader, elementPath string) (*SubtreeReader, error) {
+  p, err := parsePath(elementPath)
+  if err != nil {
+    return nil, err
+  }
+  if p.attr != nil {
+    return nil, fmt.Errorf("xmlx: attribute step not allowed in element path %q", elementPath)
+  }
+  return &SubtreeReader{Doc: New(), r: r, path: p}, nil
+}
+
+// Next returns the next matching element. It returns io.EOF once the stream
+// is exhausted. The element is detached from its ancestors, which are not
+// kept; namespace declarations in scope are copied onto it, so it can be
+// saved on its own.
+func (this *SubtreeReader) Next() (*Node, error) {
+  if this.ld == nil {
+    // The loader drops a byte order mark, which Checkpoint has to count.
+    br := bufio.NewReader(this.r)
+    if b, err := br.Peek(len(utf8BOM)); err == nil && string(b) == utf8BOM {
+      br.Discard(len(utf8BOM))
+      this.offset += int64(len(utf8BOM))
+    }
+    ld, err := this.Doc.newLoader(br, nil)
+    if err != nil {
+      return nil, err
+    }
+    this.ld = ld
+    this.Doc.Root = this.Doc.newNode(NT_ROOT)
+    this.Doc.Warnings = nil
+  }
+
+  var top, ct *Node
+  for {
+    tok, err := this.ld.xp.Token()
+    if err != nil {
+      if err == io.EOF && top != nil {
+        err = io.ErrUnexpectedEOF
+      }
+      return nil, err
+    }
+
+    if top != nil {
+      if ct, err = this.Doc.loadToken(this.ld, ct, tok); err != nil {
+        return nil, err
+      }
+      if ct == top.Parent {
+        detachSubtree(top)
+        return top, nil
+      }
+      continue
+    }
+
+    switch tok.(type) {
+    case xml.StartElement:
+      parent := this.Doc.Root
+      if len(this.stack) > 0 {
+        parent = this.stack[len(this.stack)-1]
+      }
+      if ct, err = this.Doc.loadToken(this.ld, parent, tok); err != nil {
+        return nil, err
+      }
+      // Keep only the link upwards, so finished records can be freed.
+      parent.Children[len(parent.Children)-1] = nil
+      parent.Children = parent.Children[:len(parent.Children)-1]
+
+      if this.matches(ct) {
+        top = ct
+      } else {
+        this.stack = append(this.stack, ct)
+      }
+    case xml.EndElement:
+      if len(this.stack) > 0 {
+        this.stack = this.stack[:len(this.stack)-1]
+      }
+    }
+  }
+}
+
+// matches reports whether the newly opened element t, together with the
+// open elements enclosing it, is at the reader's path.
+func (this *SubtreeReader) matches(t *Node) bool {
+  steps := this.path.steps
+  if len(this.stack) != len(steps)-1 {
+    return false
+  }
+  for i, n := range this.stack {
+    if !n.matches(steps[i].space, steps[i].local) {
+      return false
+    }
+  }
+  s := steps[len(steps)-1]
+  return t.matches(s.space, s.local)
+}
+
+// Checkpoint is the position of a SubtreeReader between two elements, from
+// which reading can be resumed with ResumeSubtreeReader, e.g. after the
+// process ingesting a huge file was restarted. It can be stored as JSON.
+type Checkpoint struct {
+  Offset int64    // Byte offset in the input where reading continues.
+  Stack  []string // Start tags of the elements open at Offset, outermost first.
+}
+
+// Checkpoint returns the current position of the reader: just after the
+// element last returned by Next. Offsets are only right for UTF-8 input
+// loaded with Doc.BadChars left at BADCHAR_ERROR, as other settings change
+// the bytes seen by the decoder.
+func (this *SubtreeReader) Checkpoint() Checkpoint {
+  cp := Checkpoint{Offset: this.offset}
+  if this.ld != nil {
+    cp.Offset += this.ld.xp.InputOffset()
+  }
+  for _, n := range this.stack {
+    cp.Stack = append(cp.Stack, startTag(n))
+  }
+  return cp
+}
+
+// ResumeSubtreeReader returns a reader for the elements of r at elementPath,
+// as NewSubtreeReader does, that starts at the position saved in cp by
+// Checkpoint. r is the same input the checkpoint was taken from. The start
+// tags in cp are read again first, so the namespaces they declare are in
+// scope and the end tags that follow are matched.
+func ResumeSubtreeReader(r io.ReadSeeker, elementPath string, cp Checkpoint) (*SubtreeReader, error) {
+  sr, err := NewSubtreeReader(r, elementPath)
+  if err != nil {
+    return nil, err
+  }
+  if _, err = r.Seek(cp.Offset, io.SeekStart); err != nil {
+    return nil, err
+  }
+  open := strings.Join(cp.Stack, "")
+  sr.r = io.MultiReader(strings.NewReader(open), r)
+  sr.offset = cp.Offset - int64(len(open))
+  return sr, nil
+}
+
+// startTag returns the start tag of n, with its attributes.
+func startTag(n *Node) string {
+  var buf bytes.Buffer
+  buf.WriteString("<" + n.QualifiedName())
+  for _, a := range n.Attributes {
+    buf.WriteRune(' ')
+    if a.Name.Space != "" {
+      buf.WriteString(n.spacePrefix(a.Name.Space) + ":")
+    }
+    buf.WriteString(a.Name.Local + `="`)
+    xml.EscapeText(&buf, []byte(a.value()))
+    buf.WriteRune('"')
+  }
+  buf.WriteRune('>')
+  return buf.String()
+}
+
+// detachSubtree cuts t loose from its parent, declaring the namespaces in
+// scope on t itself first.
+func detachSubtree(t *Node) {
+  ctx := t.Parent.NamespaceContext()
+  own := make(map[string]bool)
+  for _, a := range t.Attributes {
+    if a.Name.Space == "" && a.Name.Local == "xmlns" {
+      own[""] = true
+    } else if a.Name.Space == "xmlns" {
+      own[a.Name.Local] = true
+    }
+  }
+
+  prefixes := make([]string, 0, len(ctx))
+  for prefix := range ctx {
+    if !own[prefix] {
+      prefixes = append(prefixes, prefix)
+    }
+  }
+  sort.Strings(prefixes)
+
+  for _, prefix := range prefixes {
+    a := &Attr{Name: xml.Name{Space: "xmlns", Local: prefix}, Value: ctx[prefix]}
+    if prefix == "" {
+      a.Name = xml.Name{Local: "xmlns"}
+    }
+    t.Attributes = append(t.Attributes, a)
+  }
+
+  t.Parent = nil
+}
+
+// StreamToNDJSON converts every element at elementPath in r to a single line
+// of JSON written to w, as newline delimited JSON. Elements are mapped as by
+// Node.JSONValue. The document is read as a stream and never loaded as a
+// whole, so memory use is bounded by the size of the largest element.
+func StreamToNDJSON(r io.Reader, elementPath string, w io.Writer) error {
+  sr, err := NewSubtreeReader(r, elementPath)
+  if err != nil {
+    return err
+  }
+
+  enc := json.NewEncoder(w)
+  enc.SetEscapeHTML(false)
+  for {
+    n, err := sr.Next()
+    if err == io.EOF {
+      return nil
+    }
+    if err != nil {
+      return err
+    }
+    if err = enc.Encode(n.JSONValue()); err != nil {
+      return err
+    }
+  }
+}
+
+// BatchReader groups the elements read by a SubtreeReader into batches of a
+// fixed size, for jobs that process large streams in chunks. Only the batch
+// being read and the batches waiting to be consumed are held in memory.
+type BatchReader struct {
+  sr   *SubtreeReader
+  size int
+  err  error
+}
+
+// NewBatchReader returns a reader delivering batches of at most size
+// elements read from sr.
+func NewBatchReader(sr *SubtreeReader, size int) *BatchReader {
+  if size < 1 {
+    size = 1
+  }
+  return &BatchReader{sr: sr, size: size}
+}
+
+// Next returns the next batch of elements. The last batch may be shorter.
+// It returns io.EOF once the stream is exhausted. When reading fails part
+// way through a batch, the elements read so far are returned with the error.
+func (this *BatchReader) Next() ([]*Node, error) {
+  batch := make([]*Node, 0, this.size)
+  for len(batch) < this.size {
+    n, err := this.sr.Next()
+    if err == io.EOF {
+      break
+    }
+    if err != nil {
+      return batch, err
+    }
+    batch = append(batch, n)
+  }
+  if len(batch) == 0 {
+    return nil, io.EOF
+  }
+  return batch, nil
+}
+
+// Batches reads the stream in a separate goroutine and sends the batches on
+// the returned channel, which buffers at most buffer of them. Reading pauses
+// while the buffer is full, so a slow consumer holds back the reader rather
+// than letting memory grow. The channel is closed when the stream ends, when
+// reading fails or when done is closed; Err tells these cases apart. The
+// channel may be consumed by several workers at once.
+func (this *BatchReader) Batches(done <-chan struct{}, buffer int) <-chan []*Node {
+  ch := make(chan []*Node, buffer)
+  go func() {
+    defer close(ch)
+    for {
+      batch, err := this.Next()
+      if len(batch) > 0 {
+        select {
+        case ch <- batch:
+        case <-done:
+          return
+        }
+      }
+      if err != nil {
+        if err != io.EOF {
+          this.err = err
+        }
+        return
+      }
+    }
+  }()
+  return ch
+}
+
+// Err returns the error that ended Batches, or nil if the stream was read to
+// its end or reading was stopped. It must only be called after the channel
+// returned by Batches has been closed.
+func (this *BatchReader) Err() error {
+  return this.err
+}
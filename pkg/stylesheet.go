@@ -0,0 +1,84 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "strings"
+)
+
+// Stylesheet describes a single <?xml-stylesheet?> processing instruction as
+// defined by http://www.w3.org/TR/xml-stylesheet/.
+type Stylesheet struct {
+  Href      string // Location of the stylesheet.
+  Type      string // Mime type, e.g. "text/xsl" or "text/css".
+  Media     string // Intended destination medium.
+  Title     string // Title of the stylesheet.
+  Charset   string // Character encoding of the stylesheet.
+  Alternate bool   // True if alternate="yes".
+  Node      *Node  // The NT_PROCINST node this was parsed from.
+}
+
+// Stylesheets returns all xml-stylesheet processing instructions found in the
+// document prolog, in document order. Instructions without an href
+// pseudo-attribute are skipped, as the specification requires.
+func (this *Document) Stylesheets() []*Stylesheet {
+  list := make([]*Stylesheet, 0, 2)
+  if this.Root == nil {
+    return list
+  }
+
+  for _, v := range this.Root.Children {
+    if v.Type == NT_ELEMENT {
+      break
+    }
+    if v.Type != NT_PROCINST || v.Target != "xml-stylesheet" {
+      continue
+    }
+
+    attr := parsePseudoAttrs(v.Value)
+    if attr["href"] == "" {
+      continue
+    }
+
+    list = append(list, &Stylesheet{
+      Href:      attr["href"],
+      Type:      attr["type"],
+      Media:     attr["media"],
+      Title:     attr["title"],
+      Charset:   attr["charset"],
+      Alternate: attr["alternate"] == "yes",
+      Node:      v,
+    })
+  }
+
+  return list
+}
+
+// parsePseudoAttrs parses the name="value" pairs found in the body of
+// processing instructions like xml-stylesheet.
+func parsePseudoAttrs(s string) map[string]string {
+  attr := make(map[string]string)
+
+  for {
+    s = strings.TrimSpace(s)
+    eq := strings.IndexByte(s, '=')
+    if eq < 1 {
+      return attr
+    }
+    name := strings.TrimSpace(s[:eq])
+
+    s = strings.TrimSpace(s[eq+1:])
+    if len(s) == 0 || (s[0] != '"' && s[0] != '\'') {
+      return attr
+    }
+    end := strings.IndexByte(s[1:], s[0])
+    if end < 0 {
+      return attr
+    }
+
+    attr[name] = s[1 : end+1]
+    s = s[end+2:]
+  }
+}
@@ -0,0 +1,431 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "bytes"
+  "encoding/xml"
+  "errors"
+  "fmt"
+  "sort"
+  "strings"
+)
+
+// ToDocument returns a new document holding a deep copy of this node as its
+// root element, ready to be saved or sent on its own. The namespaces in
+// scope at the node are declared on the copy, all of them, as prefixes may
+// be used in attribute values or text (e.g. xsi:type="ns:T") where they
+// cannot be detected. The document has the settings of New; use
+// Document.ToDocument to keep those of the document the node comes from.
+//
+// Loading a document expands entity references into text, so the copy only
+// holds references (NT_ENTITYREF) a program added to the tree. For those,
+// the declarations are taken over from the DOCTYPE of the original tree into
+// a new DOCTYPE, and internal entities are added to Document.Entity.
+//
+// Called on an NT_ROOT node, the whole tree is copied.
+func (this *Node) ToDocument() *Document {
+  return this.toDocument(New())
+}
+
+// ToDocument is like Node.ToDocument, but the new document gets the load and
+// save settings of this one, which n belongs to, along with copies of its
+// Entity, Namespaces, AttrTypes and Codecs maps.
+func (this *Document) ToDocument(n *Node) *Document {
+  doc := this.cloneSettings()
+  if doc.Entity == nil {
+    doc.Entity = make(map[string]string)
+  }
+  if doc.Namespaces == nil {
+    doc.Namespaces = make(map[string]string)
+  }
+  return n.toDocument(doc)
+}
+
+func (this *Node) toDocument(doc *Document) *Document {
+  t := this.Clone()
+
+  if this.Type == NT_ROOT {
+    doc.Root = t
+    return doc
+  }
+
+  doc.Root = NewNode(NT_ROOT)
+  if this.Type == NT_ELEMENT && this.Parent != nil {
+    t.Parent = this.Parent
+    detachSubtree(t)
+    for _, a := range t.Attributes {
+      if a.Name.Space == "xmlns" {
+        doc.Namespaces[a.Value] = a.Name.Local
+      } else if a.Name.Space == "" && a.Name.Local == "xmlns" {
+        doc.Namespaces[a.Value] = ""
+      }
+    }
+  }
+
+  refs := make(map[string]bool)
+  rec_EntityRefs(t, refs)
+  if len(refs) > 0 {
+    if decls := entityDecls(this, refs, doc.Entity); len(decls) > 0 {
+      dt := NewNode(NT_DOCTYPE)
+      dt.Value = "DOCTYPE " + t.QualifiedName() + " [ " + strings.Join(decls, " ") + " ]"
+      doc.Root.AddChild(dt)
+    }
+  }
+
+  doc.Root.AddChild(t)
+  return doc
+}
+
+func rec_EntityRefs(cn *Node, refs map[string]bool) {
+  if cn.Type == NT_ENTITYREF {
+    refs[cn.Value] = true
+  }
+  for _, v := range cn.Children {
+    rec_EntityRefs(v, refs)
+  }
+}
+
+// entityDecls looks up the declarations of the named general entities in the
+// DOCTYPE of the tree n belongs to. Internal entities are also stored in
+// entity, keyed by name.
+func entityDecls(n *Node, names map[string]bool, entity map[string]string) []string {
+  for n.Parent != nil {
+    n = n.Parent
+  }
+
+  decls := make([]string, 0, len(names))
+  for _, v := range n.Children {
+    if v.Type != NT_DOCTYPE {
+      continue
+    }
+
+    doctype := v.Value
+    for {
+      i := strings.Index(doctype, "<!ENTITY")
+      if i < 0 {
+        break
+      }
+      doctype = doctype[i+len("<!ENTITY"):]
+
+      tokens, rest := dtdTokens(doctype)
+      doctype = rest
+      if len(tokens) < 2 || tokens[0] == "%" || !names[tokens[0]] {
+        continue
+      }
+
+      decls = append(decls, "<!ENTITY "+strings.Join(tokens, " ")+">")
+      if len(tokens) == 2 && len(tokens[1]) >= 2 && strings.IndexByte(`"'`, tokens[1][0]) > -1 {
+        entity[tokens[0]] = tokens[1][1 : len(tokens[1])-1]
+      }
+    }
+  }
+  return decls
+}
+
+// ImportNode returns a copy of n, which may belong to another document, for
+// use in this one, as DOM's importNode does. With deep set the whole subtree
+// is copied, otherwise only the node and its attributes. The copy has no
+// parent; add it where it belongs with AddChild. The original is not
+// changed.
+//
+// The namespaces in scope at n are declared on the copy, so its names keep
+// their meaning wherever it is put, and are added to Document.Namespaces
+// unless their URIs are known already.
+//
+// Loading expands entity references into text, so only references
+// (NT_ENTITYREF) a program added to the source tree are left in the copy.
+// These are reconciled with Document.Entity: entities declared in the
+// DOCTYPE of the source tree but unknown here are added to it, and
+// references to entities this document defines differently are replaced by
+// the text they stood for.
+func (this *Document) ImportNode(n *Node, deep bool) *Node {
+  var t *Node
+  if deep {
+    t = n.Clone()
+  } else {
+    t = n.cloneShallow()
+  }
+
+  if n.Type == NT_ELEMENT && n.Parent != nil {
+    t.Parent = n.Parent
+    detachSubtree(t)
+  }
+  this.registerNamespaces(t)
+  this.importEntities(t, n)
+  return t
+}
+
+// Adopt moves n, which may belong to another document, out of its tree for
+// use in this one, and returns it. It is the same as ImportNode with deep
+// set, except that n itself is taken instead of a copy: the namespaces in
+// scope are declared on it, they are added to Document.Namespaces, and
+// entity references added by a program are reconciled with Document.Entity.
+// Put the node in place with MoveTo, which also drops declarations the new
+// parent makes unnecessary.
+func (this *Document) Adopt(n *Node) *Node {
+  this.importEntities(n, n)
+  if p := n.Parent; p != nil {
+    if n.Type == NT_ELEMENT {
+      detachSubtree(n)
+    }
+    p.RemoveChild(n)
+  }
+  this.registerNamespaces(n)
+  return n
+}
+
+// registerNamespaces adds the namespaces declared on t to Document.Namespaces,
+// unless their URIs are known already.
+func (this *Document) registerNamespaces(t *Node) {
+  for _, a := range t.Attributes {
+    uri, prefix := a.Value, ""
+    if a.Name.Space == "xmlns" {
+      prefix = a.Name.Local
+    } else if a.Name.Space != "" || a.Name.Local != "xmlns" {
+      continue
+    }
+    if _, ok := this.Namespaces[uri]; !ok && uri != "" {
+      this.Namespaces[uri] = prefix
+    }
+  }
+}
+
+// importEntities reconciles the NT_ENTITYREF nodes in t with
+// Document.Entity, looking up their declarations in the tree of src.
+func (this *Document) importEntities(t, src *Node) {
+  refs := make(map[string]bool)
+  rec_EntityRefs(t, refs)
+  if len(refs) > 0 {
+    decls := make(map[string]string)
+    entityDecls(src, refs, decls)
+    rec_ImportEntities(t, decls, this.Entity)
+  }
+}
+
+// MoveTo makes this node the last child of parent, which may be in another
+// tree, keeping the meaning of its names. For elements, the namespaces in
+// scope at the old position are declared on the node, except those parent
+// already binds the same way, and the default namespace of parent is
+// undeclared if the node was not in one. Document.Namespaces of the target
+// document is not updated; see Document.Adopt. An error is returned if
+// parent is this node or lies below it.
+func (this *Node) MoveTo(parent *Node) error {
+  if isWithin(parent, this) {
+    return errors.New("xmlx: cannot move a node into itself")
+  }
+
+  if p := this.Parent; p != nil {
+    if this.Type == NT_ELEMENT {
+      detachSubtree(this)
+    }
+    p.RemoveChild(this)
+  }
+
+  if this.Type == NT_ELEMENT {
+    ctx := parent.NamespaceContext()
+    hasDefault := false
+    for _, a := range this.Attributes {
+      if a.Name.Space == "" && a.Name.Local == "xmlns" {
+        hasDefault = true
+      }
+    }
+    if !hasDefault && ctx[""] != "" {
+      this.Attributes = append(this.Attributes, &Attr{Name: xml.Name{Local: "xmlns"}})
+    }
+    dropRedundantNS(this, ctx)
+  }
+
+  parent.AddChild(this)
+  return nil
+}
+
+func rec_ImportEntities(cn *Node, src, entity map[string]string) {
+  if cn.Type == NT_ENTITYREF {
+    if val, ok := src[cn.Value]; ok {
+      if old, has := entity[cn.Value]; !has {
+        entity[cn.Value] = val
+      } else if old != val {
+        cn.Type, cn.Value = NT_TEXT, val
+      }
+    }
+  }
+  for _, v := range cn.Children {
+    rec_ImportEntities(v, src, entity)
+  }
+}
+
+// Project returns a pruned copy of the document holding only what the given
+// paths lead to, along with the ancestors needed to keep it in place. Paths
+// start at the document element, as with SelectNodesByPath, and may have
+// predicates. Elements are kept with everything below them; a path ending in
+// an attribute step keeps just that attribute of the elements, without
+// their content. Ancestors are kept with their attributes but only the
+// children that lead to selected nodes. Paths that select nothing are
+// ignored, so the copy may be empty; a malformed path is an error.
+//
+// The copy has the load and save settings of the document and copies of its
+// Entity, Namespaces, AttrTypes and Codecs maps, as with Clone; the original
+// document is not changed.
+func (this *Document) Project(paths []string) (*Document, error) {
+  list := make([]*path, len(paths))
+  for i, expr := range paths {
+    p, err := parsePath(expr)
+    if err != nil {
+      return nil, err
+    }
+    list[i] = p
+  }
+
+  doc := this.cloneSettings()
+  doc.Root = NewNode(NT_ROOT)
+  if this.Root == nil {
+    return doc, nil
+  }
+
+  full := make(map[*Node]bool)     // Kept with all their content.
+  attrs := make(map[*Node][]*Attr) // Kept with only these attributes.
+  needed := make(map[*Node]bool)   // On the way to a kept node.
+
+  for _, p := range list {
+    for _, n := range p.selectNodes(this.Root, 0) {
+      if p.attr == nil {
+        full[n] = true
+      } else {
+        for _, a := range n.Attributes {
+          if (p.attr.space == "*" || p.attr.space == a.Name.Space) && p.attr.local == a.Name.Local {
+            attrs[n] = append(attrs[n], a)
+          }
+        }
+        if len(attrs[n]) == 0 {
+          continue
+        }
+      }
+      for v := n; v != nil && !needed[v]; v = v.Parent {
+        needed[v] = true
+      }
+    }
+  }
+
+  for _, v := range this.Root.Children {
+    if needed[v] {
+      doc.Root.AddChild(rec_Project(v, full, attrs, needed))
+    }
+  }
+  return doc, nil
+}
+
+func rec_Project(cn *Node, full map[*Node]bool, attrs map[*Node][]*Attr, needed map[*Node]bool) *Node {
+  if full[cn] {
+    return cn.Clone()
+  }
+
+  t := cn.cloneShallow()
+  if list, ok := attrs[cn]; ok {
+    t.Attributes = t.Attributes[:0]
+    for _, a := range cn.Attributes {
+      if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+        t.Attributes = append(t.Attributes, a.copy())
+        continue
+      }
+      for _, b := range list {
+        if a == b {
+          t.Attributes = append(t.Attributes, a.copy())
+          break
+        }
+      }
+    }
+  }
+
+  for _, v := range cn.Children {
+    if needed[v] {
+      t.AddChild(rec_Project(v, full, attrs, needed))
+    }
+  }
+  return t
+}
+
+// SetInnerXML replaces the content of this node with the nodes parsed from
+// the XML fragment s, which may hold any number of elements, text, comments
+// and processing instructions, and may start with an XML declaration naming
+// its encoding for charset to convert. The prefixes in scope at this node
+// can be used in s without declaring them. Only the predefined entities and
+// character references are recognized. On error the node is left as it is.
+func (this *Node) SetInnerXML(s string, charset CharsetFunc) error {
+  nodes, err := this.parseFragment(s, charset)
+  if err != nil {
+    return err
+  }
+  for _, v := range this.Children {
+    v.Parent = nil
+  }
+  this.Children = this.Children[:0]
+  this.Value = ""
+  this.AddChildren(nodes...)
+  return nil
+}
+
+// AppendRawXML parses the UTF-8 XML fragment s as SetInnerXML does, and
+// adds the nodes in it after the current children of this node.
+func (this *Node) AppendRawXML(s string) error {
+  nodes, err := this.parseFragment(s, nil)
+  if err != nil {
+    return err
+  }
+  this.AddChildren(nodes...)
+  return nil
+}
+
+// parseFragment parses s inside a wrapper element that declares the
+// namespaces in scope at this node, and returns the nodes found in it.
+func (this *Node) parseFragment(s string, charset CharsetFunc) ([]*Node, error) {
+  if this.Type != NT_ELEMENT && this.Type != NT_ROOT {
+    return nil, fmt.Errorf("xmlx: node of type %d cannot have children", this.Type)
+  }
+
+  var buf bytes.Buffer
+  body := strings.TrimLeft(s, " \t\r\n")
+  if strings.HasPrefix(body, "<?xml ") {
+    if i := strings.Index(body, "?>"); i > 0 {
+      buf.WriteString(body[:i+2])
+      body = body[i+2:]
+    }
+  }
+
+  ctx := this.NamespaceContext()
+  prefixes := make([]string, 0, len(ctx))
+  for prefix := range ctx {
+    prefixes = append(prefixes, prefix)
+  }
+  sort.Strings(prefixes)
+
+  buf.WriteString("<xmlx-fragment")
+  for _, prefix := range prefixes {
+    if prefix == "" {
+      buf.WriteString(` xmlns="`)
+    } else {
+      buf.WriteString(" xmlns:" + prefix + `="`)
+    }
+    xml.EscapeText(&buf, []byte(ctx[prefix]))
+    buf.WriteRune('"')
+  }
+  buf.WriteRune('>')
+  buf.WriteString(body)
+  buf.WriteString("</xmlx-fragment>")
+
+  doc := New()
+  if err := doc.LoadBytes(buf.Bytes(), charset); err != nil {
+    return nil, err
+  }
+  wrapper := doc.documentElement()
+  if wrapper == nil || wrapper.Name.Local != "xmlx-fragment" {
+    return nil, errors.New("xmlx: malformed XML fragment")
+  }
+  nodes := wrapper.Children
+  for _, v := range nodes {
+    v.Parent = nil
+  }
+  return nodes, nil
+}
@@ -0,0 +1,134 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "encoding/json"
+  "regexp"
+  "strings"
+)
+
+// JSONTypes tells TypedJSONValue which elements repeat and which values are
+// numbers or booleans, so the JSON has the same shape whatever the content:
+// an element that may repeat is always an array, even when there is only
+// one, and numbers and booleans are written unquoted, or as null when empty.
+// It can be written by hand or inferred from sample documents with
+// InferJSONTypes. It is not a JSON Schema document.
+//
+// Both maps are keyed by paths of [prefix:]local names relative to the node
+// converted, e.g. "item", "item/price" or "item/@qty"; "" is the text of
+// that node itself. The paths are not affected by Names.
+type JSONTypes struct {
+  Arrays map[string]bool   // Elements always mapped to arrays.
+  Types  map[string]string // Types of element text and attribute values: "number", "boolean" or "string".
+  Names  *JSONNames        // If set, maps the keys of the result.
+}
+
+var jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// InferJSONTypes builds the types from sample elements, which should all be
+// of the same kind (e.g. the document elements of several messages). An
+// element is an array if it occurs more than once under any one parent. A
+// value is a number or boolean ("true" or "false") if every non-empty value
+// found at its path is one.
+func InferJSONTypes(samples ...*Node) *JSONTypes {
+  s := &JSONTypes{Arrays: make(map[string]bool), Types: make(map[string]string)}
+  for _, n := range samples {
+    rec_InferJSON(n, "", s)
+  }
+  return s
+}
+
+func rec_InferJSON(cn *Node, path string, s *JSONTypes) {
+  for _, a := range cn.Attributes {
+    if (a.Name.Space == "" && a.Name.Local == "xmlns") || a.Name.Space == "xmlns" {
+      continue
+    }
+    s.observe(joinPath(path, "@"+qualifiedKey(a.Name.Space, a.Name.Local)), a.value())
+  }
+
+  text := ""
+  counts := make(map[string]int)
+  for _, c := range cn.Children {
+    switch c.Type {
+    case NT_TEXT, NT_CDATA:
+      text += strings.TrimSpace(c.Value)
+    case NT_ELEMENT:
+      key := qualifiedKey(c.Name.Space, c.Name.Local)
+      if counts[key]++; counts[key] > 1 {
+        s.Arrays[joinPath(path, key)] = true
+      }
+      rec_InferJSON(c, joinPath(path, key), s)
+    }
+  }
+  s.observe(path, text)
+}
+
+// observe narrows the type at path to fit val.
+func (this *JSONTypes) observe(path, val string) {
+  if val == "" {
+    return
+  }
+  typ, seen := this.Types[path]
+  switch {
+  case (!seen || typ == "number") && jsonNumber.MatchString(val):
+    this.Types[path] = "number"
+  case (!seen || typ == "boolean") && (val == "true" || val == "false"):
+    this.Types[path] = "boolean"
+  default:
+    this.Types[path] = "string"
+  }
+}
+
+// typed converts val to the type given for path. Empty numbers and booleans
+// become nil, written as null; other values that do not fit the type are
+// left as strings.
+func (this *JSONTypes) typed(path, val string) interface{} {
+  if this == nil {
+    return val
+  }
+  switch this.Types[path] {
+  case "number":
+    if val == "" {
+      return nil
+    }
+    if jsonNumber.MatchString(val) {
+      return json.Number(val)
+    }
+  case "boolean":
+    switch val {
+    case "":
+      return nil
+    case "true", "1":
+      return true
+    case "false", "0":
+      return false
+    }
+  }
+  return val
+}
+
+// TypedJSONValue is like JSONValue, but shapes and types the result as
+// given by t.
+func (this *Node) TypedJSONValue(t *JSONTypes) interface{} {
+  var names *JSONNames
+  if t != nil {
+    names = t.Names
+  }
+  return this.jsonValue(nil, t, names, "")
+}
+
+// ToTypedJSON returns the JSON encoding of TypedJSONValue, without HTML
+// escaping.
+func (this *Node) ToTypedJSON(t *JSONTypes) ([]byte, error) {
+  return encodeJSON(this.TypedJSONValue(t))
+}
+
+func joinPath(path, key string) string {
+  if path == "" {
+    return key
+  }
+  return path + "/" + key
+}
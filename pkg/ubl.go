@@ -0,0 +1,243 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// Namespaces of UBL 2.1 invoices and their common components.
+const (
+  UBLInvoiceNS = "urn:oasis:names:specification:ubl:schema:xsd:Invoice-2"
+  UBLCacNS     = "urn:oasis:names:specification:ubl:schema:xsd:CommonAggregateComponents-2"
+  UBLCbcNS     = "urn:oasis:names:specification:ubl:schema:xsd:CommonBasicComponents-2"
+)
+
+// UBLInvoice holds the commonly needed parts of a UBL 2.1 invoice. Values
+// absent from the invoice are left zero.
+type UBLInvoice struct {
+  ID        string
+  IssueDate time.Time
+  DueDate   time.Time
+  Currency  string // DocumentCurrencyCode
+  Supplier  UBLParty
+  Customer  UBLParty
+  Lines     []UBLInvoiceLine
+  TaxAmount UBLAmount // Total tax amount, from the first TaxTotal.
+  Subtotals []UBLTaxSubtotal
+  Totals    UBLMonetaryTotal
+  Node      *Node // The Invoice element this was parsed from.
+}
+
+// UBLAmount is a monetary amount along with its currencyID.
+type UBLAmount struct {
+  Value    float64
+  Currency string
+}
+
+// UBLParty is the supplier or customer of an invoice.
+type UBLParty struct {
+  Name       string // PartyName, or the registration name if there is none.
+  EndpointID string
+  CompanyID  string // Legal registration number.
+  TaxID      string // Tax scheme (e.g. VAT) registration number.
+  Street     string
+  City       string
+  PostalZone string
+  Country    string // Country identification code.
+}
+
+// UBLInvoiceLine is a single line of an invoice.
+type UBLInvoiceLine struct {
+  ID          string
+  Quantity    float64
+  UnitCode    string
+  Amount      UBLAmount // LineExtensionAmount
+  Price       UBLAmount
+  ItemName    string
+  Description string
+  TaxCategory string
+  TaxPercent  float64
+}
+
+// UBLTaxSubtotal is the tax due for one tax category.
+type UBLTaxSubtotal struct {
+  TaxableAmount UBLAmount
+  TaxAmount     UBLAmount
+  Category      string
+  Percent       float64
+}
+
+// UBLMonetaryTotal holds the LegalMonetaryTotal of an invoice.
+type UBLMonetaryTotal struct {
+  LineExtension  UBLAmount
+  TaxExclusive   UBLAmount
+  TaxInclusive   UBLAmount
+  AllowanceTotal UBLAmount
+  ChargeTotal    UBLAmount
+  Prepaid        UBLAmount
+  Payable        UBLAmount
+}
+
+// UBLInvoice parses the document element of the document as a UBL invoice.
+func (this *Document) UBLInvoice() (*UBLInvoice, error) {
+  root := this.documentElement()
+  if root == nil {
+    return nil, fmt.Errorf("xmlx: document has no root element")
+  }
+  return ParseUBLInvoice(root)
+}
+
+// ParseUBLInvoice parses the given Invoice element.
+func ParseUBLInvoice(n *Node) (*UBLInvoice, error) {
+  if !n.IsElement() || !n.matches(UBLInvoiceNS, "Invoice") {
+    return nil, fmt.Errorf("xmlx: %s is not a UBL invoice", n.QualifiedName())
+  }
+
+  u := &ublParser{}
+  inv := &UBLInvoice{
+    ID:        ublText(n, "cbc:ID"),
+    IssueDate: u.date(n, "cbc:IssueDate"),
+    DueDate:   u.date(n, "cbc:DueDate"),
+    Currency:  ublText(n, "cbc:DocumentCurrencyCode"),
+    Supplier:  u.party(ublNode(n, "cac:AccountingSupplierParty", "cac:Party")),
+    Customer:  u.party(ublNode(n, "cac:AccountingCustomerParty", "cac:Party")),
+    Node:      n,
+  }
+
+  for _, v := range n.SelectNodes(UBLCacNS, "InvoiceLine") {
+    inv.Lines = append(inv.Lines, UBLInvoiceLine{
+      ID:          ublText(v, "cbc:ID"),
+      Quantity:    u.number(v, "cbc:InvoicedQuantity"),
+      UnitCode:    ublAttr(v, "unitCode", "cbc:InvoicedQuantity"),
+      Amount:      u.amount(v, "cbc:LineExtensionAmount"),
+      Price:       u.amount(v, "cac:Price", "cbc:PriceAmount"),
+      ItemName:    ublText(v, "cac:Item", "cbc:Name"),
+      Description: ublText(v, "cac:Item", "cbc:Description"),
+      TaxCategory: ublText(v, "cac:Item", "cac:ClassifiedTaxCategory", "cbc:ID"),
+      TaxPercent:  u.number(v, "cac:Item", "cac:ClassifiedTaxCategory", "cbc:Percent"),
+    })
+  }
+
+  if tt := ublNode(n, "cac:TaxTotal"); tt != nil {
+    inv.TaxAmount = u.amount(tt, "cbc:TaxAmount")
+    for _, v := range tt.SelectNodes(UBLCacNS, "TaxSubtotal") {
+      inv.Subtotals = append(inv.Subtotals, UBLTaxSubtotal{
+        TaxableAmount: u.amount(v, "cbc:TaxableAmount"),
+        TaxAmount:     u.amount(v, "cbc:TaxAmount"),
+        Category:      ublText(v, "cac:TaxCategory", "cbc:ID"),
+        Percent:       u.number(v, "cac:TaxCategory", "cbc:Percent"),
+      })
+    }
+  }
+
+  if mt := ublNode(n, "cac:LegalMonetaryTotal"); mt != nil {
+    inv.Totals = UBLMonetaryTotal{
+      LineExtension:  u.amount(mt, "cbc:LineExtensionAmount"),
+      TaxExclusive:   u.amount(mt, "cbc:TaxExclusiveAmount"),
+      TaxInclusive:   u.amount(mt, "cbc:TaxInclusiveAmount"),
+      AllowanceTotal: u.amount(mt, "cbc:AllowanceTotalAmount"),
+      ChargeTotal:    u.amount(mt, "cbc:ChargeTotalAmount"),
+      Prepaid:        u.amount(mt, "cbc:PrepaidAmount"),
+      Payable:        u.amount(mt, "cbc:PayableAmount"),
+    }
+  }
+
+  if u.err != nil {
+    return nil, u.err
+  }
+  return inv, nil
+}
+
+// ublParser converts UBL values, keeping the first conversion error.
+type ublParser struct {
+  err error
+}
+
+func (this *ublParser) fail(n *Node, path []string, s string) {
+  if this.err == nil {
+    this.err = fmt.Errorf("xmlx: %s/%s: invalid value %q", n.QualifiedName(), strings.Join(path, "/"), s)
+  }
+}
+
+func (this *ublParser) number(n *Node, path ...string) float64 {
+  s := ublText(n, path...)
+  if s == "" {
+    return 0
+  }
+  f, err := strconv.ParseFloat(s, 64)
+  if err != nil {
+    this.fail(n, path, s)
+  }
+  return f
+}
+
+func (this *ublParser) amount(n *Node, path ...string) UBLAmount {
+  return UBLAmount{this.number(n, path...), ublAttr(n, "currencyID", path...)}
+}
+
+func (this *ublParser) date(n *Node, path ...string) time.Time {
+  s := ublText(n, path...)
+  if s == "" {
+    return time.Time{}
+  }
+  t, err := time.Parse("2006-01-02", s)
+  if err != nil {
+    this.fail(n, path, s)
+  }
+  return t
+}
+
+func (this *ublParser) party(n *Node) UBLParty {
+  if n == nil {
+    return UBLParty{}
+  }
+  p := UBLParty{
+    Name:       ublText(n, "cac:PartyName", "cbc:Name"),
+    EndpointID: ublText(n, "cbc:EndpointID"),
+    CompanyID:  ublText(n, "cac:PartyLegalEntity", "cbc:CompanyID"),
+    TaxID:      ublText(n, "cac:PartyTaxScheme", "cbc:CompanyID"),
+    Street:     ublText(n, "cac:PostalAddress", "cbc:StreetName"),
+    City:       ublText(n, "cac:PostalAddress", "cbc:CityName"),
+    PostalZone: ublText(n, "cac:PostalAddress", "cbc:PostalZone"),
+    Country:    ublText(n, "cac:PostalAddress", "cac:Country", "cbc:IdentificationCode"),
+  }
+  if p.Name == "" {
+    p.Name = ublText(n, "cac:PartyLegalEntity", "cbc:RegistrationName")
+  }
+  return p
+}
+
+// ublNode follows a path of cac: and cbc: prefixed child elements from n.
+func ublNode(n *Node, path ...string) *Node {
+  for _, s := range path {
+    if n == nil {
+      return nil
+    }
+    ns := UBLCbcNS
+    if strings.HasPrefix(s, "cac:") {
+      ns = UBLCacNS
+    }
+    n = childElement(n, ns, s[4:])
+  }
+  return n
+}
+
+func ublText(n *Node, path ...string) string {
+  if v := ublNode(n, path...); v != nil {
+    return v.GetValue()
+  }
+  return ""
+}
+
+func ublAttr(n *Node, attr string, path ...string) string {
+  if v := ublNode(n, path...); v != nil {
+    return v.As("", attr)
+  }
+  return ""
+}
@@ -0,0 +1,64 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+// The functions in this file take the Unicode normalization to apply as a
+// plain func(string) string, so callers can pick the form they need without
+// this package depending on a normalization library. With
+// golang.org/x/text/unicode/norm, pass norm.NFC.String or norm.NFKC.String.
+
+// normalizeText applies the Normalize function of the document, if set.
+func (this *Document) normalizeText(s string) string {
+  if this.Normalize == nil {
+    return s
+  }
+  return this.Normalize(s)
+}
+
+// NormalizeValues applies fn to every text, CDATA and attribute value in the
+// document, for example right before saving it.
+func (this *Document) NormalizeValues(fn func(string) string) {
+  if this.Root != nil {
+    rec_NormalizeValues(this.Root, fn)
+  }
+}
+
+func rec_NormalizeValues(cn *Node, fn func(string) string) {
+  if cn.IsText() {
+    cn.Value = fn(cn.Value)
+  }
+  for _, a := range cn.Attributes {
+    // Spilled values stay in their files unless fn changes them.
+    val := a.value()
+    if norm := fn(val); norm != val || a.spill == nil {
+      a.set(norm)
+    }
+  }
+  for _, v := range cn.Children {
+    rec_NormalizeValues(v, fn)
+  }
+}
+
+// Unnormalized returns the nodes holding content that fn would change, in
+// document order: text and CDATA nodes whose value is not normalized, and
+// elements with an attribute value that is not. It checks a document
+// against a normalization form without modifying it.
+func (this *Document) Unnormalized(fn func(string) string) []*Node {
+  list := make([]*Node, 0, 4)
+  if this.Root == nil {
+    return list
+  }
+  return this.Root.SelectNodesRecursiveFunc(func(n *Node) bool {
+    if n.IsText() {
+      return fn(n.Value) != n.Value
+    }
+    for _, a := range n.Attributes {
+      if val := a.value(); fn(val) != val {
+        return true
+      }
+    }
+    return false
+  })
+}
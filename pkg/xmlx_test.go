@@ -4,7 +4,19 @@
 
 package xmlx
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestLoadLocal(t *testing.T) {
 	doc := New()
@@ -74,6 +86,7 @@ func TestSave(t *testing.T) {
 	}
 
 	IndentPrefix = "\t"
+	defer func() { IndentPrefix = "" }()
 	if err := doc.SaveFile("test1.xml"); err != nil {
 		t.Errorf("SaveFile(): %s", err)
 		return
@@ -257,3 +270,3467 @@ func TestElementNodeValueFetch(t *testing.T) {
 		t.Errorf("Failed to get availability using B, got: %v, wanted: true", v)
 	}
 }
+
+func TestResolvePointer(t *testing.T) {
+	data := `<book><chapter id="c1"><para>one</para></chapter><chapter xml:id="c2"><para>two</para><para>three</para></chapter></book>`
+	doc := New()
+
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	tests := map[string]string{
+		"#c1":                          "<chapter",
+		"c2":                           "<chapter",
+		"element(c2/2)":                "<para>three</para>",
+		"element(/1/1/1)":              "<para>one</para>",
+		"xpointer(id('c2'))":           "<chapter",
+		"xpointer(/book/chapter[2])":   "<chapter",
+		"element(nope)element(/1/2/1)": "<para>two</para>",
+	}
+
+	for ptr, want := range tests {
+		n, err := doc.ResolvePointer(ptr)
+		if err != nil {
+			t.Errorf("ResolvePointer(%q): %s", ptr, err)
+			continue
+		}
+		if got := n.String(); !strings.HasPrefix(got, want) {
+			t.Errorf("ResolvePointer(%q): got %q, wanted %q", ptr, got, want)
+		}
+	}
+
+	if _, err := doc.ResolvePointer("missing"); err == nil {
+		t.Errorf("ResolvePointer(): expected error for unknown id")
+	}
+}
+
+func TestStylesheets(t *testing.T) {
+	data := `<?xml version="1.0"?>
+<?xml-stylesheet href="style.xsl" type="text/xsl"?>
+<?xml-stylesheet type='text/css' media="print" alternate="yes" href='print.css'?>
+<?xml-stylesheet type="text/css"?>
+<doc/>`
+	doc := New()
+
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	list := doc.Stylesheets()
+	if len(list) != 2 {
+		t.Fatalf("Stylesheets(): Expected 2, Got %d", len(list))
+	}
+
+	if list[0].Href != "style.xsl" || list[0].Type != "text/xsl" {
+		t.Errorf("Stylesheets(): unexpected first entry %+v", list[0])
+	}
+	if list[1].Href != "print.css" || list[1].Media != "print" || !list[1].Alternate {
+		t.Errorf("Stylesheets(): unexpected second entry %+v", list[1])
+	}
+}
+
+func TestDuplicateAttrs(t *testing.T) {
+	data := `<root xmlns:a="urn:x" xmlns:b="urn:x"><item a:id="1" b:id="2" /></root>`
+	doc := New()
+
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	doc.DupAttrs = DUPATTR_WARN
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	dups := 0
+	for _, w := range doc.Warnings {
+		if strings.Contains(w.Error(), "duplicate attribute") {
+			dups++
+		}
+	}
+	if dups != 1 {
+		t.Errorf("Expected 1 duplicate attribute warning, got %d", dups)
+	}
+
+	doc.DupAttrs = DUPATTR_ERROR
+	if err := doc.LoadString(data, nil); err == nil {
+		t.Errorf("LoadString(): expected duplicate attribute error")
+	}
+}
+
+func TestStrictRoot(t *testing.T) {
+	tests := map[string]bool{
+		`<a/>`:              true,
+		"\n<a/>\n":          true,
+		`<a/><b/>`:          false,
+		`<a/>garbage`:       false,
+		`text<a/>`:          false,
+		`<!-- only -->`:     false,
+		`<a/><!-- ok --> `:  true,
+		`<?pi x?><a></a>  `: true,
+	}
+
+	for data, valid := range tests {
+		doc := New()
+		if err := doc.LoadString(data, nil); err != nil {
+			t.Errorf("LoadString(%q) non-strict: %s", data, err)
+		}
+
+		doc.StrictRoot = true
+		if err := doc.LoadString(data, nil); (err == nil) != valid {
+			t.Errorf("LoadString(%q) strict: got error %v, wanted valid=%v", data, err, valid)
+		}
+	}
+}
+
+func TestHTMLMode(t *testing.T) {
+	data := `<p>one<br>two &nbsp; <img src="x.png"></p>`
+	doc := New()
+
+	if err := doc.LoadString(data, nil); err == nil {
+		t.Fatalf("LoadString(): expected error in strict mode")
+	}
+
+	doc.UseHTMLMode()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	if n := doc.SelectNode("", "br"); n == nil || len(n.Children) != 0 {
+		t.Errorf("SelectNode(): expected empty <br> element")
+	}
+	if n := doc.SelectNode("", "img"); n == nil || n.As("", "src") != "x.png" {
+		t.Errorf("SelectNode(): expected <img> with src attribute")
+	}
+}
+
+func TestNodeTypes(t *testing.T) {
+	data := `<!DOCTYPE note SYSTEM "note.dtd"><note/>`
+	doc := New()
+
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	dt := doc.Root.Children[0]
+	if dt.Type != NT_DOCTYPE || dt.Type.String() != "NT_DOCTYPE" {
+		t.Errorf("Expected NT_DOCTYPE, got %s", dt.Type)
+	}
+
+	note := doc.SelectNode("", "note")
+	cdata := NewNode(NT_CDATA)
+	cdata.Value = "a]]>b"
+	note.AddChild(cdata)
+	ref := NewNode(NT_ENTITYREF)
+	ref.Value = "copy"
+	note.AddChild(ref)
+
+	expected := `<!DOCTYPE note SYSTEM "note.dtd"><note><![CDATA[a]]]]><![CDATA[>b]]>&copy;</note>`
+	if got := doc.Root.String(); got != expected {
+		t.Errorf("expected: %s\ngot: %s", expected, got)
+	}
+
+	if !note.IsElement() || !cdata.IsText() || !ref.Is(NT_COMMENT, NT_ENTITYREF) {
+		t.Errorf("Node type accessors returned unexpected results")
+	}
+}
+
+func TestIndentAndHints(t *testing.T) {
+	data := `<doc>
+  <a>text <b>bold</b> more</a>
+  <c><d/>   <e>x</e></c>
+  <pre><f><g/></f></pre>
+</doc>`
+	doc := New()
+
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	IndentPrefix = "  "
+	defer func() { IndentPrefix = "" }()
+
+	doc.SelectNode("", "pre").Hints = HINT_NOINDENT
+	doc.SelectNode("", "d").Hints = HINT_EXPANDED
+	doc.SelectNode("", "e").Children[0].Hints = HINT_CDATA
+
+	expected := `<doc>
+  <a>text <b>bold</b> more</a>
+  <c>
+    <d></d>
+    <e><![CDATA[x]]></e>
+  </c>
+  <pre><f><g /></f></pre>
+</doc>`
+	if got := doc.Root.String(); got != expected {
+		t.Errorf("expected: %s\ngot: %s", expected, got)
+	}
+}
+
+func TestAttrFunc(t *testing.T) {
+	doc := New()
+
+	if err := doc.LoadString(`<batch><rec/><rec/></batch>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	batch := doc.SelectNode("", "batch")
+	batch.SetAttrFunc("count", func(n *Node) string {
+		return strconv.Itoa(len(n.SelectNodes("", "rec")))
+	})
+	batch.OnSave = func(n *Node) { n.SetAttr("saved", "yes") }
+
+	if got := batch.String(); got != `<batch count="2" saved="yes"><rec /><rec /></batch>` {
+		t.Errorf("String(): got %s", got)
+	}
+
+	batch.AddChild(NewNode(NT_ELEMENT))
+	batch.Children[2].Name.Local = "rec"
+	if got := batch.As("", "count"); got != "2" {
+		t.Errorf("As(): expected stale value 2 before saving, got %s", got)
+	}
+	_ = batch.String()
+	if got := batch.As("", "count"); got != "3" {
+		t.Errorf("As(): expected 3 after saving, got %s", got)
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	doc := New()
+
+	if err := doc.LoadString(`<?pi x?><msg><body>1</body></msg>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	attr := &Attr{Name: xml.Name{Local: "v"}, Value: "2"}
+	doc.Envelope("", "envelope", attr)
+	if got := doc.Root.String(); got != `<?pi x?><envelope v="2"><msg><body>1</body></msg></envelope>` {
+		t.Errorf("Envelope(): got %s", got)
+	}
+
+	env, err := doc.Unenvelope()
+	if err != nil {
+		t.Fatalf("Unenvelope(): %s", err)
+	}
+	if env.Name.Local != "envelope" {
+		t.Errorf("Unenvelope(): returned <%s>", env.Name.Local)
+	}
+	if got := doc.Root.String(); got != `<?pi x?><msg><body>1</body></msg>` {
+		t.Errorf("Unenvelope(): got %s", got)
+	}
+
+	doc.Envelope("", "envelope")
+	doc.SelectNode("", "envelope").AddChild(NewNode(NT_ELEMENT))
+	if _, err := doc.Unenvelope(); err == nil {
+		t.Errorf("Unenvelope(): expected error for two child elements")
+	}
+
+	if err := doc.LoadString(`<msg><body>1</body></msg>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	doc.Envelope("urn:env", "envelope")
+	want := `<envelope xmlns="urn:env"><msg xmlns=""><body>1</body></msg></envelope>`
+	if got := doc.Root.String(); got != want {
+		t.Errorf("Envelope(urn:env): got %s", got)
+	}
+	if err := doc.LoadString(doc.Root.String(), nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	if uri := doc.SelectNode("*", "body").NamespaceURI(); uri != "" {
+		t.Errorf("Envelope(urn:env): <body> reloaded in namespace %q", uri)
+	}
+
+	decl := &Attr{Name: xml.Name{Space: "xmlns", Local: "e"}, Value: "urn:env"}
+	doc.Envelope("urn:env", "outer", decl)
+	if got := doc.Root.String(); !strings.HasPrefix(got, `<e:outer xmlns:e="urn:env"><envelope xmlns="urn:env">`) {
+		t.Errorf("Envelope(xmlns:e): got %s", got)
+	}
+}
+
+func TestSelectNodesDepth(t *testing.T) {
+	doc := New()
+
+	if err := doc.LoadFile("test.xml", nil); err != nil {
+		t.Fatalf("LoadFile(): %s", err)
+	}
+
+	ch := doc.SelectNode("", "channel")
+	if n := len(ch.SelectNodesDepth("", "link", 1)); n != 1 {
+		t.Errorf("SelectNodesDepth(1): Expected 1, Got %d", n)
+	}
+	if n := len(ch.SelectNodesDepth("", "link", 2)); n != 8 {
+		t.Errorf("SelectNodesDepth(2): Expected 8, Got %d", n)
+	}
+	if n := len(doc.SelectNodesDepth("", "title", 2)); n != 0 {
+		t.Errorf("SelectNodesDepth(2): Expected 0, Got %d", n)
+	}
+}
+
+func TestSortDocumentOrder(t *testing.T) {
+	doc := New()
+
+	if err := doc.LoadFile("test.xml", nil); err != nil {
+		t.Fatalf("LoadFile(): %s", err)
+	}
+
+	list := doc.SelectNodesRecursive("", "title")
+	list = append(list, doc.SelectNodesRecursive("", "link")...)
+	list = append(list, doc.SelectNode("", "channel"))
+	SortDocumentOrder(list)
+
+	want := []string{"channel", "title", "link", "title", "link", "title", "link"}
+	for i, name := range want {
+		if list[i].Name.Local != name {
+			t.Fatalf("SortDocumentOrder(): element %d is <%s>, wanted <%s>", i, list[i].Name.Local, name)
+		}
+	}
+}
+
+func TestNodeSetOperations(t *testing.T) {
+	doc := New()
+
+	if err := doc.LoadString(`<r><a id="1"/><b/><a id="2"/><b/></r>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	all := doc.SelectNode("", "r").SelectNodes("", "*")
+	as := doc.SelectNodesRecursive("", "a")
+	bs := doc.SelectNodesRecursive("", "b")
+
+	if u := Union(bs, as, bs); len(u) != 4 || u[0] != all[0] || u[3] != all[3] {
+		t.Errorf("Union(): unexpected result %v", u)
+	}
+	if i := Intersect(all, as); len(i) != 2 || i[0] != as[0] || i[1] != as[1] {
+		t.Errorf("Intersect(): unexpected result %v", i)
+	}
+	if e := Except(all, as); len(e) != 2 || e[0] != bs[0] || e[1] != bs[1] {
+		t.Errorf("Except(): unexpected result %v", e)
+	}
+}
+
+func TestValueAggregation(t *testing.T) {
+	doc := New()
+
+	if err := doc.LoadString(`<o><q>2</q><q> 3 </q><q>x</q><p>1.5</p><p>2.25</p></o>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	qs := doc.SelectNodesRecursive("", "q")
+	if got := JoinValues(qs, ","); got != "2,3,x" {
+		t.Errorf("JoinValues(): got %q", got)
+	}
+	if got := SumInt(qs); got != 5 {
+		t.Errorf("SumInt(): got %d, wanted 5", got)
+	}
+	if got := SumFloat(doc.SelectNodesRecursive("", "p")); got != 3.75 {
+		t.Errorf("SumFloat(): got %v, wanted 3.75", got)
+	}
+}
+
+func TestSaveLimits(t *testing.T) {
+	doc := New()
+
+	if err := doc.LoadString(`<a><b><c>some text</c></b></a>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	var buf bytes.Buffer
+	doc.MaxSaveDepth = 2
+	if err := doc.SaveStream(&buf); !errors.Is(err, ErrSaveLimit) {
+		t.Errorf("SaveStream(): expected depth limit error, got %v", err)
+	}
+
+	doc.MaxSaveDepth = 3
+	doc.MaxSaveSize = 40
+	if err := doc.SaveStream(&buf); !errors.Is(err, ErrSaveLimit) {
+		t.Errorf("SaveStream(): expected size limit error, got %v", err)
+	}
+	if doc.SaveBytes() != nil {
+		t.Errorf("SaveBytes(): expected nil when exceeding limits")
+	}
+	if b, err := doc.SaveBytesE(); b != nil || !errors.Is(err, ErrSaveLimit) {
+		t.Errorf("SaveBytesE(): expected size limit error, got %v", err)
+	}
+
+	doc.MaxSaveSize = 200
+	if err := doc.SaveStream(&buf); err != nil {
+		t.Errorf("SaveStream(): %s", err)
+	}
+	if b, err := doc.SaveBytesE(); err != nil || !bytes.Equal(b, doc.SaveBytes()) {
+		t.Errorf("SaveBytesE(): got %q, %v", b, err)
+	}
+}
+
+func TestDocumentPool(t *testing.T) {
+	var pool DocumentPool
+
+	entities := map[string]string{"shared": "yes"}
+	doc := pool.Get()
+	doc.Entity = entities
+	if err := doc.LoadString(`<a xmlns:x="urn:x"><x:b c="1">text</x:b></a>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	pool.Put(doc)
+	if entities["shared"] != "yes" {
+		t.Errorf("Put(): emptied the caller's Entity map")
+	}
+
+	doc = pool.Get()
+	if len(doc.Namespaces) != 0 || doc.Root != nil {
+		t.Fatalf("Get(): document was not reset")
+	}
+	if err := doc.LoadString(`<d><e f="2">more</e></d>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	if got := doc.Root.String(); got != `<d><e f="2">more</e></d>` {
+		t.Errorf("String(): got %s", got)
+	}
+	pool.Put(doc)
+}
+
+func TestTextCoalescing(t *testing.T) {
+	data := `<a>one<![CDATA[ two ]]>three</a>`
+	doc := New()
+
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	if n := len(doc.SelectNode("", "a").Children); n != 3 {
+		t.Errorf("Expected 3 text nodes, got %d", n)
+	}
+
+	doc.CoalesceText = true
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	a := doc.SelectNode("", "a")
+	if len(a.Children) != 1 || a.Children[0].Value != "one two three" {
+		t.Errorf("Expected a single coalesced text node, got %d children", len(a.Children))
+	}
+
+	doc.MaxTextSize = 8
+	if err := doc.LoadString(data, nil); err == nil {
+		t.Errorf("LoadString(): expected text size error")
+	}
+}
+
+func TestQualifiedNames(t *testing.T) {
+	data := `<env xmlns="urn:env" xmlns:soap="urn:soap"><soap:Body><item/></soap:Body></env>`
+	doc := New()
+
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	body := doc.SelectNode("soap", "Body")
+	if got := body.QualifiedName(); got != "soap:Body" {
+		t.Errorf("QualifiedName(): got %q, wanted soap:Body", got)
+	}
+
+	item := NewNode(NT_ELEMENT)
+	item.Name = xml.Name{Space: "urn:env", Local: "item"}
+	body.AddChild(item)
+	if got := item.QualifiedName(); got != "item" {
+		t.Errorf("QualifiedName(): got %q, wanted item", got)
+	}
+
+	name, err := ParseQName("soap:Body", body.NamespaceContext())
+	if err != nil || name.Space != "urn:soap" || name.Local != "Body" {
+		t.Errorf("ParseQName(): got %v, %v", name, err)
+	}
+	if name, _ = ParseQName("Body", body.NamespaceContext()); name.Space != "urn:env" {
+		t.Errorf("ParseQName(): expected default namespace, got %q", name.Space)
+	}
+	if _, err = ParseQName("wsse:Security", body.NamespaceContext()); err == nil {
+		t.Errorf("ParseQName(): expected error for unbound prefix")
+	}
+	if name, _ = ParseQName("soap:Body", nil); name.Space != "soap" {
+		t.Errorf("ParseQName(): expected prefix as space, got %q", name.Space)
+	}
+}
+
+func TestNamespaceURIAndPrefix(t *testing.T) {
+	data := `<a:root xmlns:a="urn:a" xmlns="urn:default"><item/><a:item/></a:root>`
+
+	for _, uriSpaces := range []bool{false, true} {
+		doc := New()
+		doc.URISpaces = uriSpaces
+		if err := doc.LoadString(data, nil); err != nil {
+			t.Fatalf("LoadString(): %s", err)
+		}
+
+		root := doc.SelectNode("urn:a", "root")
+		if root == nil || root != doc.SelectNode("a", "root") {
+			t.Fatalf("SelectNode(): root not found by both URI and prefix (URISpaces=%v)", uriSpaces)
+		}
+		if root.NamespaceURI() != "urn:a" || root.Prefix() != "a" {
+			t.Errorf("Got URI %q and prefix %q for root", root.NamespaceURI(), root.Prefix())
+		}
+
+		if n := len(doc.SelectNodesRecursive("urn:a", "item")); n != 1 {
+			t.Errorf("SelectNodesRecursive(urn:a): Expected 1, Got %d", n)
+		}
+		if n := len(doc.SelectNodesRecursive("urn:default", "item")); n != 1 {
+			t.Errorf("SelectNodesRecursive(urn:default): Expected 1, Got %d", n)
+		}
+		if n := len(doc.SelectNodesRecursive("", "item")); n != 1 {
+			t.Errorf("SelectNodesRecursive(\"\"): Expected 1, Got %d", n)
+		}
+
+		expected := `<a:root xmlns:a="urn:a" xmlns="urn:default"><item /><a:item /></a:root>`
+		if got := doc.Root.String(); got != expected {
+			t.Errorf("String(): got %s", got)
+		}
+	}
+}
+
+func TestNamespaceDiagnostics(t *testing.T) {
+	data := `<r xmlns:a="urn:one" xmlns:b="urn:one"><x xmlns:a="urn:two"/></r>`
+	doc := New()
+
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	if len(doc.Warnings) != 2 {
+		t.Errorf("Expected 2 warnings, got %d: %v", len(doc.Warnings), doc.Warnings)
+	}
+
+	list := doc.NamespaceBindings()
+	if len(list) != 3 {
+		t.Fatalf("NamespaceBindings(): Expected 3, Got %d", len(list))
+	}
+	if list[2].Prefix != "a" || list[2].URI != "urn:two" || list[2].Node.Name.Local != "x" {
+		t.Errorf("NamespaceBindings(): unexpected binding %+v", list[2])
+	}
+}
+
+func TestAttrsInNamespace(t *testing.T) {
+	data := `<r xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="T" id="1" xsi:nil="false"/>`
+	doc := New()
+
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	r := doc.SelectNode("", "r")
+	for _, ns := range []string{"xsi", "http://www.w3.org/2001/XMLSchema-instance"} {
+		if n := len(r.AttrsInNamespace(ns)); n != 2 {
+			t.Errorf("AttrsInNamespace(%q): Expected 2, Got %d", ns, n)
+		}
+	}
+
+	xsi := r.AttrsInNamespace("xsi")
+	r.EachAttr(func(a *Attr) {
+		for _, x := range xsi {
+			if a == x {
+				r.RemoveAttr(a.Name.Local)
+			}
+		}
+	})
+	if got := r.String(); got != `<r xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" id="1" />` {
+		t.Errorf("String(): got %s", got)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	data := `<order id="42"><customer>ACME</customer><line qty="2"/><line qty="x"/><paid>true</paid></order>`
+	doc := New()
+
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	res, err := doc.Extract(Mapping{
+		"id":       {Path: "order/@id", Type: FIELD_INT, Required: true},
+		"customer": {Path: "order/customer"},
+		"paid":     {Path: "order/paid", Type: FIELD_BOOL},
+		"qty":      {Path: "order/line/@qty", Type: FIELD_INT, Multiple: true},
+		"note":     {Path: "order/note"},
+		"total":    {Path: "order/total", Required: true},
+	})
+
+	if err == nil || !strings.Contains(err.Error(), "total") || !strings.Contains(err.Error(), "qty") {
+		t.Errorf("Extract(): expected errors for total and qty, got %v", err)
+	}
+	if res["id"] != int64(42) || res["customer"] != "ACME" || res["paid"] != true {
+		t.Errorf("Extract(): unexpected result %v", res)
+	}
+	if q, ok := res["qty"].([]interface{}); !ok || len(q) != 1 || q[0] != int64(2) {
+		t.Errorf("Extract(): unexpected qty %v", res["qty"])
+	}
+	if _, ok := res["note"]; ok {
+		t.Errorf("Extract(): optional missing field should be left out")
+	}
+}
+
+func TestRouter(t *testing.T) {
+	var r Router
+	var got string
+
+	r.Handle(All(RootIs("*", "order"), MustValueIs("order/@type", "rush")), func(doc *Document) error {
+		got = "rush"
+		return nil
+	})
+	r.Handle(RootIs("*", "order"), func(doc *Document) error {
+		got = "order"
+		return nil
+	})
+	r.Handle(MustHasPath("invoice/lines/line"), func(doc *Document) error {
+		got = "invoice"
+		return nil
+	})
+
+	tests := map[string]string{
+		`<order type="rush"/>`:                      "rush",
+		`<order type="normal"/>`:                    "order",
+		`<invoice><lines><line/></lines></invoice>`: "invoice",
+	}
+
+	for data, want := range tests {
+		doc := New()
+		if err := doc.LoadString(data, nil); err != nil {
+			t.Fatalf("LoadString(): %s", err)
+		}
+		got = ""
+		if err := r.Dispatch(doc); err != nil || got != want {
+			t.Errorf("Dispatch(%s): got %q (%v), wanted %q", data, got, err, want)
+		}
+	}
+
+	doc := New()
+	doc.LoadString(`<invoice/>`, nil)
+	if err := r.Dispatch(doc); err != ErrNoRoute {
+		t.Errorf("Dispatch(): expected ErrNoRoute, got %v", err)
+	}
+
+	if _, err := HasPath("invoice//line"); err == nil {
+		t.Errorf("HasPath(): expected error for malformed path")
+	}
+	if _, err := ValueIs("", "x"); err == nil {
+		t.Errorf("ValueIs(): expected error for empty path")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("MustHasPath(): expected panic for malformed path")
+		}
+	}()
+	MustHasPath("invoice//line")
+}
+
+func TestStreamToNDJSON(t *testing.T) {
+	data := `<feed xmlns:x="urn:x">
+  <title>ignored</title>
+  <entry id="1"><x:name>one</x:name></entry>
+  <other><entry id="nested"/></other>
+  <entry id="2"><x:name>two</x:name><tag>a</tag><tag>b</tag></entry>
+</feed>`
+
+	var b bytes.Buffer
+	if err := StreamToNDJSON(strings.NewReader(data), "feed/entry", &b); err != nil {
+		t.Fatalf("StreamToNDJSON(): %s", err)
+	}
+
+	want := `{"@id":"1","x:name":"one"}
+{"@id":"2","tag":["a","b"],"x:name":"two"}
+`
+	if b.String() != want {
+		t.Errorf("StreamToNDJSON(): got\n%s\nwanted\n%s", b.String(), want)
+	}
+
+	sr, err := NewSubtreeReader(strings.NewReader(data), "feed/entry")
+	if err != nil {
+		t.Fatalf("NewSubtreeReader(): %s", err)
+	}
+	n, err := sr.Next()
+	if err != nil {
+		t.Fatalf("Next(): %s", err)
+	}
+	if n.Parent != nil || n.NamespaceContext()["x"] != "urn:x" {
+		t.Errorf("Next(): subtree not detached with its namespaces")
+	}
+
+	if err := StreamToNDJSON(strings.NewReader(`<feed><entry>`), "feed/entry", &b); err == nil {
+		t.Errorf("StreamToNDJSON(): expected error on truncated input")
+	}
+}
+
+func TestBatchReader(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("<rows>")
+	for i := 0; i < 10; i++ {
+		b.WriteString(`<row n="` + strconv.Itoa(i) + `"/>`)
+	}
+	b.WriteString("</rows>")
+
+	sr, err := NewSubtreeReader(strings.NewReader(b.String()), "rows/row")
+	if err != nil {
+		t.Fatalf("NewSubtreeReader(): %s", err)
+	}
+
+	br := NewBatchReader(sr, 4)
+	sizes := []int{}
+	next := 0
+	for batch := range br.Batches(nil, 1) {
+		sizes = append(sizes, len(batch))
+		for _, n := range batch {
+			if n.As("", "n") != strconv.Itoa(next) {
+				t.Errorf("Batches(): got row %s, wanted %d", n.As("", "n"), next)
+			}
+			next++
+		}
+	}
+	if err := br.Err(); err != nil {
+		t.Errorf("Err(): %s", err)
+	}
+	if len(sizes) != 3 || sizes[0] != 4 || sizes[2] != 2 {
+		t.Errorf("Batches(): got batch sizes %v, wanted [4 4 2]", sizes)
+	}
+
+	sr, _ = NewSubtreeReader(strings.NewReader("<rows><row/><row>"), "rows/row")
+	br = NewBatchReader(sr, 4)
+	for range br.Batches(nil, 1) {
+	}
+	if br.Err() == nil {
+		t.Errorf("Err(): expected error on truncated input")
+	}
+}
+
+func TestSAMLAssertions(t *testing.T) {
+	data := `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol">
+  <saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="a1" IssueInstant="2024-05-01T10:00:00Z">
+    <saml:Issuer>https://idp.example.com</saml:Issuer>
+    <ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#"/>
+    <saml:Subject>
+      <saml:NameID Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress">jo@example.com</saml:NameID>
+      <saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">
+        <saml:SubjectConfirmationData Recipient="https://sp.example.com/acs" NotOnOrAfter="2024-05-01T10:05:00Z"/>
+      </saml:SubjectConfirmation>
+    </saml:Subject>
+    <saml:Conditions NotBefore="2024-05-01T09:59:00Z" NotOnOrAfter="2024-05-01T10:05:00Z">
+      <saml:AudienceRestriction><saml:Audience>https://sp.example.com</saml:Audience></saml:AudienceRestriction>
+    </saml:Conditions>
+    <saml:AttributeStatement>
+      <saml:Attribute Name="urn:oid:0.9.2342.19200300.100.1.3" FriendlyName="mail">
+        <saml:AttributeValue>jo@example.com</saml:AttributeValue>
+      </saml:Attribute>
+      <saml:Attribute Name="groups">
+        <saml:AttributeValue>admin</saml:AttributeValue>
+        <saml:AttributeValue>dev</saml:AttributeValue>
+      </saml:Attribute>
+    </saml:AttributeStatement>
+  </saml:Assertion>
+</samlp:Response>`
+
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	list, err := doc.SAMLAssertions()
+	if err != nil {
+		t.Fatalf("SAMLAssertions(): %s", err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("SAMLAssertions(): got %d assertions, wanted 1", len(list))
+	}
+
+	a := list[0]
+	if a.ID != "a1" || a.Issuer != "https://idp.example.com" || !a.Signed {
+		t.Errorf("SAMLAssertions(): bad header %+v", a)
+	}
+	if a.Subject.NameID != "jo@example.com" || a.Subject.Recipient != "https://sp.example.com/acs" {
+		t.Errorf("SAMLAssertions(): bad subject %+v", a.Subject)
+	}
+	if len(a.Conditions.Audiences) != 1 || a.Conditions.Audiences[0] != "https://sp.example.com" {
+		t.Errorf("SAMLAssertions(): bad audiences %v", a.Conditions.Audiences)
+	}
+	if v := a.Attribute("mail"); len(v) != 1 || v[0] != "jo@example.com" {
+		t.Errorf("Attribute(mail): got %v", v)
+	}
+	if v := a.Attribute("groups"); len(v) != 2 || v[1] != "dev" {
+		t.Errorf("Attribute(groups): got %v", v)
+	}
+	if !a.ValidAt(a.IssueInstant) || a.ValidAt(a.Conditions.NotOnOrAfter) {
+		t.Errorf("ValidAt(): wrong validity window")
+	}
+}
+
+func TestUBLInvoice(t *testing.T) {
+	data := `<Invoice xmlns="urn:oasis:names:specification:ubl:schema:xsd:Invoice-2"
+  xmlns:cac="urn:oasis:names:specification:ubl:schema:xsd:CommonAggregateComponents-2"
+  xmlns:cbc="urn:oasis:names:specification:ubl:schema:xsd:CommonBasicComponents-2">
+  <cbc:ID>INV-1</cbc:ID>
+  <cbc:IssueDate>2024-03-01</cbc:IssueDate>
+  <cbc:DocumentCurrencyCode>EUR</cbc:DocumentCurrencyCode>
+  <cac:AccountingSupplierParty><cac:Party>
+    <cac:PartyName><cbc:Name>Seller</cbc:Name></cac:PartyName>
+    <cac:PostalAddress><cbc:CityName>Berlin</cbc:CityName><cac:Country><cbc:IdentificationCode>DE</cbc:IdentificationCode></cac:Country></cac:PostalAddress>
+    <cac:PartyTaxScheme><cbc:CompanyID>DE123</cbc:CompanyID></cac:PartyTaxScheme>
+  </cac:Party></cac:AccountingSupplierParty>
+  <cac:AccountingCustomerParty><cac:Party>
+    <cac:PartyLegalEntity><cbc:RegistrationName>Buyer Ltd</cbc:RegistrationName></cac:PartyLegalEntity>
+  </cac:Party></cac:AccountingCustomerParty>
+  <cac:TaxTotal>
+    <cbc:TaxAmount currencyID="EUR">19.00</cbc:TaxAmount>
+    <cac:TaxSubtotal>
+      <cbc:TaxableAmount currencyID="EUR">100.00</cbc:TaxableAmount>
+      <cbc:TaxAmount currencyID="EUR">19.00</cbc:TaxAmount>
+      <cac:TaxCategory><cbc:ID>S</cbc:ID><cbc:Percent>19</cbc:Percent></cac:TaxCategory>
+    </cac:TaxSubtotal>
+  </cac:TaxTotal>
+  <cac:LegalMonetaryTotal>
+    <cbc:TaxExclusiveAmount currencyID="EUR">100.00</cbc:TaxExclusiveAmount>
+    <cbc:PayableAmount currencyID="EUR">119.00</cbc:PayableAmount>
+  </cac:LegalMonetaryTotal>
+  <cac:InvoiceLine>
+    <cbc:ID>1</cbc:ID>
+    <cbc:InvoicedQuantity unitCode="C62">4</cbc:InvoicedQuantity>
+    <cbc:LineExtensionAmount currencyID="EUR">100.00</cbc:LineExtensionAmount>
+    <cac:Item><cbc:Name>Widget</cbc:Name></cac:Item>
+    <cac:Price><cbc:PriceAmount currencyID="EUR">25.00</cbc:PriceAmount></cac:Price>
+  </cac:InvoiceLine>
+</Invoice>`
+
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	inv, err := doc.UBLInvoice()
+	if err != nil {
+		t.Fatalf("UBLInvoice(): %s", err)
+	}
+	if inv.ID != "INV-1" || inv.IssueDate.Month() != 3 || inv.Currency != "EUR" {
+		t.Errorf("UBLInvoice(): bad header %+v", inv)
+	}
+	if inv.Supplier.Name != "Seller" || inv.Supplier.Country != "DE" || inv.Supplier.TaxID != "DE123" {
+		t.Errorf("UBLInvoice(): bad supplier %+v", inv.Supplier)
+	}
+	if inv.Customer.Name != "Buyer Ltd" {
+		t.Errorf("UBLInvoice(): bad customer %+v", inv.Customer)
+	}
+	if len(inv.Lines) != 1 || inv.Lines[0].Quantity != 4 || inv.Lines[0].UnitCode != "C62" || inv.Lines[0].Price.Value != 25 {
+		t.Errorf("UBLInvoice(): bad lines %+v", inv.Lines)
+	}
+	if len(inv.Subtotals) != 1 || inv.Subtotals[0].Percent != 19 || inv.TaxAmount.Value != 19 {
+		t.Errorf("UBLInvoice(): bad tax %+v %+v", inv.TaxAmount, inv.Subtotals)
+	}
+	if inv.Totals.Payable != (UBLAmount{119, "EUR"}) {
+		t.Errorf("UBLInvoice(): bad totals %+v", inv.Totals)
+	}
+
+	doc.LoadString(strings.Replace(data, "2024-03-01", "yesterday", 1), nil)
+	if _, err := doc.UBLInvoice(); err == nil {
+		t.Errorf("UBLInvoice(): expected error for invalid date")
+	}
+}
+
+func TestGeoData(t *testing.T) {
+	data := `<gpx xmlns="http://www.topografix.com/GPX/1/1" version="1.1" creator="test">
+  <wpt lat="52.5" lon="13.4"><name>Start</name></wpt>
+  <trk><name>Run</name><trkseg>
+    <trkpt lat="52.51" lon="13.41"><ele>34.5</ele><time>2024-06-01T07:00:00Z</time></trkpt>
+    <trkpt lat="52.52" lon="13.42"/>
+  </trkseg></trk>
+</gpx>`
+
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	g, err := doc.GPX()
+	if err != nil {
+		t.Fatalf("GPX(): %s", err)
+	}
+	if len(g.Waypoints) != 1 || g.Waypoints[0].Name != "Start" || g.Waypoints[0].Lat != 52.5 {
+		t.Errorf("GPX(): bad waypoints %+v", g.Waypoints)
+	}
+	if len(g.Tracks) != 1 || len(g.Tracks[0].Segments[0]) != 2 {
+		t.Fatalf("GPX(): bad tracks %+v", g.Tracks)
+	}
+	p := g.Tracks[0].Segments[0][0]
+	if p.Ele != 34.5 || p.Time.Hour() != 7 {
+		t.Errorf("GPX(): bad track point %+v", p)
+	}
+
+	again := New()
+	if err := again.LoadString(g.Document().SaveString(), nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	if g2, err := again.GPX(); err != nil || g2.Tracks[0].Segments[0][0] != p || g2.Creator != "test" {
+		t.Errorf("GPX(): round trip failed: %v", err)
+	}
+
+	kml := KMLDocument([]KMLPlacemark{
+		{Name: "Here", Points: []GeoPoint{{Lat: 1.5, Lon: 2.5}}},
+		{Name: "Path", Points: []GeoPoint{{Lat: 1, Lon: 2}, {Lat: 3, Lon: 4, Ele: 5}}},
+	})
+	if err := doc.LoadString(kml.SaveString(), nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	list, err := doc.KMLPlacemarks()
+	if err != nil {
+		t.Fatalf("KMLPlacemarks(): %s", err)
+	}
+	if len(list) != 2 || list[0].Points[0].Lon != 2.5 || list[1].Points[1].Ele != 5 {
+		t.Errorf("KMLPlacemarks(): got %+v", list)
+	}
+}
+
+func TestInlineElements(t *testing.T) {
+	data := `<article><para><emphasis>one</emphasis> <link><b>two</b></link></para>` +
+		`<section><title>T</title></section><para>x <emphasis><b>y</b></emphasis></para></article>`
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	IndentPrefix = "  "
+	defer func() { IndentPrefix = "" }()
+	doc.SaveDocType = false
+	doc.Inline = []string{"emphasis", "link"}
+
+	expected := `<article>
+  <para><emphasis>one</emphasis> <link><b>two</b></link></para>
+  <section>
+    <title>T</title>
+  </section>
+  <para>x <emphasis><b>y</b></emphasis></para>
+</article>`
+	if got := doc.SaveString(); got != expected {
+		t.Errorf("expected: %s\ngot: %s", expected, got)
+	}
+}
+
+func TestConrefResolver(t *testing.T) {
+	data := `<topic id="t1">
+  <warn id="w" audience="all"><p>Hot!</p></warn>
+  <body>
+    <warn conref="#w"/>
+    <note conref="lib.dita#lib/n1"/>
+  </body>
+</topic>`
+	lib := `<topic id="lib"><note id="n1"><p>Shared</p><warn conref="#t1/w"/></note></topic>`
+
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	var loads int
+	r := &ConrefResolver{Load: func(uri string) (*Document, error) {
+		loads++
+		if uri != "lib.dita" {
+			return nil, errors.New("not found")
+		}
+		d := New()
+		return d, d.LoadString(strings.Replace(lib, "#t1/w", "#lib", 1), nil)
+	}}
+	if err := r.Resolve(doc); err == nil {
+		t.Errorf("Resolve(): expected cycle error")
+	}
+
+	lib = `<topic id="lib"><note id="n1"><p>Shared</p></note></topic>`
+	doc.LoadString(data, nil)
+	if err := r.Resolve(doc); err != nil {
+		t.Fatalf("Resolve(): %s", err)
+	}
+
+	body := doc.SelectNode("", "body")
+	expected := `<body>
+    <warn audience="all"><p>Hot!</p></warn>
+    <note><p>Shared</p></note>
+  </body>`
+	if got := body.String(); got != expected {
+		t.Errorf("Resolve(): expected %s\ngot: %s", expected, got)
+	}
+	if loads != 2 {
+		t.Errorf("Resolve(): loaded external document %d times, wanted once per run", loads)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	doc := New()
+	if err := doc.Validate(); err == nil {
+		t.Errorf("Validate(): expected error for empty document")
+	}
+	if err := doc.SaveStream(ioutil.Discard); err != nil {
+		t.Errorf("SaveStream(): %s for empty document", err)
+	}
+
+	if err := doc.LoadString(`<a/>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	if err := doc.Validate(); err != nil {
+		t.Errorf("Validate(): %s", err)
+	}
+
+	b := NewNode(NT_ELEMENT)
+	b.Name.Local = "b"
+	doc.Root.AddChild(b)
+	if err := doc.Validate(); err == nil {
+		t.Errorf("Validate(): expected error for multiple root elements")
+	}
+	var buf bytes.Buffer
+	if err := doc.SaveStream(&buf); err != nil || !strings.HasSuffix(buf.String(), `<a /><b />`) {
+		t.Errorf("SaveStream(): got %q, %v for multiple root elements", buf.String(), err)
+	}
+	if got := fmt.Sprintf("%s", doc); !strings.HasSuffix(got, `<a /><b />`) {
+		t.Errorf("String(): got %q for multiple root elements", got)
+	}
+	if got := doc.SaveBytes(); !bytes.HasSuffix(got, []byte(`<a /><b />`)) {
+		t.Errorf("SaveBytes(): got %q for multiple root elements", got)
+	}
+
+	doc.ValidateSave = true
+	buf.Reset()
+	if err := doc.SaveStream(&buf); err == nil {
+		t.Errorf("SaveStream(): expected error for multiple root elements")
+	}
+	if _, err := doc.SaveBytesE(); err == nil {
+		t.Errorf("SaveBytesE(): expected error for multiple root elements")
+	}
+	if got := doc.SaveBytes(); got != nil {
+		t.Errorf("SaveBytes(): got %q, wanted nil for multiple root elements", got)
+	}
+	if got := doc.SaveString(); got != "" {
+		t.Errorf("SaveString(): got %q, wanted \"\" for multiple root elements", got)
+	}
+
+	doc.Fragment = true
+	doc.SaveDocType = false
+	if got := doc.SaveString(); got != `<a /><b />` {
+		t.Errorf("SaveString(): got %q in fragment mode", got)
+	}
+}
+
+func TestSelectNodeByPath(t *testing.T) {
+	data := `<s:order xmlns:s="urn:shop">
+  <s:items><s:item id="1"/><s:item id="2"/><other><s:item id="x"/></other></s:items>
+  <s:items><s:item id="3"/></s:items>
+</s:order>`
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	if n := doc.SelectNodeByPath("s:order/s:items/s:item"); n == nil || n.As("", "id") != "1" {
+		t.Errorf("SelectNodeByPath(): got %v", n)
+	}
+	if list := doc.SelectNodesByPath("order/items/item"); len(list) != 3 || list[2].As("", "id") != "3" {
+		t.Errorf("SelectNodesByPath(): got %d nodes", len(list))
+	}
+	if list := doc.SelectNodesByPath("{urn:shop}order/*/item"); len(list) != 3 {
+		t.Errorf("SelectNodesByPath(): got %d nodes with URI step", len(list))
+	}
+	if n := doc.SelectNodeByPath("order/{http://x/y}items"); n != nil {
+		t.Errorf("SelectNodeByPath(): expected nil for wrong prefix")
+	}
+	if list := doc.SelectNodesByPath("order//item"); len(list) != 0 {
+		t.Errorf("SelectNodesByPath(): expected no nodes for malformed path")
+	}
+
+	doc = New()
+	if err := doc.LoadString(`<r xmlns:x="urn:x"><e x:id="1"/><e id="1"/></r>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	r := doc.SelectNode("", "r")
+	for expr, want := range map[string]int{
+		"*[@{urn:x}id='1']": 1,
+		"*[@x:id='1']":      1,
+		"*[@id='1']":        2,
+		"*[@{urn:y}id]":     0,
+		"*[@{}id]":          1,
+	} {
+		if got := len(r.SelectNodesByPath(expr)); got != want {
+			t.Errorf("SelectNodesByPath(%s): got %d nodes, wanted %d", expr, got, want)
+		}
+	}
+	if got := MustCompileQuery("e/@{urn:x}id").Values(r); len(got) != 1 || got[0] != "1" {
+		t.Errorf("Query(e/@{urn:x}id): got %v", got)
+	}
+}
+
+func TestNormalizeAttr(t *testing.T) {
+	data := `<!DOCTYPE doc [
+  <!ATTLIST doc refs IDREFS #IMPLIED
+                kind (a | b) "a"
+                note CDATA #FIXED " x ">
+]>
+<doc refs="  a
+  b  " kind=" b " note="one	two" other=" p  q "/>`
+
+	doc := New()
+	doc.NormalizeAttr = true
+	doc.AttrTypes = map[string]string{"doc@other": "NMTOKENS"}
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	n := doc.SelectNode("", "doc")
+	tests := map[string]string{"refs": "a b", "kind": "b", "note": "one two", "other": "p q"}
+	for attr, want := range tests {
+		if got := n.As("", attr); got != want {
+			t.Errorf("As(%s): got %q, wanted %q", attr, got, want)
+		}
+	}
+
+	doc.NormalizeAttr = false
+	doc.LoadString(data, nil)
+	if got := doc.SelectNode("", "doc").As("", "kind"); got != " b " {
+		t.Errorf("As(kind): got %q without normalization", got)
+	}
+}
+
+func TestSelectNodesFunc(t *testing.T) {
+	doc := New()
+	if err := doc.LoadString(`<list><item price="5">a</item><item price="15">b<item price="20">c</item></item></list>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	expensive := func(n *Node) bool { return n.IsElement() && n.Ai("", "price") > 10 }
+
+	if list := doc.SelectNodesRecursiveFunc(expensive); len(list) != 2 || list[1].GetValue() != "c" {
+		t.Errorf("SelectNodesRecursiveFunc(): got %d nodes", len(list))
+	}
+	if list := doc.SelectNode("", "list").SelectNodesFunc(expensive); len(list) != 1 || list[0].GetValue() != "b" {
+		t.Errorf("SelectNodesFunc(): got %d nodes", len(list))
+	}
+	if list := doc.SelectNodesRecursiveFunc(func(n *Node) bool { return n.IsText() }); len(list) != 3 {
+		t.Errorf("SelectNodesRecursiveFunc(): got %d text nodes", len(list))
+	}
+}
+
+func TestSelectNodesByAttr(t *testing.T) {
+	data := `<items xmlns:x="urn:x">
+  <item status="pending" id="1"/>
+  <item status="done" id="2"><item status="pending" id="3"/></item>
+  <other status="pending"/>
+  <item x:status="pending" xml:lang="en" id="4"/>
+</items>`
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	list := doc.SelectNodesByAttr("", "item", "status", "pending")
+	if len(list) != 2 || list[0].As("", "id") != "1" || list[1].As("", "id") != "3" {
+		t.Errorf("SelectNodesByAttr(): got %d nodes", len(list))
+	}
+	if list = doc.SelectNodesByAttr("*", "*", "status", "*"); len(list) != 4 {
+		t.Errorf("SelectNodesByAttr(): got %d nodes with any value", len(list))
+	}
+	if list = doc.SelectNodesByAttr("", "item", "x:status", "pending"); len(list) != 1 {
+		t.Errorf("SelectNodesByAttr(): got %d nodes for prefixed attribute", len(list))
+	}
+	if list = doc.SelectNodesByAttr("", "item", "xml:lang", "en"); len(list) != 1 {
+		t.Errorf("SelectNodesByAttr(): got %d nodes for xml:lang", len(list))
+	}
+}
+
+func TestUnicodeNormalization(t *testing.T) {
+	// A stand-in for norm.NFC.String covering a single composition.
+	nfc := func(s string) string { return strings.Replace(s, "é", "é", -1) }
+
+	data := "<names><n lang=\"café\">José</n><n>Ana</n><n alias=\"René\"/></names>"
+
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	if list := doc.Unnormalized(nfc); len(list) != 2 {
+		t.Errorf("Unnormalized(): got %d nodes, wanted 2", len(list))
+	}
+
+	doc.NormalizeValues(nfc)
+	if list := doc.Unnormalized(nfc); len(list) != 0 {
+		t.Errorf("Unnormalized(): got %d nodes after NormalizeValues", len(list))
+	}
+
+	doc.Normalize = nfc
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	if got := doc.SelectNode("", "n").GetValue(); got != "José" {
+		t.Errorf("GetValue(): got %q with Normalize set", got)
+	}
+	if got := doc.SelectNodes("", "names")[0].Children[2].As("", "alias"); got != "René" {
+		t.Errorf("As(alias): got %q with Normalize set", got)
+	}
+}
+
+func TestBadChars(t *testing.T) {
+	data := "<doc a=\"x\x01y\">one\x00two\nthree\xed\xa0\x80</doc>"
+
+	doc := New()
+	if err := doc.LoadString(data, nil); err == nil {
+		t.Errorf("LoadString(): expected error for invalid characters")
+	}
+
+	doc.BadChars = BADCHAR_STRIP
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	n := doc.SelectNode("", "doc")
+	if n.As("", "a") != "xy" || n.GetValue() != "onetwo\nthree" {
+		t.Errorf("LoadString(): got %q, %q", n.As("", "a"), n.GetValue())
+	}
+	if len(doc.Warnings) != 3 || !strings.Contains(doc.Warnings[2].Error(), "line 2") {
+		t.Errorf("LoadString(): got warnings %v", doc.Warnings)
+	}
+
+	doc.BadChars = BADCHAR_REPLACE
+	doc.LoadString(data, nil)
+	if got := doc.SelectNode("", "doc").As("", "a"); got != "x�y" {
+		t.Errorf("LoadString(): got %q with BADCHAR_REPLACE", got)
+	}
+
+	n = doc.SelectNode("", "doc")
+	n.SetAttr("a", "bell\x07")
+	doc.BadChars = BADCHAR_ERROR
+	var buf bytes.Buffer
+	if err := doc.SaveStream(&buf); err == nil {
+		t.Errorf("SaveStream(): expected error for invalid character")
+	}
+	doc.BadChars = BADCHAR_STRIP
+	if got := doc.SaveString(); !strings.Contains(got, `a="bell"`) {
+		t.Errorf("SaveString(): got %q", got)
+	}
+}
+
+func TestSelectWildcards(t *testing.T) {
+	data := `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:a="urn:a">
+  <soap:Header/>
+  <soap:Body><a:item/><item/><!-- note --></soap:Body>
+</soap:Envelope>`
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	if list := doc.SelectNodesRecursive("*", "item"); len(list) != 2 {
+		t.Errorf("SelectNodesRecursive(*, item): got %d nodes, wanted 2", len(list))
+	}
+	env := doc.SelectNode("soap", "Envelope")
+	if list := env.SelectNodes("soap", "*"); len(list) != 2 {
+		t.Errorf("SelectNodes(soap, *): got %d nodes, wanted 2", len(list))
+	}
+	body := doc.SelectNode("*", "Body")
+	if list := body.SelectNodes("*", "*"); len(list) != 2 {
+		t.Errorf("SelectNodes(*, *): got %d nodes, wanted only the 2 elements", len(list))
+	}
+	if n := doc.SelectNode("*", "*"); n != env {
+		t.Errorf("SelectNode(*, *): expected the document element, got %v", n)
+	}
+}
+
+func TestCompileQuery(t *testing.T) {
+	data := `<order>
+  <items>
+    <item id="1" status="open"><sku>A1</sku></item>
+    <item status="open"><sku>B2</sku></item>
+    <item id="3" status="closed"><sku>C3</sku></item>
+    <item id="4" status="open"><sku>A1</sku></item>
+  </items>
+</order>`
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"order/items/item[@id]/@id", "1,3,4"},
+		{"order/items/item[@status='open']/@id", "1,4"},
+		{`order/items/item[sku="A1"][2]/@id`, "4"},
+		{"order/items/item[2]/sku", "B2"},
+		{"order/items/item[sku][@id='3']/sku", "C3"},
+		{"order/items/item[9]", ""},
+	}
+
+	for _, tt := range tests {
+		q, err := CompileQuery(tt.expr)
+		if err != nil {
+			t.Errorf("CompileQuery(%s): %s", tt.expr, err)
+			continue
+		}
+		if got := strings.Join(q.Values(doc.Root), ","); got != tt.want {
+			t.Errorf("%s: got %q, wanted %q", q, got, tt.want)
+		}
+	}
+
+	q := MustCompileQuery("order/items/item/@id")
+	if list := q.Evaluate(doc.Root); len(list) != 3 {
+		t.Errorf("Evaluate(): got %d nodes, wanted 3", len(list))
+	}
+	if n := q.First(doc.Root); n == nil || n.As("", "id") != "1" {
+		t.Errorf("First(): got %v", n)
+	}
+
+	for _, expr := range []string{"a[", "a[@id=x]", "a[0]", "a[]"} {
+		if _, err := CompileQuery(expr); err == nil {
+			t.Errorf("CompileQuery(%s): expected error", expr)
+		}
+	}
+}
+
+func TestBOM(t *testing.T) {
+	doc := New()
+	doc.StrictRoot = true
+	if err := doc.LoadString("\xef\xbb\xbf<a>x</a>", nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	doc.SaveDocType = false
+	if got := doc.SaveString(); got != "<a>x</a>" {
+		t.Errorf("SaveString(): got %q, wanted BOM dropped", got)
+	}
+
+	doc.SaveBOM = true
+	if got := string(doc.SaveBytes()); got != "\xef\xbb\xbf<a>x</a>" {
+		t.Errorf("SaveBytes(): got %q, wanted UTF-8 BOM", got)
+	}
+	if got := doc.SaveString(); got != "<a>x</a>" {
+		t.Errorf("SaveString(): got %q, wanted no BOM", got)
+	}
+
+	doc.Encoding = "UTF-16BE"
+	if got := doc.SaveBytes(); !bytes.Equal(got[:4], []byte{0xfe, 0xff, 0, '<'}) {
+		t.Errorf("SaveBytes(): got % x, wanted UTF-16BE with BOM", got[:4])
+	}
+
+	doc.SaveBOM = false
+	doc.Encoding = "UTF-16"
+	if got := doc.SaveBytes(); !bytes.Equal(got[:4], []byte{0xff, 0xfe, '<', 0}) || len(got) != 18 {
+		t.Errorf("SaveBytes(): got % x, wanted UTF-16LE with BOM", got)
+	}
+	if got := doc.String(); got != "<a>x</a>" {
+		t.Errorf("String(): got %q, wanted UTF-8 text", got)
+	}
+	var buf bytes.Buffer
+	if err := doc.SaveStream(&buf); err != nil || len(buf.Bytes()) != 18 {
+		t.Errorf("SaveStream(): got % x, wanted UTF-16LE with BOM", buf.Bytes())
+	}
+}
+
+func TestFind(t *testing.T) {
+	data := `<shop xmlns:x="urn:x">
+  <order id="o1">
+    <item class="urgent big" sku="A1" n="1"/>
+    <item class="big" n="2"/>
+    <box><item class="urgent" sku="B2" n="3"/></box>
+    <x:item class="urgent" sku="C3" n="4"/>
+  </order>
+  <item class="urgent" sku="D4" n="5"/>
+</shop>`
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	tests := []struct {
+		sel  string
+		want string
+	}{
+		{"order > item.urgent[sku]", "1,4"},
+		{"order item.urgent", "1,3,4"},
+		{"x|item", "4"},
+		{"#o1 > *:first-child", "1"},
+		{"item + item", "2"},
+		{"item ~ x|*", "4"},
+		{"[sku^=B], [sku$='4']", "3,5"},
+		{"order > :nth-child(2)", "2"},
+		{"shop > item:last-child", "5"},
+		{"item[class~=big]:not(x)", ""},
+	}
+
+	for _, tt := range tests {
+		vals := []string{}
+		for _, n := range doc.Find(tt.sel) {
+			vals = append(vals, n.As("", "n"))
+		}
+		if got := strings.Join(vals, ","); got != tt.want {
+			t.Errorf("Find(%s): got %q, wanted %q", tt.sel, got, tt.want)
+		}
+	}
+
+	for _, sel := range []string{"", "a >", "a[", "a:hover", "a,,b"} {
+		if _, err := CompileSelector(sel); err == nil {
+			t.Errorf("CompileSelector(%q): expected error", sel)
+		}
+	}
+}
+
+func TestNewlines(t *testing.T) {
+	data := "<?xml version=\"1.0\"?>\r\n<a x=\"1\r\n2\">\r\n<!-- one\r\ntwo\rthree -->\r\n<b>x\r\ny</b><?pi p\r\nq?></a>"
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	a := doc.SelectNode("", "a")
+	if v := a.As("", "x"); v != "1\n2" {
+		t.Errorf("attribute: got %q, wanted %q", v, "1\n2")
+	}
+	if v := doc.SelectNode("", "b").GetValue(); v != "x\ny" {
+		t.Errorf("text: got %q, wanted %q", v, "x\ny")
+	}
+	for _, v := range a.Children {
+		if strings.Contains(v.Value, "\r") {
+			t.Errorf("%s: value %q holds a carriage return", v.Type, v.Value)
+		}
+	}
+
+	IndentPrefix = " "
+	defer func() { IndentPrefix = "" }()
+
+	doc = New()
+	if err := doc.LoadString("<a><b/><c/></a>", nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	doc.SaveDocType = false
+	doc.Newline = "\r\n"
+	if got, want := doc.SaveString(), "<a>\r\n <b />\r\n <c />\r\n</a>"; got != want {
+		t.Errorf("SaveString(): got %q, wanted %q", got, want)
+	}
+	if got, want := doc.Root.String(), "<a>\n <b />\n <c />\n</a>"; got != want {
+		t.Errorf("String(): got %q, wanted %q", got, want)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	data := `<log xmlns:x="urn:x">
+  <entry level="warn" code="E-17">disk <b>almost</b> full</entry>
+  <entry level="info">user logged in</entry>
+  <entry level="error" x:ref="E-42">disk failure</entry>
+</log>`
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	names := func(list []*Node) string {
+		vals := []string{}
+		for _, n := range list {
+			vals = append(vals, n.Name.Local+":"+n.As("", "level"))
+		}
+		return strings.Join(vals, ",")
+	}
+
+	if got, want := names(doc.SearchText(regexp.MustCompile(`^disk`))), "entry:warn,entry:error"; got != want {
+		t.Errorf("SearchText(): got %q, wanted %q", got, want)
+	}
+	if got, want := names(doc.SearchText(regexp.MustCompile(`almost`))), "b:"; got != want {
+		t.Errorf("SearchText(): got %q, wanted %q", got, want)
+	}
+	if got, want := names(doc.SearchAttr(regexp.MustCompile(`^E-\d+$`))), "entry:warn,entry:error"; got != want {
+		t.Errorf("SearchAttr(): got %q, wanted %q", got, want)
+	}
+	if got := doc.SearchAttr(regexp.MustCompile(`urn`)); len(got) != 0 {
+		t.Errorf("SearchAttr(): namespace declaration matched")
+	}
+}
+
+func TestNoIndentElements(t *testing.T) {
+	data := `<doc><sig><v>QUJD
+REVG</v><k><n/></k></sig><p><q/></p></doc>`
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	IndentPrefix = "  "
+	defer func() { IndentPrefix = "" }()
+	doc.SaveDocType = false
+	doc.NoIndent = []string{"sig"}
+
+	expected := `<doc>
+  <sig><v>QUJD&#xA;REVG</v><k><n /></k></sig>
+  <p>
+    <q />
+  </p>
+</doc>`
+	if got := doc.SaveString(); got != expected {
+		t.Errorf("expected: %s\ngot: %s", expected, got)
+	}
+}
+
+func TestMaxIndent(t *testing.T) {
+	doc := New()
+	if err := doc.LoadString(`<a><b><c><d/></c></b><e/></a>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	IndentPrefix = "  "
+	defer func() { IndentPrefix = "" }()
+	doc.SaveDocType = false
+	doc.MaxIndent = 1
+
+	expected := `<a>
+  <b><c><d /></c></b>
+  <e />
+</a>`
+	if got := doc.SaveString(); got != expected {
+		t.Errorf("expected: %s\ngot: %s", expected, got)
+	}
+}
+
+func TestSiblings(t *testing.T) {
+	doc := New()
+	if err := doc.LoadString(`<a><b/>text<!-- c --><d/></a>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	a := doc.SelectNode("", "a")
+	b, d := a.Children[0], a.Children[3]
+
+	if n := b.NextSibling(); n == nil || n.Type != NT_TEXT {
+		t.Errorf("NextSibling(): got %v, wanted the text node", n)
+	}
+	if n := d.PrevSibling(); n == nil || n.Type != NT_COMMENT {
+		t.Errorf("PrevSibling(): got %v, wanted the comment", n)
+	}
+	if n := b.NextSiblingElement(); n != d {
+		t.Errorf("NextSiblingElement(): got %v, wanted <d>", n)
+	}
+	if n := d.PrevSiblingElement(); n != b {
+		t.Errorf("PrevSiblingElement(): got %v, wanted <b>", n)
+	}
+	if b.PrevSibling() != nil || d.NextSibling() != nil || d.NextSiblingElement() != nil || a.NextSibling() != nil {
+		t.Errorf("expected nil beyond the first and last child")
+	}
+	if NewNode(NT_ELEMENT).PrevSiblingElement() != nil {
+		t.Errorf("expected nil for a detached node")
+	}
+}
+
+func TestMutateWhileIterating(t *testing.T) {
+	doc := New()
+	if err := doc.LoadString(`<a><b n="1"/><b n="2"/><b n="3"/><b n="4"/></a>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	a := doc.SelectNode("", "a")
+	seen := []string{}
+	doc.SelectNodesRecursiveFunc(func(n *Node) bool {
+		if n.Name.Local != "b" {
+			return false
+		}
+		seen = append(seen, n.As("", "n"))
+		switch n.As("", "n") {
+		case "1":
+			a.RemoveChild(n)
+		case "2":
+			a.RemoveChild(a.Children[2]) // <b n="4">, after 1 is gone.
+			c := NewNode(NT_ELEMENT)
+			c.Name.Local = "b"
+			c.SetAttr("n", "5")
+			a.AddChild(c)
+		}
+		return false
+	})
+	if got, want := strings.Join(seen, ","), "1,2,3"; got != want {
+		t.Errorf("visited %s, wanted %s", got, want)
+	}
+
+	doc.SelectNode("", "b").OnSave = func(n *Node) {
+		a.RemoveChild(n.NextSibling())
+	}
+	if got, want := a.String(), `<a><b n="2" /><b n="5" /></a>`; got != want {
+		t.Errorf("String(): got %s, wanted %s", got, want)
+	}
+}
+
+func TestChildElements(t *testing.T) {
+	doc := New()
+	if err := doc.LoadString("<a>\n  <!-- x --><b/>text<c/><d/>\n</a>", nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	a := doc.SelectNode("", "a")
+	if n := a.FirstChildElement(); n == nil || n.Name.Local != "b" {
+		t.Errorf("FirstChildElement(): got %v, wanted <b>", n)
+	}
+	if n := a.LastChildElement(); n == nil || n.Name.Local != "d" {
+		t.Errorf("LastChildElement(): got %v, wanted <d>", n)
+	}
+	names := []string{}
+	for _, n := range a.ChildElements() {
+		names = append(names, n.Name.Local)
+	}
+	if got := strings.Join(names, ","); got != "b,c,d" {
+		t.Errorf("ChildElements(): got %s, wanted b,c,d", got)
+	}
+	if n := a.ChildElementCount(); n != 3 {
+		t.Errorf("ChildElementCount(): got %d, wanted 3", n)
+	}
+
+	b := a.FirstChildElement()
+	if b.FirstChildElement() != nil || b.LastChildElement() != nil || len(b.ChildElements()) != 0 {
+		t.Errorf("expected no child elements for <b>")
+	}
+}
+
+func TestFindByHash(t *testing.T) {
+	data := `<docs xmlns:a="urn:legal" xmlns:b="urn:legal">
+  <a:notice lang="en" v="1"><a:p>All rights reserved.</a:p></a:notice>
+  <b:notice v="1" lang="en"><!-- copy --><b:p>All rights <![CDATA[reserved.]]></b:p></b:notice>
+  <a:notice lang="es" v="1"><a:p>Todos los derechos reservados.</a:p></a:notice>
+</docs>`
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	notices := doc.SelectNodesRecursive("*", "notice")
+	if len(notices) != 3 {
+		t.Fatalf("expected 3 notices, got %d", len(notices))
+	}
+
+	found := doc.FindByHash(notices[0].Hash())
+	if len(found) != 2 || found[0] != notices[0] || found[1] != notices[1] {
+		t.Errorf("FindByHash(): got %d nodes, wanted the first two notices", len(found))
+	}
+	if found := doc.FindByHash(notices[2].Hash()); len(found) != 1 {
+		t.Errorf("FindByHash(): got %d nodes, wanted 1", len(found))
+	}
+	if found := doc.FindByHash([]byte("nope")); len(found) != 0 {
+		t.Errorf("FindByHash(): got %d nodes, wanted none", len(found))
+	}
+
+	other := New()
+	if err := other.LoadString(`<notice xmlns="urn:legal" v="1" lang="en"><p>All rights reserved.</p></notice>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	idx := NewHashIndex()
+	idx.Add(doc.Root)
+	if found := idx.Find(other.SelectNode("*", "notice").Hash()); len(found) != 2 {
+		t.Errorf("HashIndex.Find(): got %d nodes across documents, wanted 2", len(found))
+	}
+}
+
+func TestAncestors(t *testing.T) {
+	doc := New()
+	if err := doc.LoadString(`<batch><record id="7"><record id="8"><field>x</field></record></record></batch>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	field := doc.SelectNode("", "field")
+	names := []string{}
+	for _, n := range field.Ancestors() {
+		names = append(names, n.Type.String()+":"+n.Name.Local)
+	}
+	want := "NT_ELEMENT:record,NT_ELEMENT:record,NT_ELEMENT:batch,NT_ROOT:"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("Ancestors(): got %s, wanted %s", got, want)
+	}
+
+	if n := field.FindAncestor("", "record"); n == nil || n.As("", "id") != "8" {
+		t.Errorf("FindAncestor(record): got %v, wanted record 8", n)
+	}
+	if n := field.Children[0].FindAncestor("*", "batch"); n == nil {
+		t.Errorf("FindAncestor(batch): got nil")
+	}
+	if n := field.FindAncestor("", "field"); n != nil {
+		t.Errorf("FindAncestor(field): got %v, wanted nil", n)
+	}
+	if len(doc.Root.Ancestors()) != 0 {
+		t.Errorf("Ancestors(): expected none for the document root")
+	}
+}
+
+func TestPath(t *testing.T) {
+	data := `<catalog xmlns:x="urn:x"><book/><book/><book><title>Go</title><!-- c --><x:isbn>1</x:isbn></book></catalog>`
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	books := doc.SelectNodesRecursive("", "book")
+	title := books[2].SelectNode("", "title")
+	tests := []struct {
+		n    *Node
+		want string
+	}{
+		{doc.Root, "/"},
+		{title, "/catalog/book[3]/title"},
+		{title.Children[0], "/catalog/book[3]/title/text()"},
+		{books[2].Children[1], "/catalog/book[3]/comment()"},
+		{books[2].SelectNode("urn:x", "isbn"), "/catalog/book[3]/x:isbn"},
+	}
+	for _, tt := range tests {
+		if got := tt.n.Path(); got != tt.want {
+			t.Errorf("Path(): got %q, wanted %q", got, tt.want)
+		}
+	}
+
+	if n := doc.SelectNodeByPath(title.Path()); n != title {
+		t.Errorf("SelectNodeByPath(%q): got %v, wanted the title", title.Path(), n)
+	}
+
+	title.Parent.RemoveChild(title)
+	if got := title.Children[0].Path(); got != "/title/text()" {
+		t.Errorf("Path(): got %q, wanted /title/text() for a detached node", got)
+	}
+}
+
+func TestToDocument(t *testing.T) {
+	data := `<!DOCTYPE feed [ <!ENTITY corp "ACME Corp"> <!ENTITY logo SYSTEM "logo.xml"> ]>
+<feed xmlns="urn:feed" xmlns:x="urn:x">
+  <entry x:id="1"><title>Hello</title></entry>
+</feed>`
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	entry := doc.SelectNode("*", "entry")
+	ref := NewNode(NT_ENTITYREF)
+	ref.Value = "corp"
+	entry.AddChild(ref)
+
+	sub := entry.ToDocument()
+	sub.SaveDocType = false
+	want := `<!DOCTYPE entry [ <!ENTITY corp "ACME Corp"> ]><entry x:id="1" xmlns="urn:feed" xmlns:x="urn:x"><title>Hello</title>&corp;</entry>`
+	if got := sub.SaveString(); got != want {
+		t.Errorf("SaveString():\ngot  %s\nwant %s", got, want)
+	}
+	if v := sub.Entity["corp"]; v != "ACME Corp" {
+		t.Errorf("Entity[corp]: got %q", v)
+	}
+
+	sub.SelectNode("*", "title").SetValue("Changed")
+	if v := doc.SelectNode("*", "title").GetValue(); v != "Hello" {
+		t.Errorf("original changed along with the copy: %q", v)
+	}
+	if entry.Parent == nil || len(entry.Attributes) != 1 {
+		t.Errorf("original node was modified")
+	}
+
+	reload := New()
+	reload.Entity = sub.Entity
+	if err := reload.LoadString(sub.SaveString(), nil); err != nil {
+		t.Fatalf("LoadString(saved copy): %s", err)
+	}
+	if n := reload.SelectNode("urn:feed", "entry"); n == nil || n.GetValue() != "ACME Corp" {
+		t.Errorf("reloaded copy: got %v", n)
+	}
+
+	doc.SaveDocType = false
+	doc.OmitEmpty = []string{"title"}
+	doc.SelectNode("*", "title").SetValue("")
+	sub = doc.ToDocument(entry)
+	want = `<!DOCTYPE entry [ <!ENTITY corp "ACME Corp"> ]><entry x:id="1" xmlns="urn:feed" xmlns:x="urn:x">&corp;</entry>`
+	if got := sub.SaveString(); got != want {
+		t.Errorf("Document.ToDocument():\ngot  %s\nwant %s", got, want)
+	}
+	if _, ok := doc.Entity["corp"]; ok {
+		t.Errorf("Document.ToDocument(): changed the Entity map of the original")
+	}
+}
+
+func TestImportNode(t *testing.T) {
+	src := New()
+	data := `<!DOCTYPE lib [ <!ENTITY pub "O'Reilly"> <!ENTITY ed "2nd"> ]>
+<lib xmlns:b="urn:books"><b:book id="1"><b:title>Go</b:title></b:book></lib>`
+	if err := src.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	book := src.SelectNode("b", "book")
+	for _, name := range []string{"pub", "ed"} {
+		ref := NewNode(NT_ENTITYREF)
+		ref.Value = name
+		book.AddChild(ref)
+	}
+
+	dst := New()
+	dst.Entity["ed"] = "second"
+	if err := dst.LoadString(`<shelf xmlns:b="urn:other"/>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	shallow := dst.ImportNode(book, false)
+	if len(shallow.Children) != 0 || shallow.As("", "id") != "1" || shallow.Parent != nil {
+		t.Errorf("ImportNode(shallow): got %s", shallow)
+	}
+
+	imported := dst.ImportNode(book, true)
+	dst.SelectNode("", "shelf").AddChild(imported)
+	dst.SaveDocType = false
+	want := `<shelf xmlns:b="urn:other"><b:book id="1" xmlns:b="urn:books"><b:title>Go</b:title>&pub;2nd</b:book></shelf>`
+	if got := dst.SaveString(); got != want {
+		t.Errorf("SaveString():\ngot  %s\nwant %s", got, want)
+	}
+	if v := dst.Entity["pub"]; v != "O'Reilly" {
+		t.Errorf("Entity[pub]: got %q", v)
+	}
+	if v := dst.Entity["ed"]; v != "second" {
+		t.Errorf("Entity[ed]: got %q", v)
+	}
+	if n := dst.SelectNode("urn:books", "title"); n == nil {
+		t.Errorf("imported title not found by namespace URI")
+	}
+	if book.Parent == nil || len(book.Children) != 3 {
+		t.Errorf("original node was modified")
+	}
+}
+
+func TestRename(t *testing.T) {
+	data := `<root xmlns="urn:a" xmlns:p="urn:p"><item p:id="1" n="x"><p:sub/></item><plain/></root>`
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	doc.SaveDocType = false
+
+	item := doc.SelectNode("*", "item")
+	item.Hints = HINT_EXPANDED
+	if err := item.Rename("urn:b", "b", "entry"); err != nil {
+		t.Fatalf("Rename(): %s", err)
+	}
+	if err := doc.SelectNode("*", "plain").Rename("", "", "plain"); err != nil {
+		t.Fatalf("Rename(): %s", err)
+	}
+	want := `<root xmlns="urn:a" xmlns:p="urn:p"><b:entry p:id="1" n="x" xmlns:b="urn:b"><p:sub /></b:entry><plain xmlns="" /></root>`
+	if got := doc.SaveString(); got != want {
+		t.Errorf("SaveString():\ngot  %s\nwant %s", got, want)
+	}
+	if item.NamespaceURI() != "urn:b" || item.Prefix() != "b" || item.Hints != HINT_EXPANDED {
+		t.Errorf("renamed element: got %s %s %d", item.NamespaceURI(), item.Prefix(), item.Hints)
+	}
+
+	if err := item.Rename("urn:q", "p", "entry"); err == nil {
+		t.Errorf("Rename(): expected error when rebinding a prefix used below")
+	}
+	if err := item.Rename("urn:c", "b", "entry"); err == nil {
+		t.Errorf("Rename(): expected error for a prefix declared on the element")
+	}
+	if err := item.Rename("", "x", "entry"); err == nil {
+		t.Errorf("Rename(): expected error for a prefix without namespace")
+	}
+	if len(item.Attributes) != 3 || item.QualifiedName() != "b:entry" {
+		t.Errorf("failed renames changed the element: %s", item)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	doc := New()
+	if err := doc.LoadString(`<a><skip><x/></skip><b><c/><stop/><d/></b><e/></a>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	visited := []string{}
+	doc.Walk(func(n *Node, depth int) WalkAction {
+		visited = append(visited, n.Name.Local+strconv.Itoa(depth))
+		switch n.Name.Local {
+		case "skip":
+			return WALK_SKIP
+		case "stop":
+			return WALK_STOP
+		}
+		return WALK_CONTINUE
+	})
+	if got, want := strings.Join(visited, ","), "a0,skip1,b1,c2,stop2"; got != want {
+		t.Errorf("Walk(): got %s, wanted %s", got, want)
+	}
+
+	visited = visited[:0]
+	doc.SelectNode("", "b").Walk(func(n *Node, depth int) WalkAction {
+		visited = append(visited, n.Name.Local+strconv.Itoa(depth))
+		return WALK_CONTINUE
+	})
+	if got, want := strings.Join(visited, ","), "b0,c1,stop1,d1"; got != want {
+		t.Errorf("Node.Walk(): got %s, wanted %s", got, want)
+	}
+}
+
+func TestOmitEmpty(t *testing.T) {
+	data := `<order>
+  <notes>  </notes>
+  <extras><gift/><notes/></extras>
+  <gift wrap="yes"/>
+  <items><item>1</item><gift/></items>
+  <empty/>
+</order>`
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	doc.SaveDocType = false
+	doc.OmitEmpty = []string{"notes", "extras", "gift"}
+
+	IndentPrefix = "  "
+	defer func() { IndentPrefix = "" }()
+
+	want := `<order>
+  <gift wrap="yes" />
+  <items>
+    <item>1</item>
+  </items>
+  <empty />
+</order>`
+	if got := doc.SaveString(); got != want {
+		t.Errorf("SaveString():\ngot  %s\nwant %s", got, want)
+	}
+
+	doc.OmitEmpty = []string{"*"}
+	want = `<order>
+  <gift wrap="yes" />
+  <items>
+    <item>1</item>
+  </items>
+</order>`
+	if got := doc.SaveString(); got != want {
+		t.Errorf("SaveString():\ngot  %s\nwant %s", got, want)
+	}
+
+	IndentPrefix = ""
+	if err := doc.LoadString(`<a><bar> </bar><notes> </notes><c> <gift/> </c></a>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	doc.OmitEmpty = []string{"notes", "gift"}
+	if got, want := doc.SaveString(), `<a><bar> </bar><c>  </c></a>`; got != want {
+		t.Errorf("SaveString(): got %s, want %s", got, want)
+	}
+}
+
+func TestSelectNodesStream(t *testing.T) {
+	doc := New()
+	if err := doc.LoadString(`<a><hit n="1"><hit n="2"/></hit><b><hit n="3"/></b><hit n="4"/></a>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	seen := []string{}
+	doc.SelectNodesStream("", "hit", func(n *Node) bool {
+		seen = append(seen, n.As("", "n"))
+		return len(seen) < 3
+	})
+	if got, want := strings.Join(seen, ","), "1,2,3"; got != want {
+		t.Errorf("SelectNodesStream(): got %s, wanted %s", got, want)
+	}
+
+	count := 0
+	doc.SelectNode("", "b").SelectNodesStream("*", "*", func(n *Node) bool {
+		count++
+		return true
+	})
+	if count != 1 {
+		t.Errorf("SelectNodesStream(*): got %d matches, wanted 1", count)
+	}
+}
+
+func TestLoadStreamTee(t *testing.T) {
+	data := "\xef\xbb\xbf<a>\r\n<b>x</b></a>\n"
+	var archive bytes.Buffer
+
+	doc := New()
+	if err := doc.LoadStreamTee(strings.NewReader(data), &archive, nil); err != nil {
+		t.Fatalf("LoadStreamTee(): %s", err)
+	}
+	if archive.String() != data {
+		t.Errorf("archive: got %q, wanted %q", archive.String(), data)
+	}
+	if doc.SelectNode("", "b") == nil {
+		t.Errorf("document not loaded")
+	}
+
+	if err := doc.LoadStreamTee(strings.NewReader(data), failWriter{}, nil); err == nil {
+		t.Errorf("LoadStreamTee(): expected write error")
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestRedact(t *testing.T) {
+	data := `<order xmlns:p="urn:pay">
+  <customer><name>Ann</name><ssn>123-45-6789</ssn></customer>
+  <ssn>not in customer</ssn>
+  <p:card p:number="4111111111111111" type="visa"><holder><first>Ann</first> <last>Lee</last></holder></p:card>
+  <note>ok</note>
+</order>`
+	doc := New()
+	doc.Redact = []RedactRule{
+		{Path: "customer/ssn", Mask: "XXX"},
+		{Path: "/order/p:card/@p:number", Func: func(s string) string { return "****" + s[len(s)-4:] }},
+		{Path: "holder", Mask: "*"},
+	}
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	ssns := doc.SelectNodesRecursive("", "ssn")
+	if v := ssns[0].GetValue(); v != "XXX" {
+		t.Errorf("customer/ssn: got %q", v)
+	}
+	if v := ssns[1].GetValue(); v != "not in customer" {
+		t.Errorf("ssn: got %q", v)
+	}
+	card := doc.SelectNode("*", "card")
+	if v := card.As("*", "number"); v != "****1111" {
+		t.Errorf("@p:number: got %q", v)
+	}
+	if v := card.As("", "type"); v != "visa" {
+		t.Errorf("@type: got %q", v)
+	}
+	if got := doc.SelectNode("", "holder").String(); got != "<holder><first>*</first> <last>*</last></holder>" {
+		t.Errorf("holder: got %s", got)
+	}
+	if v := doc.SelectNode("", "note").GetValue(); v != "ok" {
+		t.Errorf("note: got %q", v)
+	}
+
+	doc.Redact = []RedactRule{{Path: "a[1]"}}
+	if err := doc.LoadString(data, nil); err == nil {
+		t.Errorf("LoadString(): expected error for a predicate")
+	}
+}
+
+func TestSelectNodesReverse(t *testing.T) {
+	doc := New()
+	if err := doc.LoadString(`<log><e n="1"><e n="2"/></e><b><e n="3"/></b><e n="4"/></log>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	vals := []string{}
+	for _, n := range doc.SelectNodesReverse("", "e") {
+		vals = append(vals, n.As("", "n"))
+	}
+	if got, want := strings.Join(vals, ","), "4,3,2,1"; got != want {
+		t.Errorf("SelectNodesReverse(): got %s, wanted %s", got, want)
+	}
+
+	vals = vals[:0]
+	doc.SelectNodesStreamReverse("", "e", func(n *Node) bool {
+		vals = append(vals, n.As("", "n"))
+		return len(vals) < 2
+	})
+	if got, want := strings.Join(vals, ","), "4,3"; got != want {
+		t.Errorf("SelectNodesStreamReverse(): got %s, wanted %s", got, want)
+	}
+}
+
+func TestProject(t *testing.T) {
+	data := `<order id="7" xmlns:x="urn:x">
+  <customer vip="yes"><name>Ann</name><address>Main St 1</address></customer>
+  <items>
+    <item sku="A1" qty="2"><desc>Long text</desc></item>
+    <item sku="B2" qty="1"><desc>More text</desc></item>
+  </items>
+  <x:audit>lots of data</x:audit>
+</order>`
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	doc.OmitEmpty = []string{"desc"}
+	p, err := doc.Project([]string{"order/customer/name", "order/items/item/@sku", "order/nope", "order/items/item[2]/desc"})
+	if err != nil {
+		t.Fatalf("Project(): %s", err)
+	}
+	if len(p.OmitEmpty) != 1 {
+		t.Errorf("Project(): settings not copied")
+	}
+	p.SaveDocType = false
+	want := `<order id="7" xmlns:x="urn:x"><customer vip="yes"><name>Ann</name></customer><items><item sku="A1" /><item sku="B2"><desc>More text</desc></item></items></order>`
+	if got := p.SaveString(); got != want {
+		t.Errorf("Project():\ngot  %s\nwant %s", got, want)
+	}
+
+	if n := doc.SelectNode("*", "audit"); n == nil {
+		t.Errorf("Project() changed the original document")
+	}
+	if p, err := doc.Project([]string{"nope"}); err != nil || len(p.Root.Children) != 0 {
+		t.Errorf("Project(): expected an empty document")
+	}
+	if _, err := doc.Project([]string{"order/name", "order/items["}); err == nil {
+		t.Errorf("Project(): expected error for malformed path")
+	}
+}
+
+func TestSelectNodesN(t *testing.T) {
+	doc := New()
+	if err := doc.LoadString(`<a><e n="1"><e n="2"/></e><b><e n="3"/></b><e n="4"/></a>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	for _, tt := range []struct {
+		limit int
+		want  string
+	}{{2, "1,2"}, {3, "1,2,3"}, {10, "1,2,3,4"}, {0, "1,2,3,4"}} {
+		vals := []string{}
+		for _, n := range doc.SelectNodesN("", "e", tt.limit) {
+			vals = append(vals, n.As("", "n"))
+		}
+		if got := strings.Join(vals, ","); got != tt.want {
+			t.Errorf("SelectNodesN(%d): got %s, wanted %s", tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestEntityStats(t *testing.T) {
+	data := `<!DOCTYPE r [ <!ENTITY co "ACME"> <!-- don't &co; --> ]>
+<r a="&co;&amp;"><!-- &co; --><![CDATA[&co;]]><?pi &co;?>&co; &lt; &#65; &co;</r>`
+	doc := New()
+	doc.Entity["co"] = "ACME"
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	s := doc.Stats
+	if s.InputBytes != int64(len(data)) || s.EntityExpansions != 3 || s.EntityBytes != 12 {
+		t.Errorf("Stats: got %+v, wanted %d bytes, 3 expansions of 12 bytes", s, len(data))
+	}
+	if f := s.ExpansionFactor(); f <= 1 {
+		t.Errorf("ExpansionFactor(): got %f, wanted more than 1", f)
+	}
+
+	doc.MaxEntities = 2
+	if err := doc.LoadString(data, nil); err == nil {
+		t.Errorf("LoadString(): expected an error with MaxEntities 2")
+	}
+
+	doc.MaxEntities, doc.MaxEntitySize = 0, 8
+	if err := doc.LoadString(data, nil); err == nil {
+		t.Errorf("LoadString(): expected an error with MaxEntitySize 8")
+	}
+
+	doc.MaxEntitySize = 12
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Errorf("LoadString(): %s", err)
+	}
+
+	doc = New()
+	if err := doc.LoadString(`<r a="&amp;">&lt;</r>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	if s := doc.Stats; s.InputBytes != 21 || s.EntityExpansions != 0 {
+		t.Errorf("Stats: got %+v without entities", s)
+	}
+}
+
+func TestInsertBefore(t *testing.T) {
+	doc := New()
+	if err := doc.LoadString(`<Document><Hdr/><Amt/></Document>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	elem := func(name string) *Node {
+		n := NewNode(NT_ELEMENT)
+		n.Name.Local = name
+		return n
+	}
+	root := doc.SelectNode("", "Document")
+	hdr, amt := root.Children[0], root.Children[1]
+	if err := root.InsertBefore(amt, elem("Id")); err != nil {
+		t.Fatalf("InsertBefore(): %s", err)
+	}
+	if err := root.InsertAfter(amt, elem("Ccy")); err != nil {
+		t.Fatalf("InsertAfter(): %s", err)
+	}
+	if err := root.InsertAfter(nil, elem("Ver")); err != nil {
+		t.Fatalf("InsertAfter(nil): %s", err)
+	}
+	if err := root.InsertBefore(nil, hdr); err != nil {
+		t.Fatalf("InsertBefore(nil): %s", err)
+	}
+
+	want := `<Document><Ver /><Id /><Amt /><Ccy /><Hdr /></Document>`
+	if got := root.String(); got != want {
+		t.Errorf("String(): got %s, wanted %s", got, want)
+	}
+	if hdr.Parent != root || len(root.Children) != 5 {
+		t.Errorf("InsertBefore(): node not moved")
+	}
+
+	if err := root.InsertBefore(elem("x"), elem("y")); err == nil {
+		t.Errorf("InsertBefore(): expected an error for a foreign reference node")
+	}
+	stray := elem("s")
+	stray.Parent = root // Not in root.Children.
+	if err := root.InsertAfter(stray, elem("y")); err == nil {
+		t.Errorf("InsertAfter(): expected an error for a reference node missing from Children")
+	}
+	if err := amt.InsertBefore(nil, root); err == nil {
+		t.Errorf("InsertBefore(): expected an error when inserting an ancestor")
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	data := `<msg><Payload>aGVsbG8g
+PGI+</Payload><CompressedData>H4sIAAAAAAAA/8tIzcnJBwCGphA2BQAAAA==</CompressedData></msg>`
+	doc := New()
+	doc.SaveDocType = false
+	doc.Codecs = map[string]Codec{"Payload": Base64Codec, "CompressedData": GzipCodec}
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	if got := doc.SelectNode("", "Payload").GetValue(); got != "hello <b>" {
+		t.Errorf("Payload: got %q, wanted %q", got, "hello <b>")
+	}
+	if got := doc.SelectNode("", "CompressedData").GetValue(); got != "hello" {
+		t.Errorf("CompressedData: got %q, wanted %q", got, "hello")
+	}
+
+	doc.SelectNode("", "Payload").SetValue("bye")
+	out := doc.SaveString()
+	if !strings.Contains(out, "<Payload>Ynll</Payload>") {
+		t.Errorf("SaveString(): got %s, wanted Payload encoded", out)
+	}
+
+	reload := New()
+	reload.Codecs = doc.Codecs
+	if err := reload.LoadString(out, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	if got := reload.SelectNode("", "CompressedData").GetValue(); got != "hello" {
+		t.Errorf("CompressedData after round trip: got %q, wanted %q", got, "hello")
+	}
+
+	if err := doc.LoadString(`<msg><Payload>!!</Payload></msg>`, nil); err == nil {
+		t.Errorf("LoadString(): expected an error for invalid base64")
+	}
+
+	doc.Codecs = map[string]Codec{"{urn:m}Payload": Base64Codec}
+	data = `<msg xmlns:m="urn:m"><m:Payload><!-- note -->Ynll<?p x?></m:Payload><Payload>plain</Payload></msg>`
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	if got := doc.SelectNode("urn:m", "Payload").GetValue(); got != "bye" {
+		t.Errorf("m:Payload: got %q, wanted %q", got, "bye")
+	}
+	want := `<msg xmlns:m="urn:m"><m:Payload><!-- note --><?p x?>Ynll</m:Payload><Payload>plain</Payload></msg>`
+	if got := doc.SaveString(); got != want {
+		t.Errorf("SaveString(): got %s, wanted %s", got, want)
+	}
+}
+
+func TestReplaceChild(t *testing.T) {
+	doc := New()
+	if err := doc.LoadString(`<a><b/><c/><d/></a>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	a := doc.SelectNode("", "a")
+	b, c, d := a.Children[0], a.Children[1], a.Children[2]
+	if err := a.ReplaceChild(b, d); err != nil {
+		t.Fatalf("ReplaceChild(): %s", err)
+	}
+	if got := a.String(); got != `<a><d /><c /></a>` {
+		t.Errorf("ReplaceChild(): got %s", got)
+	}
+	if b.Parent != nil || d.Parent != a {
+		t.Errorf("ReplaceChild(): parent pointers not updated")
+	}
+	if err := a.ReplaceChild(b, c); err == nil {
+		t.Errorf("ReplaceChild(): expected an error for a detached node")
+	}
+	if err := a.ReplaceChild(d, a); err == nil {
+		t.Errorf("ReplaceChild(): expected an error when inserting an ancestor")
+	}
+	stray := NewNode(NT_ELEMENT)
+	stray.Parent = a // Not in a.Children.
+	if err := a.ReplaceChild(stray, NewNode(NT_ELEMENT)); err == nil {
+		t.Errorf("ReplaceChild(): expected an error for a node missing from Children")
+	}
+
+	c.Remove()
+	if got := a.String(); got != `<a><d /></a>` || c.Parent != nil {
+		t.Errorf("Remove(): got %s", got)
+	}
+	c.Remove()
+}
+
+func TestSetAttrNS(t *testing.T) {
+	doc := New()
+	if err := doc.LoadString(`<a xmlns:x="urn:x" id="1" x:id="2" id="3" k="v"/>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	a := doc.SelectNode("", "a")
+	a.SetAttrNS("x", "id", "20")
+	a.SetAttrNS("x", "ref", "r")
+	if a.As("x", "id") != "20" || a.As("", "id") != "1" || !a.HasAttr("x", "ref") {
+		t.Errorf("SetAttrNS(): got %s", a)
+	}
+
+	a.RemoveAttrNS("x", "id")
+	if a.HasAttr("x", "id") || !a.HasAttr("", "id") {
+		t.Errorf("RemoveAttrNS(x, id): got %s", a)
+	}
+
+	a.RemoveAttr("id")
+	names := []string{}
+	for _, v := range a.Attributes {
+		names = append(names, v.Name.Local)
+	}
+	if got := strings.Join(names, ","); got != "x,k,ref" {
+		t.Errorf("RemoveAttr(id): got attributes %s, wanted x,k,ref", got)
+	}
+}
+
+func TestClone(t *testing.T) {
+	doc := New()
+	if err := doc.LoadString(`<batch><record id="1"><name>x</name><!-- c --></record></batch>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	batch := doc.SelectNode("", "batch")
+	tmpl := doc.SelectNode("", "record")
+	for i := 2; i <= 3; i++ {
+		c := tmpl.Clone()
+		if c.Parent != nil || c.Children[0].Parent != c || c.Children[0].Children[0].Parent != c.Children[0] {
+			t.Fatalf("Clone(): parent pointers not reset")
+		}
+		c.SetAttr("id", strconv.Itoa(i))
+		c.SelectNode("", "name").SetValue("y")
+		batch.AddChild(c)
+	}
+
+	want := `<batch><record id="1"><name>x</name><!-- c --></record>` +
+		`<record id="2"><name>y</name><!-- c --></record><record id="3"><name>y</name><!-- c --></record></batch>`
+	if got := batch.String(); got != want {
+		t.Errorf("Clone(): got %s, wanted %s", got, want)
+	}
+}
+
+func TestMinimizeNamespaces(t *testing.T) {
+	data := `<root xmlns:a="urn:a"><x xmlns:b="urn:b"><b:i/></x><y xmlns:b="urn:b"><b:j/></y>` +
+		`<z xmlns:a="urn:a"><a:k xmlns:a="urn:a"/></z><w xmlns:c="urn:c1"/><v xmlns:c="urn:c2"/></root>`
+	doc := New()
+	doc.SaveDocType = false
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	doc.MinimizeNamespaces()
+	want := `<root xmlns:a="urn:a" xmlns:b="urn:b"><x><b:i /></x><y><b:j /></y>` +
+		`<z><a:k /></z><w xmlns:c="urn:c1" /><v xmlns:c="urn:c2" /></root>`
+	if got := doc.SaveString(); got != want {
+		t.Errorf("MinimizeNamespaces(): got %s, wanted %s", got, want)
+	}
+}
+
+func TestDocumentClone(t *testing.T) {
+	tmpl := New()
+	tmpl.Entity["co"] = "ACME"
+	if err := tmpl.LoadString(`<req xmlns:x="urn:x"><x:id>0</x:id><by>&co;</by></req>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	doc := tmpl.Clone()
+	doc.SelectNode("x", "id").SetValue("42")
+	doc.Entity["co"] = "Other"
+	doc.Namespaces["urn:y"] = "y"
+
+	if got := tmpl.SelectNode("x", "id").GetValue(); got != "0" {
+		t.Errorf("Clone(): original changed to %q", got)
+	}
+	if tmpl.Entity["co"] != "ACME" || tmpl.Namespaces["urn:y"] != "" {
+		t.Errorf("Clone(): original maps changed")
+	}
+	if got := doc.SelectNode("x", "id").GetValue(); got != "42" || doc.SelectNode("", "by").GetValue() != "ACME" {
+		t.Errorf("Clone(): got %s", doc.Root)
+	}
+	if doc.Root.Children[0].Parent != doc.Root {
+		t.Errorf("Clone(): parent pointers not set")
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	inputs := []Input{
+		{Reader: strings.NewReader(`<a>1</a>`)},
+		{Path: "test.xml"},
+		{Reader: strings.NewReader(`<a>`)},
+		{Reader: strings.NewReader(`<b/>`), Doc: &Document{Strict: true, Entity: map[string]string{}, Namespaces: map[string]string{}}},
+		{},
+	}
+	docs, errs := ParseAll(context.Background(), inputs, 2)
+	for i, wantErr := range []bool{false, false, true, false, true} {
+		if (errs[i] != nil) != wantErr || (docs[i] == nil) != wantErr {
+			t.Errorf("ParseAll(): input %d: got doc %v, error %v", i, docs[i] != nil, errs[i])
+		}
+	}
+	if docs[0] != nil && docs[0].SelectNode("", "a").GetValue() != "1" {
+		t.Errorf("ParseAll(): wrong content for input 0")
+	}
+	if docs[3] != inputs[3].Doc {
+		t.Errorf("ParseAll(): Input.Doc not used")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	many := make([]Input, 200)
+	for i := range many {
+		many[i] = Input{Reader: strings.NewReader("<a/>")}
+	}
+	docs, errs = ParseAll(ctx, many, 4)
+	for i := range errs {
+		if docs[i] != nil || errs[i] != context.Canceled {
+			t.Fatalf("ParseAll(): input %d: expected context.Canceled, got %v", i, errs[i])
+		}
+	}
+}
+
+func TestAdopt(t *testing.T) {
+	src := New()
+	if err := src.LoadString(`<feed xmlns="urn:feed" xmlns:x="urn:x"><entry x:id="1"><title>T</title></entry><plain xmlns=""/></feed>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	dst := New()
+	dst.SaveDocType = false
+	if err := dst.LoadString(`<list xmlns:x="urn:x"><box xmlns="urn:box"/></list>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	list := dst.SelectNode("", "list")
+	entry := dst.Adopt(src.SelectNode("*", "entry"))
+	if entry.Parent != nil || src.SelectNode("*", "entry") != nil {
+		t.Errorf("Adopt(): node not removed from its document")
+	}
+	if _, ok := dst.Namespaces["urn:feed"]; !ok {
+		t.Errorf("Adopt(): namespace not registered")
+	}
+	if err := entry.MoveTo(list); err != nil {
+		t.Fatalf("MoveTo(): %s", err)
+	}
+
+	plain := src.SelectNode("*", "plain")
+	if err := plain.MoveTo(list.Children[0]); err != nil {
+		t.Fatalf("MoveTo(): %s", err)
+	}
+
+	want := `<list xmlns:x="urn:x"><box xmlns="urn:box"><plain xmlns="" /></box>` +
+		`<entry x:id="1" xmlns="urn:feed"><title>T</title></entry></list>`
+	if got := dst.SaveString(); got != want {
+		t.Errorf("SaveString(): got %s, wanted %s", got, want)
+	}
+	if err := list.MoveTo(entry); err == nil {
+		t.Errorf("MoveTo(): expected an error when moving a node below itself")
+	}
+}
+
+func TestJSONValueWarnings(t *testing.T) {
+	doc := New()
+	if err := doc.LoadString(`<r><p>Hi <b>you</b> there</p><a/><c/><a/><a/><!-- x --></r>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	b, warn, err := doc.SelectNode("", "r").ToJSONWarnings()
+	if err != nil {
+		t.Fatalf("ToJSONWarnings(): %s", err)
+	}
+	if want := `{"a":["","",""],"c":"","p":{"#text":"Hithere","b":"you"}}`; string(b) != want {
+		t.Errorf("ToJSONWarnings(): got %s, wanted %s", b, want)
+	}
+
+	got := []string{}
+	for _, w := range warn {
+		got = append(got, w.Path+" "+w.Issue)
+	}
+	want := []string{
+		"/r/p mixed content",
+		"/r/a[2] repeated element a not adjacent to the previous one",
+		"/r/comment() comment",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("ToJSONWarnings(): got warnings %q, wanted %q", got, want)
+	}
+}
+
+func TestTypedJSON(t *testing.T) {
+	samples := []string{
+		`<order id="7"><item qty="2"><sku>A1</sku><price>9.50</price></item><item qty="1"><sku>B2</sku><price>3</price></item><paid>true</paid></order>`,
+		`<order id="8"><item qty="5"><sku>C3</sku><price>1.25</price></item><paid>false</paid><note>12b</note></order>`,
+	}
+	nodes := []*Node{}
+	for _, data := range samples {
+		doc := New()
+		if err := doc.LoadString(data, nil); err != nil {
+			t.Fatalf("LoadString(): %s", err)
+		}
+		nodes = append(nodes, doc.SelectNode("", "order"))
+	}
+
+	s := InferJSONTypes(nodes...)
+	if !s.Arrays["item"] || s.Arrays["item/sku"] || s.Types["item/price"] != "number" || s.Types["@id"] != "number" ||
+		s.Types["paid"] != "boolean" || s.Types["item/sku"] != "string" {
+		t.Errorf("InferJSONTypes(): got %+v", s)
+	}
+
+	b, err := nodes[1].ToTypedJSON(s)
+	if err != nil {
+		t.Fatalf("ToTypedJSON(): %s", err)
+	}
+	want := `{"@id":8,"item":[{"@qty":5,"price":1.25,"sku":"C3"}],"note":"12b","paid":false}`
+	if string(b) != want {
+		t.Errorf("ToTypedJSON(): got %s, wanted %s", b, want)
+	}
+
+	nodes[1].SelectNode("", "price").SetValue("")
+	nodes[1].SelectNode("", "paid").SetValue("")
+	b, _ = nodes[1].ToTypedJSON(s)
+	want = `{"@id":8,"item":[{"@qty":5,"price":null,"sku":"C3"}],"note":"12b","paid":null}`
+	if string(b) != want {
+		t.Errorf("ToTypedJSON(): got %s, wanted %s", b, want)
+	}
+}
+
+func TestRenameTree(t *testing.T) {
+	data := `<a xmlns="urn:old" xmlns:k="urn:keep"><b><c xmlns:o="urn:old" o:x="1" k:y="2"/><k:d/></b><e xmlns="urn:other"><f/></e></a>`
+	doc := New()
+	doc.SaveDocType = false
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	a := doc.SelectNode("*", "a")
+	if err := a.RenameTree("urn:new", "A"); err != nil {
+		t.Fatalf("RenameTree(): %s", err)
+	}
+	for _, n := range doc.SelectNodesRecursive("*", "*") {
+		want := "urn:new"
+		switch n.Name.Local {
+		case "d":
+			want = "urn:keep"
+		case "e", "f":
+			want = "urn:other"
+		}
+		if got := n.NamespaceURI(); got != want {
+			t.Errorf("RenameTree(): <%s> in %q, wanted %q", n.Name.Local, got, want)
+		}
+	}
+	want := `<A xmlns="urn:new" xmlns:k="urn:keep"><b><o:c xmlns:o="urn:new" o:x="1" k:y="2" /><k:d /></b>` +
+		`<e xmlns="urn:other"><f /></e></A>`
+	if got := doc.SaveString(); got != want {
+		t.Errorf("SaveString(): got %s, wanted %s", got, want)
+	}
+
+	if err := doc.SelectNode("*", "c").RenameTree("", "c"); err == nil {
+		t.Errorf("RenameTree(): expected an error moving prefix o into no namespace")
+	}
+}
+
+func TestJsonML(t *testing.T) {
+	data := `<ul xmlns="urn:l" xmlns:x="urn:x" x:id="1"><li>one</li> <li class="b">two<!-- c --></li><x:li/></ul>`
+	doc := New()
+	if err := doc.LoadString(data, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	ul := doc.SelectNode("*", "ul")
+	b, err := ul.ToJsonML()
+	if err != nil {
+		t.Fatalf("ToJsonML(): %s", err)
+	}
+	want := `["ul",{"xmlns":"urn:l","xmlns:x":"urn:x","x:id":"1"},["li","one"]," ",["li",{"class":"b"},"two"],["x:li"]]`
+	if string(b) != want {
+		t.Errorf("ToJsonML(): got %s, wanted %s", b, want)
+	}
+
+	n, err := ParseJsonML(b)
+	if err != nil {
+		t.Fatalf("ParseJsonML(): %s", err)
+	}
+	ul.Children[2].Children[1].Remove() // The comment has no JsonML form.
+	if got, want := n.String(), ul.String(); got != want {
+		t.Errorf("ParseJsonML(): got %s, wanted %s", got, want)
+	}
+
+	for _, bad := range []string{`[]`, `[1]`, `["a",{"b":[]}]`, `["a",null]`, `["a"] 1`} {
+		if _, err := ParseJsonML([]byte(bad)); err == nil {
+			t.Errorf("ParseJsonML(%s): expected an error", bad)
+		}
+	}
+
+	src := `["a",{"v":"a\"b&c"},"t<u",["b"]," & v"]`
+	m, err := ParseJsonML([]byte(src))
+	if err != nil {
+		t.Fatalf("ParseJsonML(): %s", err)
+	}
+	esc := New()
+	if err := esc.LoadString(m.String(), nil); err != nil {
+		t.Fatalf("LoadString(%s): %s", m.String(), err)
+	}
+	if b, _ := esc.SelectNode("", "a").ToJsonML(); string(b) != src {
+		t.Errorf("ParseJsonML(): got %s back, wanted %s", b, src)
+	}
+
+	a := &Attr{Name: xml.Name{Local: "n"}, Value: "stored", Func: func(*Node) string { return "computed" }}
+	n.Attributes = []*Attr{a}
+	if b, _ := n.ToJsonML(); !strings.HasPrefix(string(b), `["ul",{"n":"computed"}`) || a.Value != "stored" {
+		t.Errorf("ToJsonML(): got %s and attribute value %q", b, a.Value)
+	}
+}
+
+func TestJSONNames(t *testing.T) {
+	tests := []struct{ in, camel, snake string }{
+		{"OrderLine", "orderLine", "order_line"},
+		{"order-line", "orderLine", "order_line"},
+		{"customerID", "customerId", "customer_id"},
+		{"XMLHttpRequest", "xmlHttpRequest", "xml_http_request"},
+		{"cbc:ID", "cbc:id", "cbc:id"},
+	}
+	for _, tt := range tests {
+		if got := CamelCase(tt.in); got != tt.camel {
+			t.Errorf("CamelCase(%q): got %q, wanted %q", tt.in, got, tt.camel)
+		}
+		if got := SnakeCase(tt.in); got != tt.snake {
+			t.Errorf("SnakeCase(%q): got %q, wanted %q", tt.in, got, tt.snake)
+		}
+	}
+
+	doc := New()
+	if err := doc.LoadString(`<Order xmlns:cbc="urn:cbc" Order-ID="7"><cbc:LineCount>2</cbc:LineCount></Order>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	names := &JSONNames{
+		ToJSON:   func(name string) string { return CamelCase(StripPrefix(name)) },
+		FromJSON: func(key string) string { return strings.Title(key) },
+	}
+	order := doc.SelectNode("", "Order")
+
+	var v struct {
+		ID        string `json:"@orderId"`
+		LineCount string `json:"lineCount"`
+	}
+	if err := names.Decode(order, &v); err != nil {
+		t.Fatalf("Decode(): %s", err)
+	}
+	if v.ID != "7" || v.LineCount != "2" {
+		t.Errorf("Decode(): got %+v", v)
+	}
+	if b, _ := order.ToJSON(); string(b) != `{"@Order-ID":"7","cbc:LineCount":"2"}` {
+		t.Errorf("ToJSON(): names mapped without JSONNames: %s", b)
+	}
+
+	types := &JSONTypes{Types: map[string]string{"cbc:LineCount": "number"}, Names: names}
+	if b, _ := order.ToTypedJSON(types); string(b) != `{"@orderId":"7","lineCount":2}` {
+		t.Errorf("ToTypedJSON(): got %s", b)
+	}
+
+	n, err := names.NodeFromMap(map[string]interface{}{"order": map[string]interface{}{"@status": "open", "lineCount": 2}})
+	if err != nil {
+		t.Fatalf("NodeFromMap(): %s", err)
+	}
+	if got, want := n.String(), `<Order Status="open"><LineCount>2</LineCount></Order>`; got != want {
+		t.Errorf("NodeFromMap(): got %s, wanted %s", got, want)
+	}
+
+	b, err := names.ToJsonML(n)
+	if err != nil {
+		t.Fatalf("ToJsonML(): %s", err)
+	}
+	if want := `["order",{"status":"open"},["lineCount","2"]]`; string(b) != want {
+		t.Errorf("ToJsonML(): got %s, wanted %s", b, want)
+	}
+	if n, err = names.ParseJsonML(b); err != nil || n.String() != `<Order Status="open"><LineCount>2</LineCount></Order>` {
+		t.Errorf("ParseJsonML(): got %v, %v", n, err)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	doc := New()
+	doc.SaveDocType = false
+	if err := doc.LoadString(`<r xmlns:k="urn:k"><a/><k:b>x</k:b><c/></r>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	b := doc.SelectNode("k", "b")
+	w := b.Wrap("", "group")
+	if w.Parent == nil || b.Parent != w || doc.SaveString() != `<r xmlns:k="urn:k"><a /><group><k:b>x</k:b></group><c /></r>` {
+		t.Errorf("Wrap(): got %s", doc.SaveString())
+	}
+	if err := w.Unwrap(); err != nil {
+		t.Fatalf("Unwrap(): %s", err)
+	}
+	if got := doc.SaveString(); got != `<r xmlns:k="urn:k"><a /><k:b>x</k:b><c /></r>` || b.Parent.Name.Local != "r" {
+		t.Errorf("Unwrap(): got %s", got)
+	}
+
+	if err := doc.LoadString(`<r><ns xmlns="urn:d" xmlns:k="urn:k" a="1"><x/>t<k:y/></ns></r>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	if err := doc.SelectNode("*", "ns").Unwrap(); err != nil {
+		t.Fatalf("Unwrap(): %s", err)
+	}
+	if got := doc.SaveString(); got != `<r><x xmlns="urn:d" xmlns:k="urn:k" />t<k:y xmlns="urn:d" xmlns:k="urn:k" /></r>` {
+		t.Errorf("Unwrap(): got %s", got)
+	}
+
+	stray := NewNode(NT_ELEMENT)
+	stray.Parent = doc.SelectNode("*", "r") // Not in its Children.
+	if stray.Wrap("", "w") != nil || stray.Unwrap() == nil {
+		t.Errorf("Wrap(), Unwrap(): expected nil and an error for a node missing from Children")
+	}
+	if err := doc.Root.Unwrap(); err == nil {
+		t.Errorf("Unwrap(): expected an error for a node without parent")
+	}
+}
+
+func TestSpillAttrs(t *testing.T) {
+	big := strings.Repeat("QUJD", 64) + `&"`
+	src := strings.Repeat("QUJD", 64) + `&amp;&#34;`
+	doc := New()
+	doc.SaveDocType = false
+	doc.SpillSize = 16
+	doc.SpillDir = t.TempDir()
+	if err := doc.LoadString(`<r><img id="1" data="`+src+`"/></r>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	defer doc.RemoveSpilled()
+
+	img := doc.SelectNode("", "img")
+	a := img.Attributes[1]
+	if img.Attributes[0].Spilled() || !a.Spilled() || a.Value != "" || a.Size() != int64(len(big)) {
+		t.Errorf("Spilled(): expected only the large value to be spilled, got %v, %d", a.Spilled(), a.Size())
+	}
+	if img.As("", "data") != big {
+		t.Errorf("As(): expected the spilled value to be read back")
+	}
+
+	r, err := img.AttrReader("", "data")
+	if err != nil {
+		t.Fatalf("AttrReader(): %s", err)
+	}
+	b, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || string(b) != big {
+		t.Errorf("AttrReader(): got %q, %v", b, err)
+	}
+	if _, err := img.AttrReader("", "missing"); err == nil {
+		t.Errorf("AttrReader(): expected an error for a missing attribute")
+	}
+
+	if got := doc.SaveString(); got != `<r><img id="1" data="`+src+`" /></r>` {
+		t.Errorf("SaveString(): got %s", got)
+	}
+
+	plain := New()
+	if err := plain.LoadString(`<r><img id="1" data="`+src+`"/></r>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	if !bytes.Equal(img.Hash(), plain.SelectNode("", "img").Hash()) {
+		t.Errorf("Hash(): spilled value not hashed")
+	}
+	if err := Match(img, `<img id="1" data="`+src+`"/>`); err != nil {
+		t.Errorf("Match(): %s", err)
+	}
+
+	c := img.Clone()
+	img.SetAttr("data", "small")
+	if a.Spilled() || img.As("", "data") != "small" || !strings.Contains(img.String(), `data="small"`) {
+		t.Errorf("SetAttr(): spilled value not replaced: %s", img.As("", "data"))
+	}
+	if files, _ := ioutil.ReadDir(doc.SpillDir); len(files) != 1 || c.As("", "data") != big {
+		t.Errorf("SetAttr(): file shared by a clone removed")
+	}
+	c.SetAttrNS("", "data", "other")
+	if files, _ := ioutil.ReadDir(doc.SpillDir); len(files) != 0 {
+		t.Errorf("SetAttrNS(): file of the replaced value kept")
+	}
+
+	if err := doc.RemoveSpilled(); err != nil {
+		t.Fatalf("RemoveSpilled(): %s", err)
+	}
+	if files, _ := ioutil.ReadDir(doc.SpillDir); len(files) != 0 {
+		t.Errorf("RemoveSpilled(): %d files left", len(files))
+	}
+}
+
+func TestSpilledQueries(t *testing.T) {
+	doc := New()
+	doc.SpillSize = 4
+	doc.SpillDir = t.TempDir()
+	if err := doc.LoadString(`<r xmlns:p="urn:p-long"><e id="abcdefgh" p:k="valuevalue"/></r>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	defer doc.RemoveSpilled()
+
+	r := doc.SelectNode("", "r")
+	if r.Attributes[0].Spilled() || !doc.SelectNode("", "e").Attributes[0].Spilled() {
+		t.Fatalf("SpillSize: expected the id, not the namespace declaration, to be spilled")
+	}
+	if got := r.SelectNodesByAttr("", "e", "id", "abcdefgh"); len(got) != 1 {
+		t.Errorf("SelectNodesByAttr(): got %d nodes", len(got))
+	}
+	if got := r.SearchAttr(regexp.MustCompile("^value")); len(got) != 1 {
+		t.Errorf("SearchAttr(): got %d nodes", len(got))
+	}
+	if got := r.Find(`e[id="abcdefgh"]`); len(got) != 1 {
+		t.Errorf("Find(): got %d nodes", len(got))
+	}
+	if r.SelectNodeByPath(`e[@id='abcdefgh']`) == nil || MustCompileQuery("e/@p:k").Value(r) != "valuevalue" {
+		t.Errorf("SelectNodeByPath(), Query: spilled values not matched")
+	}
+	if n, err := doc.ResolvePointer("abcdefgh"); err != nil || n == nil || n.Name.Local != "e" {
+		t.Errorf("ResolvePointer(): got %v, %v", n, err)
+	}
+}
+
+func TestSortChildren(t *testing.T) {
+	doc := New()
+	doc.SaveDocType = false
+	if err := doc.LoadString(`<r><c n="10"/><!--x--><a n="9"/><b/><a n="2"/></r>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	r := doc.SelectNode("", "r")
+	r.SortChildren(ByName)
+	if got := doc.SaveString(); got != `<r><a n="9" /><!-- x --><a n="2" /><b /><c n="10" /></r>` {
+		t.Errorf("SortChildren(ByName): got %s", got)
+	}
+
+	r.SortChildren(ByAttr("", "n"))
+	if got := doc.SaveString(); got != `<r><a n="2" /><!-- x --><a n="9" /><c n="10" /><b /></r>` {
+		t.Errorf("SortChildren(ByAttr): got %s", got)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	src := "\xef\xbb\xbf" + `<feed xmlns:x="urn:x"><meta a="1"/><list><entry id="1"/><entry id="2"><x:v>b</x:v></entry><entry id="3"/></list></feed>`
+
+	sr, err := NewSubtreeReader(strings.NewReader(src), "feed/list/entry")
+	if err != nil {
+		t.Fatalf("NewSubtreeReader(): %s", err)
+	}
+	if n, err := sr.Next(); err != nil || n.As("", "id") != "1" {
+		t.Fatalf("Next(): %v, %v", n, err)
+	}
+	cp := sr.Checkpoint()
+	if !strings.HasPrefix(src[cp.Offset:], `<entry id="2">`) || len(cp.Stack) != 2 {
+		t.Fatalf("Checkpoint(): got %d, %q", cp.Offset, cp.Stack)
+	}
+
+	sr, err = ResumeSubtreeReader(strings.NewReader(src), "feed/list/entry", cp)
+	if err != nil {
+		t.Fatalf("ResumeSubtreeReader(): %s", err)
+	}
+	n, err := sr.Next()
+	if err != nil {
+		t.Fatalf("Next(): %s", err)
+	}
+	if got := n.String(); got != `<entry id="2" xmlns:x="urn:x"><x:v>b</x:v></entry>` {
+		t.Errorf("Next(): got %s", got)
+	}
+	if cp2 := sr.Checkpoint(); !strings.HasPrefix(src[cp2.Offset:], `<entry id="3"/>`) {
+		t.Errorf("Checkpoint(): got %d after resuming", cp2.Offset)
+	}
+	if n, err = sr.Next(); err != nil || n.As("", "id") != "3" {
+		t.Errorf("Next(): %v, %v", n, err)
+	}
+	if _, err = sr.Next(); err == nil {
+		t.Errorf("Next(): expected the end of the stream")
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	doc := New()
+	doc.SaveDocType = false
+	b := doc.Build().NS("p", "urn:p")
+	b.ElemNS("urn:o", "order").Attr("id", "1").
+		Elem("item").Text("x").End().
+		ElemNS("urn:p", "price").AttrNS("urn:p", "cur", "EUR").AttrNS("urn:q", "q", "1").Text("9").End().
+		ElemNS("", "note").Comment("c").End().
+		End()
+	if err := b.Err(); err != nil {
+		t.Fatalf("Err(): %s", err)
+	}
+
+	want := `<order xmlns="urn:o" id="1"><item>x</item>` +
+		`<p:price xmlns:p="urn:p" xmlns:ns1="urn:q" p:cur="EUR" ns1:q="1">9</p:price>` +
+		`<note xmlns=""><!-- c --></note></order>`
+	if got := doc.SaveString(); got != want {
+		t.Errorf("Build(): got %s", got)
+	}
+	if item := doc.SelectNode("urn:o", "item"); item == nil || item.NamespaceURI() != "urn:o" {
+		t.Errorf("Elem(): expected <item> in the parent's namespace")
+	}
+
+	if err := doc.Build().End().Elem("x").Err(); err == nil {
+		t.Errorf("End(): expected an error without an open element")
+	}
+	if err := doc.Build().Elem("").Err(); err == nil {
+		t.Errorf("Elem(): expected an error for an empty name")
+	}
+
+	val := "x\"y&<z' >\tw\n"
+	doc = New()
+	doc.SaveDocType = false
+	doc.Build().Elem("a").Attr("v", val).End()
+	if got, want := doc.SaveString(), `<a v="x&#34;y&amp;&lt;z&#39; &gt;&#x9;w&#xA;" />`; got != want {
+		t.Errorf("Attr(): got %s, wanted %s", got, want)
+	}
+	again := New()
+	if err := again.LoadString(doc.SaveString(), nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	if got := again.SelectNode("", "a").As("", "v"); got != val {
+		t.Errorf("Attr(): got %q back, wanted %q", got, val)
+	}
+}
+
+func TestSetInnerXML(t *testing.T) {
+	doc := New()
+	doc.SaveDocType = false
+	if err := doc.LoadString(`<r xmlns:a="urn:a"><t>old<o/></t></r>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	n := doc.SelectNode("", "t")
+	if err := n.SetInnerXML(`<a:x k="1">v &amp; w</a:x>text<y/>`, nil); err != nil {
+		t.Fatalf("SetInnerXML(): %s", err)
+	}
+	if err := n.AppendRawXML(`<!--c--><z/>`); err != nil {
+		t.Fatalf("AppendRawXML(): %s", err)
+	}
+	want := `<r xmlns:a="urn:a"><t><a:x k="1">v &amp; w</a:x>text<y /><!-- c --><z /></t></r>`
+	if got := doc.SaveString(); got != want {
+		t.Errorf("SetInnerXML(): got %s", got)
+	}
+	if x := n.FirstChildElement(); x.Parent != n || x.NamespaceURI() != "urn:a" {
+		t.Errorf("SetInnerXML(): <a:x> not bound into the tree")
+	}
+
+	if err := n.SetInnerXML(`<?xml version="1.0" encoding="UTF-8"?><q/>`, nil); err != nil {
+		t.Errorf("SetInnerXML(): %s", err)
+	}
+	if err := n.SetInnerXML(`<open>`, nil); err == nil {
+		t.Errorf("SetInnerXML(): expected an error for a malformed fragment")
+	}
+	if got := n.String(); got != `<t><q /></t>` {
+		t.Errorf("SetInnerXML(): got %s after an error", got)
+	}
+}
+
+func TestSetProfile(t *testing.T) {
+	doc := New()
+	if err := doc.SetProfile(PROFILE_STRICT); err != nil {
+		t.Fatalf("SetProfile(): %s", err)
+	}
+	if err := doc.LoadString(`<r><p:x/></r>`, nil); err == nil {
+		t.Errorf("PROFILE_STRICT: expected an error for an unbound prefix")
+	}
+	if err := doc.LoadString(`<r xmlns:p="urn:p"><p:x p:a="1" xml:lang="en"/></r>`, nil); err != nil {
+		t.Errorf("PROFILE_STRICT: %s", err)
+	}
+	if err := doc.LoadString(`<r a="1" a="2"/>`, nil); err == nil {
+		t.Errorf("PROFILE_STRICT: expected an error for a duplicate attribute")
+	}
+
+	if err := doc.SetProfile(PROFILE_LENIENT); err != nil {
+		t.Fatalf("SetProfile(): %s", err)
+	}
+	if err := doc.LoadString(`<r><p:x/><br>&nbsp;</r>`, nil); err != nil {
+		t.Errorf("PROFILE_LENIENT: %s", err)
+	}
+
+	if err := doc.SetProfile(PROFILE_STRICT); err != nil {
+		t.Fatalf("SetProfile(): %s", err)
+	}
+	if err := doc.LoadString(`<a>&nbsp;</a>`, nil); err == nil {
+		t.Errorf("PROFILE_STRICT: HTML entities kept from PROFILE_LENIENT")
+	}
+	if err := doc.SetProfile(PROFILE_LENIENT); err != nil {
+		t.Fatalf("SetProfile(): %s", err)
+	}
+
+	doc.Entity["big"] = "x"
+	if err := doc.SetProfile(PROFILE_HARDENED); err != nil {
+		t.Fatalf("SetProfile(): %s", err)
+	}
+	if !doc.Strict || doc.DupAttrs != DUPATTR_ERROR || len(doc.Entity) != 0 ||
+		doc.MaxEntities != HardenedMaxEntities || doc.MaxSaveDepth != HardenedMaxSaveDepth {
+		t.Errorf("PROFILE_HARDENED: options not set")
+	}
+	if err := doc.LoadString(`<r>&big;</r>`, nil); err == nil {
+		t.Errorf("PROFILE_HARDENED: expected an error for an unknown entity")
+	}
+
+	if err := doc.SetProfile(PROFILE_HARDENED + 1); err == nil || doc.MaxEntities != HardenedMaxEntities {
+		t.Errorf("SetProfile(): expected an error for an unknown profile, leaving the options alone")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	doc := New()
+	if err := doc.LoadString(`<r xmlns:a="urn:a" id="7">
+  <a:status code="200">  all
+    good </a:status>
+  <item>1</item><item>2</item><!-- skipped -->
+  <tail/>
+</r>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	for _, pattern := range []string{
+		`<r id="..." xmlns:s="urn:a"><s:status code="200">all good</s:status>...<tail/></r>`,
+		`<r id="7"><any/>...</r>`,
+		`<r id="7">...<item>2</item>...</r>`,
+	} {
+		if err := Match(doc.Root, pattern); err != nil {
+			t.Errorf("Match(%s): %s", pattern, err)
+		}
+	}
+
+	for pattern, path := range map[string]string{
+		`<r id="8">...</r>`: "/r: attribute id",
+		`<r>...</r>`:        "/r: unexpected attribute id",
+		`<r id="7"><any/><item>1</item><item>3</item>...</r>`: "/r/item[2]/text(): expected text \"3\"",
+		`<r id="7">...<item>2</item></r>`:                     "/r/tail: unexpected <tail>",
+		`<r id="7">...<missing/></r>`:                         "/r: missing <missing>",
+	} {
+		err := Match(doc.Root, pattern)
+		if err == nil || !strings.HasPrefix(err.Error(), "xmlx: "+path) {
+			t.Errorf("Match(%s): got %v, expected %s", pattern, err, path)
+		}
+	}
+}
+
+func TestNodeFromValue(t *testing.T) {
+	type order struct {
+		XMLName xml.Name `xml:"urn:o order"`
+		ID      int      `xml:"id,attr"`
+		Items   []string `xml:"item"`
+	}
+
+	n, err := NodeFromValue(order{ID: 7, Items: []string{"a", "b"}})
+	if err != nil {
+		t.Fatalf("NodeFromValue(): %s", err)
+	}
+	if n.Parent != nil || n.NamespaceURI() != "urn:o" {
+		t.Errorf("NodeFromValue(): got parent %v, namespace %q", n.Parent, n.NamespaceURI())
+	}
+
+	doc := New()
+	doc.SaveDocType = false
+	doc.Envelope("", "envelope").AddChild(n)
+	if got := doc.SaveString(); got != `<envelope><order xmlns="urn:o" id="7"><item>a</item><item>b</item></order></envelope>` {
+		t.Errorf("NodeFromValue(): got %s", got)
+	}
+
+	var back order
+	if err := n.Unmarshal(&back); err != nil || back.ID != 7 || len(back.Items) != 2 {
+		t.Errorf("Unmarshal(): got %+v, %v", back, err)
+	}
+
+	if _, err := NodeFromValue([]order{{ID: 1}, {ID: 2}}); err == nil {
+		t.Errorf("NodeFromValue(): expected an error for several elements")
+	}
+	if _, err := NodeFromValue(make(chan int)); err == nil {
+		t.Errorf("NodeFromValue(): expected an error for a value that cannot be marshalled")
+	}
+}
+
+func TestResponse(t *testing.T) {
+	src := New()
+	if err := src.LoadString(`<list xmlns:p="urn:p"><p:item>a</p:item><plain/></list>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	r := OKResponse(src.SelectNode("p", "item"), src.SelectNode("", "plain"))
+	r.Code = 200
+	r.Page = &Pagination{Page: 2, Size: 20, Next: "/x?page=3"}
+	doc := r.Document()
+	doc.SaveDocType = false
+	want := `<response xmlns="urn:xmlx:response" status="ok" code="200">` +
+		`<pagination page="2" size="20" next="/x?page=3" />` +
+		`<data><p:item xmlns:p="urn:p" xmlns="">a</p:item><plain xmlns:p="urn:p" xmlns="" /></data></response>`
+	if got := doc.SaveString(); got != want {
+		t.Errorf("Document(): got %s", got)
+	}
+	if len(src.SelectNode("", "list").Children) != 2 {
+		t.Errorf("Document(): payload removed from its tree")
+	}
+
+	doc = ErrorResponse(422, ResponseError{Code: "E_RANGE", Field: "qty", Message: "out of range"}).Document()
+	doc.SaveDocType = false
+	want = `<response xmlns="urn:xmlx:response" status="error" code="422">` +
+		`<errors><error code="E_RANGE" field="qty">out of range</error></errors></response>`
+	if got := doc.SaveString(); got != want {
+		t.Errorf("Document(): got %s", got)
+	}
+
+	e := ResponseError{Code: `E&"Q"`, Field: "a<b", Message: `value "x" & <y> rejected`}
+	doc = ErrorResponse(400, e).Document()
+	again := New()
+	if err := again.LoadString(doc.SaveString(), nil); err != nil {
+		t.Fatalf("LoadString(%s): %s", doc.SaveString(), err)
+	}
+	got := again.SelectNode("*", "error")
+	if got == nil || got.As("", "code") != e.Code || got.As("", "field") != e.Field || got.GetValue() != e.Message {
+		t.Errorf("Document(): error not kept through a reload: %s", again.SaveString())
+	}
+}
+
+func TestStore(t *testing.T) {
+	src := `<?xml version="1.0"?>
+<!-- head -->
+<lib xmlns:b="urn:b"><b:book id="1"><title>Go</title></b:book><b:book id="2" b:lang="en"><title> XML </title><?pi x?></b:book></lib>`
+
+	doc := New()
+	if err := doc.LoadString(src, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	s, err := New().LoadStore(strings.NewReader(src), nil)
+	if err != nil {
+		t.Fatalf("LoadStore(): %s", err)
+	}
+
+	books := s.SelectNodesRecursive(s.Root(), "b", "book")
+	if len(books) != 2 || len(doc.SelectNodesRecursive("b", "book")) != 2 {
+		t.Fatalf("SelectNodesRecursive(): got %d nodes", len(books))
+	}
+	if len(s.SelectNodesRecursive(s.Root(), "urn:b", "book")) != 2 {
+		t.Errorf("SelectNodesRecursive(): namespace URI not matched")
+	}
+	if s.As(books[1], "*", "lang") != "en" || s.As(books[0], "", "id") != "1" || s.HasAttr(books[0], "b", "lang") {
+		t.Errorf("As(): wrong attribute values")
+	}
+	if got := s.S(books[1], "*", "title"); got != "XML" {
+		t.Errorf("S(): got %q", got)
+	}
+	if p := s.Parent(books[0]); s.Name(p).Local != "lib" || s.NextSibling(books[0]) != books[1] {
+		t.Errorf("Parent(), NextSibling(): wrong links")
+	}
+	if s.SelectNode(books[0], "*", "missing") != NoNode {
+		t.Errorf("SelectNode(): expected NoNode")
+	}
+
+	want := doc.SelectNodesRecursive("b", "book")[1].String()
+	if got := s.Node(books[1]).String(); got != want {
+		t.Errorf("Node(): got %s, expected %s", got, want)
+	}
+	if got, want := s.Node(s.Root()).String(), doc.Root.String(); got != want {
+		t.Errorf("Node(): got %s, expected %s", got, want)
+	}
+
+	if _, err := New().LoadStore(strings.NewReader(`<a><b>`), nil); err == nil {
+		t.Errorf("LoadStore(): expected an error for truncated input")
+	}
+
+	hardened := New()
+	if err := hardened.SetProfile(PROFILE_HARDENED); err != nil {
+		t.Fatalf("SetProfile(): %s", err)
+	}
+	_, err = hardened.LoadStore(strings.NewReader(src), nil)
+	if err == nil || !strings.Contains(err.Error(), "MaxEntities") || !strings.Contains(err.Error(), "StrictRoot") {
+		t.Errorf("LoadStore(): expected an error naming the unsupported options, got %v", err)
+	}
+}
+
+func TestNodeFromMap(t *testing.T) {
+	n, err := NodeFromMap(map[string]interface{}{
+		"order": map[string]interface{}{
+			"@id":      7,
+			"@xmlns:p": "urn:p",
+			"item":     []interface{}{"a", map[string]interface{}{"#text": "b", "@q": 2.5}},
+			"p:note":   nil,
+			"total":    []string{"1", "2"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NodeFromMap(): %s", err)
+	}
+	want := `<order xmlns:p="urn:p" id="7"><item>a</item><item q="2.5">b</item><p:note /><total>1</total><total>2</total></order>`
+	if got := n.String(); got != want {
+		t.Errorf("NodeFromMap(): got %s", got)
+	}
+
+	if _, err := NodeFromMap(map[string]interface{}{"a": 1, "b": 2}); err == nil {
+		t.Errorf("NodeFromMap(): expected an error for two roots")
+	}
+	if _, err := NodeFromMap(map[string]interface{}{"a": map[string]interface{}{"@x": []int{1}}}); err == nil {
+		t.Errorf("NodeFromMap(): expected an error for a non-text attribute")
+	}
+	for _, bad := range []map[string]interface{}{
+		{"a b": "x"},
+		{"a": map[string]interface{}{"1c": "x"}},
+		{"a": map[string]interface{}{"@v w": "x"}},
+		{"a": map[string]interface{}{"p:q:r": "x"}},
+	} {
+		if _, err := NodeFromMap(bad); err == nil {
+			t.Errorf("NodeFromMap(%v): expected an error for an invalid name", bad)
+		}
+	}
+
+	n, err = NodeFromMap(map[string]interface{}{"a": map[string]interface{}{"@v": `q"&`, "#text": "1 < 2 & 3", "b": "x"}})
+	if err != nil {
+		t.Fatalf("NodeFromMap(): %s", err)
+	}
+	want = `<a v="q&#34;&amp;">1 &lt; 2 &amp; 3<b>x</b></a>`
+	if got := n.String(); got != want {
+		t.Errorf("NodeFromMap(): got %s, wanted %s", got, want)
+	}
+	doc := New()
+	if err := doc.LoadString(n.String(), nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	if a := doc.SelectNode("", "a"); a.As("", "v") != `q"&` || a.Children[0].Value != "1 < 2 & 3" {
+		t.Errorf("NodeFromMap(): values not kept through a reload")
+	}
+}
+
+func TestTypedSetters(t *testing.T) {
+	doc := New()
+	doc.SaveDocType = false
+	if err := doc.LoadString(`<r><qty>1</qty></r>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	r := doc.SelectNode("", "r")
+	r.SetI64("", "qty", -3)
+	r.SetU64("", "max", 18446744073709551615)
+	r.SetF64("*", "price", 9.25)
+	r.SetBool("", "ok", true)
+	r.SetTime("", "at", time.RFC3339, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	r.SetS("", "name", "a<b")
+
+	want := `<r><qty>-3</qty><max>18446744073709551615</max><price>9.25</price><ok>true</ok><at>2024-05-01T12:00:00Z</at><name>a&lt;b</name></r>`
+	if got := doc.SaveString(); got != want {
+		t.Errorf("Set*(): got %s", got)
+	}
+	if r.I64("", "qty") != -3 || r.U64("", "max") != 18446744073709551615 || r.F64("", "price") != 9.25 || !r.B("", "ok") {
+		t.Errorf("Set*(): values do not read back")
+	}
+	if r.SetS("", "*", "x") == nil || r.SetS("x", "*", "y") != nil {
+		t.Errorf("SetS(): wrong result for name \"*\"")
+	}
+}
+
+func TestAddChildren(t *testing.T) {
+	old := NewNode(NT_ELEMENT)
+	old.Name.Local = "old"
+	r := NewNode(NT_ELEMENT)
+	r.Name.Local = "r"
+
+	nodes := make([]*Node, 300)
+	for i := range nodes {
+		nodes[i] = NewNode(NT_ELEMENT)
+		nodes[i].Name.Local = "n" + strconv.Itoa(i)
+	}
+	old.AddChild(nodes[0])
+
+	r.AddChildren(nodes...)
+	if len(r.Children) != 300 || len(old.Children) != 0 {
+		t.Fatalf("AddChildren(): got %d children, %d left in old parent", len(r.Children), len(old.Children))
+	}
+	for i, c := range r.Children {
+		if c != nodes[i] || c.Parent != r {
+			t.Fatalf("AddChildren(): wrong child or parent at %d", i)
+		}
+	}
+
+	p := NewNode(NT_ELEMENT)
+	p.Name.Local = "p"
+	a, b := nodes[0], nodes[1]
+	p.AddChildren(a, b, a) // As AddChild(a), AddChild(b), AddChild(a).
+	if got, want := p.String(), `<p><n1 /><n0 /></p>`; got != want {
+		t.Errorf("AddChildren(): got %s, wanted %s", got, want)
+	}
+	if len(r.Children) != 298 {
+		t.Errorf("AddChildren(): nodes not taken from their old parent")
+	}
+}
+
+func TestDeclareNamespace(t *testing.T) {
+	doc := New()
+	doc.SaveDocType = false
+	if err := doc.LoadString(`<r><a/></r>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	if err := doc.DeclareNamespace(nil, "p", "urn:p"); err != nil {
+		t.Fatalf("DeclareNamespace(): %s", err)
+	}
+	if doc.Namespaces["urn:p"] != "p" {
+		t.Errorf("DeclareNamespace(): Namespaces not updated")
+	}
+
+	b := NewNode(NT_ELEMENT)
+	b.Name = xml.Name{Space: "urn:p", Local: "b"}
+	b.SetAttrNS("urn:p", "k", "1")
+	doc.SelectNode("", "a").AddChild(b)
+	if got := doc.SaveString(); got != `<r xmlns:p="urn:p"><a><p:b p:k="1" /></a></r>` {
+		t.Errorf("DeclareNamespace(): got %s", got)
+	}
+
+	r := doc.SelectNode("", "r")
+	if err := r.DeclareNamespace("p", "urn:p"); err != nil {
+		t.Errorf("DeclareNamespace(): %s for the same declaration", err)
+	}
+	if err := r.DeclareNamespace("p", "urn:other"); err == nil {
+		t.Errorf("DeclareNamespace(): expected an error for a rebound prefix")
+	}
+	if err := r.DeclareNamespace("", "urn:d"); err == nil || len(r.Attributes) != 1 {
+		t.Errorf("DeclareNamespace(): expected an error and no change when <a> would change namespace")
+	}
+	if err := r.DeclareNamespace("xml", "urn:x"); err == nil {
+		t.Errorf("DeclareNamespace(): expected an error for a reserved prefix")
+	}
+}
+
+func TestCommentsAndProcInsts(t *testing.T) {
+	doc := New()
+	doc.SaveDocType = false
+	if err := doc.LoadString(`<r><?app v="1"?><a/></r>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	for _, inst := range []string{`type="text/xsl" href="a.xsl"`, `type="text/xsl" href="b.xsl"`} {
+		if _, err := doc.Root.SetProcInst("xml-stylesheet", inst); err != nil {
+			t.Fatalf("SetProcInst(): %s", err)
+		}
+	}
+	doc.Root.AddComment("generated -- do not edit")
+
+	r := doc.SelectNode("", "r")
+	if _, err := r.SetProcInst("app", `v="2"`); err != nil {
+		t.Fatalf("SetProcInst(): %s", err)
+	}
+	if _, err := r.AddProcInst("app", `v="3" a?>b`); err != nil {
+		t.Fatalf("AddProcInst(): %s", err)
+	}
+	r.AddComment("end")
+
+	for _, target := range []string{"", "xml", "XmL", "1a", "a b", "a?>"} {
+		if _, err := r.AddProcInst(target, "x"); err == nil {
+			t.Errorf("AddProcInst(%q): expected an error", target)
+		}
+		if _, err := r.SetProcInst(target, "x"); err == nil {
+			t.Errorf("SetProcInst(%q): expected an error", target)
+		}
+	}
+
+	want := `<?xml-stylesheet type="text/xsl" href="b.xsl"?><!-- generated - - do not edit -->` +
+		`<r><?app v="2"?><a /><?app v="3" a? >b?><!-- end --></r>`
+	if got := doc.SaveString(); got != want {
+		t.Errorf("SaveString(): got %s", got)
+	}
+	if len(r.ProcInsts("app")) != 2 || len(r.ProcInsts("*")) != 2 || len(r.Comments()) != 1 {
+		t.Errorf("ProcInsts(), Comments(): wrong counts")
+	}
+	if ss := doc.Stylesheets(); len(ss) != 1 || ss[0].Href != "b.xsl" {
+		t.Errorf("Stylesheets(): got %v", ss)
+	}
+	if r.ProcInst("missing") != nil {
+		t.Errorf("ProcInst(): expected nil")
+	}
+}
+
+func TestTypedGettersE(t *testing.T) {
+	doc := New()
+	if err := doc.LoadString(`<r><qty>12</qty><bad>1x</bad><price>2.5</price><ok>true</ok><big>-3</big><empty/></r>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	if n, err := doc.Root.I64E("", "qty"); n != 12 || err != nil {
+		t.Errorf("I64E(qty): got %d, %v", n, err)
+	}
+	if n, err := doc.Root.F64E("", "price"); n != 2.5 || err != nil {
+		t.Errorf("F64E(price): got %v, %v", n, err)
+	}
+	if b, err := doc.Root.BE("", "ok"); !b || err != nil {
+		t.Errorf("BE(ok): got %v, %v", b, err)
+	}
+	if n, err := doc.Root.I64E("", "missing"); n != 0 || err != nil {
+		t.Errorf("I64E(missing): got %d, %v", n, err)
+	}
+	if n, err := doc.Root.U64E("", "empty"); n != 0 || err != nil {
+		t.Errorf("U64E(empty): got %d, %v", n, err)
+	}
+
+	_, err := doc.Root.I64E("", "bad")
+	if err == nil || !strings.Contains(err.Error(), "/r/bad") {
+		t.Errorf("I64E(bad): got %v", err)
+	}
+	var ne *strconv.NumError
+	if !errors.As(err, &ne) || ne.Num != "1x" {
+		t.Errorf("I64E(bad): expected a *strconv.NumError, got %v", err)
+	}
+	if _, err := doc.Root.U64E("", "big"); err == nil {
+		t.Errorf("U64E(big): expected an error")
+	}
+	if _, err := doc.Root.BE("", "qty"); err == nil {
+		t.Errorf("BE(qty): expected an error")
+	}
+	if doc.Root.I64("", "bad") != 0 {
+		t.Errorf("I64(bad): expected 0")
+	}
+}
+
+func TestTimeGetters(t *testing.T) {
+	doc := New()
+	if err := doc.LoadString(`<r at="2024-03-01T10:00:00.5+01:00" day="01/03/2024" bad="soon">`+
+		`<a>2024-03-01T10:00:00Z</a><b>2024-03-01</b><c>2024-03-01T10:00:00</c><d>10:30:00</d><e>later</e></r>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+	r := doc.SelectNode("", "r")
+
+	want := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	if tm, err := r.T("", "a"); !tm.Equal(want) || err != nil {
+		t.Errorf("T(a): got %v, %v", tm, err)
+	}
+	if tm, err := r.T("", "b"); !tm.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) || err != nil {
+		t.Errorf("T(b): got %v, %v", tm, err)
+	}
+	if tm, err := r.T("", "c"); !tm.Equal(want) || err != nil {
+		t.Errorf("T(c): got %v, %v", tm, err)
+	}
+	if tm, err := r.T("", "d"); tm.Hour() != 10 || tm.Minute() != 30 || err != nil {
+		t.Errorf("T(d): got %v, %v", tm, err)
+	}
+	if tm, err := r.T("", "missing"); !tm.IsZero() || err != nil {
+		t.Errorf("T(missing): got %v, %v", tm, err)
+	}
+	if _, err := r.T("", "e"); err == nil || !strings.Contains(err.Error(), "/r/e") {
+		t.Errorf("T(e): got %v", err)
+	}
+	if _, err := r.T("", "a", "2006-01-02"); err == nil {
+		t.Errorf("T(a, layout): expected an error")
+	}
+
+	at := time.Date(2024, 3, 1, 9, 0, 0, 500000000, time.UTC)
+	if tm, err := r.At("", "at"); !tm.Equal(at) || err != nil {
+		t.Errorf("At(at): got %v, %v", tm, err)
+	}
+	if tm, err := r.At("", "day", "02/01/2006"); !tm.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) || err != nil {
+		t.Errorf("At(day): got %v, %v", tm, err)
+	}
+	if _, err := r.At("", "bad"); err == nil || !strings.Contains(err.Error(), "/r/@bad") {
+		t.Errorf("At(bad): got %v", err)
+	}
+}
+
+func TestDescendants(t *testing.T) {
+	doc := New()
+	if err := doc.LoadString(`<a><b><c/></b><d/><e><f/></e></a>`, nil); err != nil {
+		t.Fatalf("LoadString(): %s", err)
+	}
+
+	names := []string{}
+	for n := range doc.Root.Descendants() {
+		names = append(names, n.Name.Local)
+		if n.Name.Local == "d" {
+			n.Parent.RemoveChild(n.NextSibling())
+		}
+	}
+	if got, want := strings.Join(names, ","), "a,b,c,d"; got != want {
+		t.Errorf("Descendants(): got %s, wanted %s", got, want)
+	}
+
+	names = names[:0]
+	for n := range doc.SelectNode("", "a").ChildrenSeq() {
+		names = append(names, n.Name.Local)
+		break
+	}
+	if got, want := strings.Join(names, ","), "b"; got != want {
+		t.Errorf("ChildrenSeq(): got %s, wanted %s", got, want)
+	}
+}
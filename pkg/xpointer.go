@@ -0,0 +1,217 @@
+// This work is subject to the CC0 1.0 Universal (CC0 1.0) Public Domain Dedication
+// license. Its contents can be found at:
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package xmlx
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+)
+
+// xmlURL is the namespace the xml: prefix is bound to. encoding/xml resolves
+// attributes like xml:id to this namespace.
+const xmlURL = "http://www.w3.org/XML/1998/namespace"
+
+// ResolvePointer returns the node addressed by the given XPointer. The
+// leading '#' is optional. Supported forms are:
+//
+//   chapter2                    shorthand pointer; the element with that ID.
+//   element(chapter2/1/3)       child sequence starting at an ID.
+//   element(/1/2)               child sequence starting at the document.
+//   xpointer(id('chapter2'))    ID lookup through the xpointer() scheme.
+//   xpointer(/book/chapter[2])  absolute location path with positions.
+//
+// Multiple scheme parts may be given (e.g. "element(a)element(/1)"); they are
+// tried in order and the first one that locates a node wins, as the XPointer
+// framework prescribes. xmlns() parts are accepted and ignored.
+func (this *Document) ResolvePointer(ptr string) (*Node, error) {
+  if this.Root == nil {
+    return nil, fmt.Errorf("xpointer: document is empty")
+  }
+  return resolvePointer(this.Root, strings.TrimPrefix(ptr, "#"))
+}
+
+func resolvePointer(root *Node, ptr string) (*Node, error) {
+  if ptr == "" {
+    return nil, fmt.Errorf("xpointer: empty pointer")
+  }
+
+  if !strings.Contains(ptr, "(") {
+    if n := findID(root, ptr); n != nil {
+      return n, nil
+    }
+    return nil, fmt.Errorf("xpointer: no element with id %q", ptr)
+  }
+
+  parts, err := splitPointerParts(ptr)
+  if err != nil {
+    return nil, err
+  }
+
+  for _, p := range parts {
+    var n *Node
+    switch p[0] {
+    case "xmlns":
+      continue
+    case "element":
+      n, err = pointerElement(root, p[1])
+    case "xpointer":
+      n, err = pointerXPointer(root, p[1])
+    default:
+      err = fmt.Errorf("xpointer: unsupported scheme %q", p[0])
+    }
+    if err != nil {
+      return nil, err
+    }
+    if n != nil {
+      return n, nil
+    }
+  }
+
+  return nil, fmt.Errorf("xpointer: %q does not identify a node", ptr)
+}
+
+// splitPointerParts splits a scheme based pointer into (scheme, data) pairs,
+// honouring nested and ^-escaped parentheses in the scheme data.
+func splitPointerParts(ptr string) ([][2]string, error) {
+  var parts [][2]string
+
+  for ptr = strings.TrimSpace(ptr); ptr != ""; ptr = strings.TrimSpace(ptr) {
+    open := strings.IndexByte(ptr, '(')
+    if open < 1 {
+      return nil, fmt.Errorf("xpointer: malformed pointer part %q", ptr)
+    }
+
+    var data strings.Builder
+    depth, end := 1, -1
+    for i := open + 1; i < len(ptr) && end < 0; i++ {
+      switch c := ptr[i]; c {
+      case '^':
+        if i+1 < len(ptr) {
+          i++
+          data.WriteByte(ptr[i])
+        }
+      case '(':
+        depth++
+        data.WriteByte(c)
+      case ')':
+        if depth--; depth == 0 {
+          end = i
+        } else {
+          data.WriteByte(c)
+        }
+      default:
+        data.WriteByte(c)
+      }
+    }
+    if end < 0 {
+      return nil, fmt.Errorf("xpointer: unbalanced parentheses in %q", ptr)
+    }
+
+    parts = append(parts, [2]string{strings.TrimSpace(ptr[:open]), data.String()})
+    ptr = ptr[end+1:]
+  }
+
+  return parts, nil
+}
+
+// pointerElement evaluates the data of an element() scheme part.
+func pointerElement(root *Node, data string) (*Node, error) {
+  steps := strings.Split(data, "/")
+
+  cn := root
+  if steps[0] != "" {
+    if cn = findID(root, steps[0]); cn == nil {
+      return nil, nil
+    }
+  }
+
+  for _, s := range steps[1:] {
+    i, err := strconv.Atoi(s)
+    if err != nil || i < 1 {
+      return nil, fmt.Errorf("xpointer: invalid child sequence step %q", s)
+    }
+    if cn = nthChildElement(cn, "*", i); cn == nil {
+      return nil, nil
+    }
+  }
+
+  if cn == root {
+    return nil, fmt.Errorf("xpointer: empty child sequence")
+  }
+  return cn, nil
+}
+
+// pointerXPointer evaluates the small subset of the xpointer() scheme this
+// package understands: id('x') and absolute paths of named steps with an
+// optional positional predicate.
+func pointerXPointer(root *Node, data string) (*Node, error) {
+  data = strings.TrimSpace(data)
+
+  if strings.HasPrefix(data, "id(") && strings.HasSuffix(data, ")") {
+    id := strings.Trim(strings.TrimSpace(data[3:len(data)-1]), `'"`)
+    return findID(root, id), nil
+  }
+
+  if !strings.HasPrefix(data, "/") {
+    return nil, fmt.Errorf("xpointer: unsupported expression %q", data)
+  }
+
+  cn := root
+  for _, s := range strings.Split(data[1:], "/") {
+    name, pos := s, 1
+    if i := strings.IndexByte(s, '['); i > -1 && strings.HasSuffix(s, "]") {
+      var err error
+      if pos, err = strconv.Atoi(s[i+1 : len(s)-1]); err != nil || pos < 1 {
+        return nil, fmt.Errorf("xpointer: unsupported predicate in %q", s)
+      }
+      name = s[:i]
+    }
+    if i := strings.IndexByte(name, ':'); i > -1 {
+      name = name[i+1:]
+    }
+    if cn = nthChildElement(cn, name, pos); cn == nil {
+      return nil, nil
+    }
+  }
+
+  return cn, nil
+}
+
+// nthChildElement returns the n-th (1-based) element child of cn with the
+// given local name, or nil.
+func nthChildElement(cn *Node, name string, n int) *Node {
+  for _, v := range cn.Children {
+    if v.Type != NT_ELEMENT || (name != "*" && v.Name.Local != name) {
+      continue
+    }
+    if n--; n == 0 {
+      return v
+    }
+  }
+  return nil
+}
+
+// findID returns the first element carrying an xml:id or id attribute with
+// the given value.
+func findID(cn *Node, id string) *Node {
+  if cn.Type == NT_ELEMENT {
+    for _, a := range cn.Attributes {
+      if a.Name.Local != "id" || a.value() != id {
+        continue
+      }
+      if a.Name.Space == "" || a.Name.Space == "xml" || a.Name.Space == xmlURL {
+        return cn
+      }
+    }
+  }
+
+  for _, v := range cn.Children {
+    if n := findID(v, id); n != nil {
+      return n
+    }
+  }
+  return nil
+}